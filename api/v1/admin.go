@@ -0,0 +1,107 @@
+package v1
+
+// 当前管理员的角色和权限
+type AdminInfoResp struct {
+	UserId      int64    `json:"user_id"`
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+}
+
+// 按用户ID、邮箱或手机号查找，优先级依次降低
+type AdminUserQuery struct {
+	UserId int64  `json:"user_id" form:"user_id"`
+	Email  string `json:"email" form:"email"`
+	Phone  string `json:"phone" form:"phone"`
+}
+
+type AdminUserResp struct {
+	UserId        int64         `json:"user_id"`
+	Phone         string        `json:"phone"`
+	Email         string        `json:"email"`
+	NickName      string        `json:"nick_name"`
+	Avatar        string        `json:"avatar"`
+	Status        int           `json:"status"`       //用户状态 0封禁 1正常 2已注销
+	SilentFlag    int           `json:"silent_flag"`  //禁言标识 1禁言
+	SilentUntil   int64         `json:"silent_until"` //禁言截止时间，0为永久
+	EmailVerified bool          `json:"email_verified"`
+	PhoneVerified bool          `json:"phone_verified"`
+	RegisteredAt  int64         `json:"registered_at"`
+	Online        bool          `json:"online"`   //websocket是否在线
+	Sessions      []SessionResp `json:"sessions"` //登录设备
+}
+
+// 封禁、解封、强制下线
+type AdminUserReq struct {
+	UserId int64  `json:"user_id" binding:"required" example:"123456"`
+	Reason string `json:"reason" binding:"max=255" example:"发布违规内容"` //操作原因，记录在操作日志
+}
+
+type AdminGroupMember struct {
+	UserId   int64  `json:"user_id"`
+	NickName string `json:"nick_name"`
+	Avatar   string `json:"avatar"`
+	Status   int    `json:"status"`
+}
+
+type AdminGroupResp struct {
+	ConversationId int64              `json:"conversation_id"`
+	Member         int                `json:"member"`          //成员数
+	Avatar         string             `json:"avatar"`          //群组头像
+	Announcement   string             `json:"announcement"`    //群公告
+	RecentMsgTime  int64              `json:"recent_msg_time"` //最新消息时间
+	Status         int                `json:"status"`          //0正常 1已解散
+	CreatedAt      int64              `json:"created_at"`
+	Members        []AdminGroupMember `json:"members,omitempty"` //只在查看详情时返回
+}
+
+type AdminGroupListResp struct {
+	Total int64            `json:"total"`
+	Rows  []AdminGroupResp `json:"rows"`
+}
+
+// 合规审查，按seq升序返回seq之后的消息，包含已屏蔽和撤回的
+type AdminMsgListReq struct {
+	Seq   int64 `json:"seq" form:"seq"`
+	Limit int   `json:"limit" form:"limit"`
+}
+
+type AnnouncementReq struct {
+	Title   string `json:"title" binding:"required,max=128" example:"系统维护通知"`
+	Content string `json:"content" binding:"required,max=2000" example:"今晚23:00-24:00进行系统维护"`
+}
+
+type AnnouncementResp struct {
+	Id        int64  `json:"id"`
+	Title     string `json:"title"`
+	Content   string `json:"content"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+type MsgMinuteCount struct {
+	Minute int64 `json:"minute"` //该分钟开始的时间戳
+	Count  int64 `json:"count"`
+}
+
+// 连接数为当前节点，消息数为所有节点
+type AdminStatsResp struct {
+	NodeId          string           `json:"node_id"`
+	Connections     int              `json:"connections"`      //本节点连接数
+	Buckets         []int            `json:"buckets"`          //ConnMgr每个桶的连接数
+	DroppedFrames   uint64           `json:"dropped_frames"`   //慢连接丢弃的帧数
+	SlowDisconnects uint64           `json:"slow_disconnects"` //慢连接被断开的次数
+	MsgPerMinute    []MsgMinuteCount `json:"msg_per_minute"`   //最近每分钟的消息数，按时间升序
+}
+
+// 分配角色
+type SaveAdminReq struct {
+	UserId int64  `json:"user_id" binding:"required" example:"123456"`
+	Role   string `json:"role" binding:"required" example:"operator"`
+}
+
+type AdminResp struct {
+	UserId    int64  `json:"user_id"`
+	Role      string `json:"role"`
+	CreatedBy int64  `json:"created_by"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
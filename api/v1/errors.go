@@ -5,16 +5,45 @@ var (
 	ErrSuccess             = newError(0, "ok")
 	ErrBadRequest          = newError(400, "Bad Request")
 	ErrUnauthorized        = newError(401, "Unauthorized")
+	ErrForbidden           = newError(403, "Forbidden")
 	ErrNotFound            = newError(404, "Not Found")
 	ErrInternalServerError = newError(500, "Internal Server Error")
+	ErrTooManyRequests     = newError(429, "Too Many Requests")
 
 	// more biz errors
 	ErrEmailAlreadyUse      = newError(1001, "The email is already in use.")
 	ErrGenerateFromPassword = newError(1002, "密码加密异常")
 	ErrGenerateUserID       = newError(1003, "创建用户ID失败")
 	ErrPasswordFailed       = newError(1004, "账号密码错误")
+	ErrPhoneAlreadyUse      = newError(1005, "手机号已注册")
+	ErrVerifyCodeInvalid    = newError(1006, "验证码错误或已过期")
+	ErrVerifyCodeFrequent   = newError(1007, "验证码发送过于频繁")
+	ErrTokenInvalid         = newError(1008, "链接无效或已过期")
+	ErrNotVerified          = newError(1009, "请先验证邮箱或手机号")
+	ErrLoginTooFrequent     = newError(1010, "登录尝试过于频繁，请稍后再试")
+	ErrAccountLocked        = newError(1011, "登录失败次数过多，账号已临时锁定")
+	ErrProfileFieldInvalid  = newError(1012, "资料字段不支持或格式错误")
+	ErrUserBanned           = newError(1013, "账号已被封禁")
 
 	// 申请关系
 	ErrAddApplyFriendshipFailed = newError(2001, "申请失败")
 	ErrCreateRelationshipFailed = newError(2002, "添加好友失败")
+	ErrBlocked                  = newError(2003, "已被对方拉黑或已拉黑对方")
+	ErrTagExists                = newError(2004, "标签名已存在")
+	ErrTagLimit                 = newError(2005, "标签数量已达上限")
+
+	// 聊天
+	ErrSendTooFrequent = newError(3001, "发送过于频繁，请稍后再试")
+	ErrDuplicateMsg    = newError(3002, "请勿重复发送相同内容")
+	ErrMsgRejected     = newError(3003, "消息包含违规内容，发送失败")
+	ErrUserMuted       = newError(3004, "你已被禁言")
+
+	// 举报
+	ErrReportDuplicate = newError(4001, "已举报过，请等待处理")
+	ErrReportHandled   = newError(4002, "举报已处理")
+	ErrReportAction    = newError(4003, "处理动作不适用于该举报对象")
+
+	// 管理后台
+	ErrAdminRoleInvalid = newError(5001, "角色不存在")
+	ErrAdminSelf        = newError(5002, "不能修改自己的角色")
 )
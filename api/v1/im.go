@@ -19,6 +19,7 @@ type SendMsgResp struct {
 	Seq            int64  `json:"seq"`
 	SendTime       int64  `json:"send_time"` //发送时间
 	CreatedAt      int64  `json:"created_at"`
+	TargetRequest  bool   `json:"-"` //接收者的单聊会话是消息请求，不实时推送给接收者
 }
 
 type ConversationResp struct {
@@ -28,6 +29,7 @@ type ConversationResp struct {
 	LastReadSeq    int64  `json:"last_read_seq"`   //此会话用户已读的最后一条消息
 	NotifyType     int    `json:"notify_type"`     //会话收到消息的提醒类型，0未屏蔽，正常提醒 1屏蔽 2强提醒
 	IsTop          int    `json:"is_top"`          //会话是否被置顶展示
+	RequestStatus  int    `json:"request_status"`  //消息请求状态 0正常会话 1陌生人消息待处理 2已忽略
 
 	RecentMsg SendMsgResp              `json:"recent_msg"` //此会话最新产生的消息
 	UserList  []GetProfileResponseData `json:"user_list"`  //此会话的用户列表
@@ -52,3 +54,9 @@ type ConversationMsgListReq struct {
 	PageNum  int   `json:"page_num" binding:"required" example:"1"`
 	PageSize int   `json:"page_size" binding:"required" example:"10"`
 }
+
+// 处理陌生人消息请求
+type MsgRequestReq struct {
+	UserId         int64 `json:"user_id"`                                             //用户ID
+	ConversationId int64 `json:"conversation_id" binding:"required" example:"123456"` //会话ID
+}
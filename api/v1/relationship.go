@@ -12,13 +12,97 @@ type RelationshipRequest struct {
 	UserId           int64  `json:"user_id"`                                          //用户id 拥有者
 	TargetId         int64  `json:"target_id" binding:"required" example:"1"`         //用户id 对方
 	Remark           string `json:"remark"`                                           //验证信息
-	RelationshipType int    `json:"relationship_type" binding:"required" example:"1"` //关系类型  1好友 2关注
+	RelationshipType int    `json:"relationship_type" binding:"required" example:"1"` //关系类型  1好友 2关注 3黑名单
 	Status           int    `json:"status"`                                           //状态 1正常 2拉黑 3删除
 	Extra            string `json:"extra"`                                            //其他信息
 }
 
 type RelationshipListReq struct {
-	RelationshipType int `json:"relationship_type" binding:"required" example:"1"` //关系类型  1好友 2关注
-	PageNum          int `json:"page_num"`
-	PageSize         int `json:"page_size"`
+	RelationshipType int   `json:"relationship_type" binding:"required" example:"1"` //关系类型  1好友 2关注 3黑名单
+	TagId            int64 `json:"tag_id"`                                           //按分组标签筛选，0不筛选
+	PageNum          int   `json:"page_num"`
+	PageSize         int   `json:"page_size"`
+}
+
+type BlockRequest struct {
+	UserId   int64 `json:"user_id"`                                  //用户id 拉黑人
+	TargetId int64 `json:"target_id" binding:"required" example:"1"` //用户id 被拉黑的人
+}
+
+// 取消关注
+type FollowRequest struct {
+	UserId   int64 `json:"user_id"`                                  //用户id 关注人
+	TargetId int64 `json:"target_id" binding:"required" example:"1"` //用户id 被关注的人
+}
+
+type FollowListReq struct {
+	Cursor   int64 `json:"cursor" form:"cursor"`       //上一页返回的next_cursor，第一页传0
+	PageSize int   `json:"page_size" form:"page_size"` //每页数量
+}
+
+type FollowItem struct {
+	UserId    int64  `json:"user_id"`    //对方用户id
+	Remark    string `json:"remark"`     //备注
+	Mutual    bool   `json:"mutual"`     //是否互相关注
+	CreatedAt int64  `json:"created_at"` //关注时间
+}
+
+type FollowListResp struct {
+	Rows       []FollowItem `json:"rows"`
+	NextCursor int64        `json:"next_cursor"` //下一页游标，0表示没有更多
+}
+
+type FollowCountReq struct {
+	UserId int64 `json:"user_id" form:"user_id"` //不传则查询自己
+}
+
+type FollowCountResp struct {
+	UserId    int64 `json:"user_id"`
+	Following int64 `json:"following"` //关注数
+	Followers int64 `json:"followers"` //粉丝数
+}
+
+type TagRequest struct {
+	UserId int64  `json:"user_id"`                                     //用户id 拥有者
+	TagId  int64  `json:"tag_id"`                                      //标签id，新建时不传
+	Name   string `json:"name" binding:"required,max=32" example:"家人"` //标签名
+}
+
+type DelTagRequest struct {
+	UserId int64 `json:"user_id"`                               //用户id 拥有者
+	TagId  int64 `json:"tag_id" binding:"required" example:"1"` //标签id
+}
+
+type TagResp struct {
+	TagId int64  `json:"tag_id"`
+	Name  string `json:"name"`
+}
+
+type SetRelationshipTagsRequest struct {
+	UserId   int64   `json:"user_id"`                                  //用户id 拥有者
+	TargetId int64   `json:"target_id" binding:"required" example:"1"` //好友id
+	TagIds   []int64 `json:"tag_ids"`                                  //好友所属的全部标签，空则移出所有标签
+}
+
+type ContactSyncReq struct {
+	Version  int64 `json:"version" form:"version"`     //客户端本地的通讯录版本号，首次同步传0
+	PageSize int   `json:"page_size" form:"page_size"` //每页数量
+}
+
+type ContactItem struct {
+	TargetId         int64   `json:"target_id"`         //用户id 对方
+	Remark           string  `json:"remark"`            //对方的别名备注
+	RelationshipType int     `json:"relationship_type"` //关系类型  1好友 2关注 3黑名单
+	Status           int     `json:"status"`            //状态 1正常 2拉黑 3删除
+	Extra            string  `json:"extra"`             //其他信息
+	TagIds           []int64 `json:"tag_ids"`           //所属分组标签
+	Deleted          bool    `json:"deleted"`           //已删除，客户端移除本地记录
+	Version          int64   `json:"version"`
+}
+
+type ContactSyncResp struct {
+	Rows    []ContactItem `json:"rows"`
+	Tags    []TagResp     `json:"tags"`     //全部分组标签
+	Version int64         `json:"version"`  //本次同步到的版本号，下次请求传入
+	HasMore bool          `json:"has_more"` //还有更多变更，需继续同步
 }
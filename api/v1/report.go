@@ -0,0 +1,57 @@
+package v1
+
+type CreateReportReq struct {
+	UserId      int64  `json:"user_id"`                                                 //举报人ID
+	TargetType  int    `json:"target_type" binding:"required,oneof=1 2 3" example:"1"`  //举报对象 1消息 2用户 3群组
+	TargetId    int64  `json:"target_id" binding:"required" example:"123456"`           //消息ID、用户ID或群组会话ID
+	Reason      int    `json:"reason" binding:"required,oneof=1 2 3 4 5 9" example:"1"` //举报原因 1垃圾广告 2骚扰辱骂 3色情低俗 4违法违规 5诈骗 9其他
+	Description string `json:"description" binding:"max=500" example:"反复发送广告链接"`        //补充说明
+}
+
+type ReportResp struct {
+	Id             int64  `json:"id"`
+	ReporterId     int64  `json:"reporter_id"`      //举报人ID
+	TargetType     int    `json:"target_type"`      //举报对象 1消息 2用户 3群组
+	TargetId       int64  `json:"target_id"`        //对象ID
+	ReportedUserId int64  `json:"reported_user_id"` //被举报的用户，举报消息时为发送者
+	Reason         int    `json:"reason"`           //举报原因
+	Description    string `json:"description"`      //补充说明
+	Content        string `json:"content"`          //被举报消息的内容快照，只对管理员返回
+	Status         int    `json:"status"`           //状态 0待处理 1已处理 2已驳回
+	Action         int    `json:"action"`           //处理动作 0不处理 1屏蔽消息 2禁言 3封禁
+	Note           string `json:"note"`             //处理备注，只对管理员返回
+	CreatedAt      int64  `json:"created_at"`
+	HandledAt      int64  `json:"handled_at"`
+}
+
+// 审核队列筛选
+type ReportListReq struct {
+	Status     int   `json:"status" form:"status"`           //状态 0待处理 1已处理 2已驳回，-1全部
+	TargetType int   `json:"target_type" form:"target_type"` //举报对象，0不筛选
+	TargetId   int64 `json:"target_id" form:"target_id"`     //对象ID，0不筛选
+	PageNum    int   `json:"page_num" form:"page_num"`
+	PageSize   int   `json:"page_size" form:"page_size"`
+}
+
+type ReportListResp struct {
+	Total int64        `json:"total"`
+	Rows  []ReportResp `json:"rows"`
+}
+
+// 处理举报，action为0时驳回
+type ResolveReportReq struct {
+	HandlerId   int64  `json:"handler_id"`                                   //处理人ID
+	ReportId    int64  `json:"report_id" binding:"required" example:"1"`     //举报ID
+	Action      int    `json:"action" binding:"oneof=0 1 2 3" example:"1"`   //处理动作 0驳回 1屏蔽消息 2禁言 3封禁
+	MuteSeconds int64  `json:"mute_seconds" binding:"min=0" example:"86400"` //禁言时长，0为永久
+	Note        string `json:"note" binding:"max=500" example:"已核实，屏蔽该消息"`   //处理备注，只对管理员可见
+}
+
+// 推送给举报人的处理结果
+type ReportResultNotify struct {
+	ReportId   int64 `json:"report_id"`
+	TargetType int   `json:"target_type"`
+	TargetId   int64 `json:"target_id"`
+	Status     int   `json:"status"` //1已处理 2已驳回
+	Action     int   `json:"action"`
+}
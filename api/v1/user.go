@@ -5,38 +5,175 @@ type RegisterRequest struct {
 	Password string `json:"password" binding:"required" example:"123456"`
 }
 
+type SendCodeRequest struct {
+	Phone string `json:"phone" binding:"required,len=11,numeric" example:"13800138000"`
+	Scene string `json:"scene" binding:"required,oneof=register login" example:"login"` //register注册 login登录
+}
+
+// 发送到当前账号已绑定的手机号
+type SendAccountCodeRequest struct {
+	Scene string `json:"scene" binding:"required,oneof=update_account delete_account" example:"update_account"` //update_account修改邮箱、手机号、密码 delete_account注销账号
+}
+
+type PhoneRegisterRequest struct {
+	Phone    string `json:"phone" binding:"required,len=11,numeric" example:"13800138000"`
+	Code     string `json:"code" binding:"required" example:"123456"`
+	Password string `json:"password" example:"123456"` //可不设置，之后用验证码登录
+}
+
+type PhoneLoginRequest struct {
+	Phone     string `json:"phone" binding:"required,len=11,numeric" example:"13800138000"`
+	Code      string `json:"code" binding:"required" example:"123456"`
+	Device    string `json:"device" binding:"max=64" example:"iPhone 15"` //设备名
+	Platform  string `json:"platform" binding:"max=16" example:"ios"`     //平台 ios android web等
+	Ip        string `json:"-"`
+	UserAgent string `json:"-"`
+}
+
 type LoginRequest struct {
-	Email    string `json:"email" binding:"required,email" example:"1234@gmail.com"`
-	Password string `json:"password" binding:"required" example:"123456"`
+	Email     string `json:"email" binding:"required,email" example:"1234@gmail.com"`
+	Password  string `json:"password" binding:"required" example:"123456"`
+	Device    string `json:"device" binding:"max=64" example:"iPhone 15"` //设备名
+	Platform  string `json:"platform" binding:"max=16" example:"ios"`     //平台 ios android web等
+	Ip        string `json:"-"`
+	UserAgent string `json:"-"`
 }
 type LoginResponseData struct {
-	AccessToken string `json:"accessToken"`
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"` //只能使用一次，刷新后换成新的
+	ExpiresIn    int64  `json:"expiresIn"`    //accessToken有效期，秒
+}
+
+// 登录设备
+type SessionResp struct {
+	SessionId string `json:"session_id"`
+	Device    string `json:"device"`     //设备名
+	Platform  string `json:"platform"`   //平台
+	Ip        string `json:"ip"`         //登录ip
+	CreatedAt int64  `json:"created_at"` //登录时间
+	LastSeen  int64  `json:"last_seen"`  //最后活跃时间
+	Online    bool   `json:"online"`     //websocket是否在线
+	Current   bool   `json:"current"`    //是否当前设备
+}
+
+type RevokeSessionRequest struct {
+	SessionId string `json:"session_id" binding:"required"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
 }
 type LoginResponse struct {
 	Response
 	Data LoginResponseData
 }
 
+// 不传的字段不修改
 type UpdateRegisterInfoRequest struct {
-	Email    string `json:"email"`
-	Phone    string `json:"phone"`
-	Password string `json:"password"`
+	OldPassword string `json:"old_password" example:"123456"` //当前密码，未设置过密码时可不传
+	Code        string `json:"code" example:"123456"`         //未设置过密码时必填，以update_account场景发送到已绑定手机号的验证码
+	Email       string `json:"email" binding:"omitempty,email" example:"1234@gmail.com"`
+	Phone       string `json:"phone" binding:"omitempty,len=11,numeric" example:"13800138000"`
+	Password    string `json:"password" example:"654321"` //新密码，修改后其他设备需重新登录
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"` //验证邮件链接中的token
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email" example:"1234@gmail.com"`
 }
 
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"` //找回密码邮件链接中的token
+	Password string `json:"password" binding:"required" example:"654321"`
+}
+
+// 资料字段及其可见范围
+type ProfileField struct {
+	Value      string `json:"value"`
+	Visibility int    `json:"visibility" binding:"omitempty,oneof=1 2 3" example:"1"` //可见范围 1所有人 2仅好友 3仅自己，不传为所有人
+}
+
+// 不传的字段不修改
 type UpdateProfileRequest struct {
-	NickName string `json:"nick_name"` //昵称
-	Avatar   string `json:"avatar"`    //头像
-	Gender   int    `json:"gender"`    //性别
+	NickName     string                  `json:"nick_name"`                                          //昵称
+	Avatar       string                  `json:"avatar"`                                             //头像
+	Gender       int                     `json:"gender" binding:"omitempty,oneof=1 2 3" example:"1"` //性别 1男 2女 3未知
+	Signature    *ProfileField           `json:"signature"`                                          //个性签名
+	Birthday     *ProfileField           `json:"birthday"`                                           //生日 yyyy-mm-dd
+	Region       *ProfileField           `json:"region"`                                             //地区
+	CustomFields map[string]ProfileField `json:"custom_fields" binding:"omitempty,dive"`             //自定义字段，字段名由服务端配置，value为空则删除该字段
 }
 type GetProfileResponseData struct {
-	UserId   int64  `json:"user_id"`
-	Phone    string `json:"phone"`
-	Email    string `json:"email"`
-	NickName string `json:"nick_name"` //昵称
-	Avatar   string `json:"avatar"`    //头像
-	Gender   int    `json:"gender"`    //性别
+	UserId        int64  `json:"user_id"`
+	Phone         string `json:"phone"`
+	Email         string `json:"email"`
+	NickName      string `json:"nick_name"`      //昵称
+	Avatar        string `json:"avatar"`         //头像
+	Gender        int    `json:"gender"`         //性别
+	EmailVerified bool   `json:"email_verified"` //邮箱已验证
+	PhoneVerified bool   `json:"phone_verified"` //手机号已验证
+
+	Signature    ProfileField            `json:"signature"`     //个性签名
+	Birthday     ProfileField            `json:"birthday"`      //生日
+	Region       ProfileField            `json:"region"`        //地区
+	CustomFields map[string]ProfileField `json:"custom_fields"` //自定义字段
+}
+
+// 查看他人的资料，只返回对方允许查看者看到的字段
+type GetUserProfileResponseData struct {
+	UserId       int64             `json:"user_id"`
+	NickName     string            `json:"nick_name"`               //昵称
+	Avatar       string            `json:"avatar"`                  //头像
+	Gender       int               `json:"gender"`                  //性别
+	Signature    string            `json:"signature,omitempty"`     //个性签名
+	Birthday     string            `json:"birthday,omitempty"`      //生日
+	Region       string            `json:"region,omitempty"`        //地区
+	CustomFields map[string]string `json:"custom_fields,omitempty"` //自定义字段
+	Online       bool              `json:"online"`                  //是否在线
+	Deleted      bool              `json:"deleted"`                 //已注销
 }
 type GetProfileResponse struct {
 	Response
 	Data GetProfileResponseData
 }
+
+// 不传的字段不修改
+type UpdatePrivacyRequest struct {
+	MsgAllowType *int `json:"msg_allow_type" binding:"omitempty,oneof=0 1 2 3" example:"2"` //谁可以给我发消息 0服务端配置 1所有人 2仅好友 3好友和关注我的人
+	Discoverable *int `json:"discoverable" binding:"omitempty,min=0,max=7" example:"7"`     //允许被搜索的方式 1邮箱 2手机号 4昵称，按位组合
+}
+type GetPrivacyResponseData struct {
+	MsgAllowType int `json:"msg_allow_type"` //谁可以给我发消息
+	Discoverable int `json:"discoverable"`   //允许被搜索的方式
+}
+
+type SearchUserRequest struct {
+	Keyword string `json:"keyword" form:"keyword" binding:"required,max=64" example:"foo@bar.com"` //邮箱、手机号精确匹配，其他按昵称前缀匹配
+}
+type SearchUserItem struct {
+	UserId       int64  `json:"user_id"`
+	NickName     string `json:"nick_name"`    //昵称
+	Avatar       string `json:"avatar"`       //头像
+	Gender       int    `json:"gender"`       //性别
+	Relationship int    `json:"relationship"` //与我的关系 0无 1好友 2申请中 3已拉黑
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password" example:"123456"` //当前密码，未设置过密码时可不传
+	Code     string `json:"code" example:"123456"`     //未设置过密码时必填，以delete_account场景发送到已绑定手机号的验证码
+}
+
+// 导出数据中的资料
+type ExportProfile struct {
+	Profile GetProfileResponseData `json:"profile"`
+	Privacy GetPrivacyResponseData `json:"privacy"`
+}
+
+// 导出数据中的通讯录
+type ExportContacts struct {
+	Tags     []TagResp     `json:"tags"`
+	Contacts []ContactItem `json:"contacts"`
+}
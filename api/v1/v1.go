@@ -34,6 +34,14 @@ func HandleError(ctx *gin.Context, httpCode int, err error, data interface{}) {
 	ctx.JSON(httpCode, resp)
 }
 
+// 业务错误码，未注册的错误按500处理
+func ErrorCode(err error) int {
+	if code, ok := errorCodeMap[err]; ok {
+		return code
+	}
+	return errorCodeMap[ErrInternalServerError]
+}
+
 type Error struct {
 	Code    int
 	Message string
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/ljinf/im_server_standalone/pkg/moderation"
+)
+
+// 本地调试moderation.webhook用的审核服务：
+// 内容包含reject-word拒绝，包含block-word屏蔽，包含mask-word时替换为*，其余放行
+func main() {
+	var (
+		addr       = flag.String("addr", "127.0.0.1:8090", "listen address")
+		rejectWord = flag.String("reject", "reject", "reject when content contains")
+		blockWord  = flag.String("block", "block", "block when content contains")
+		maskWord   = flag.String("mask", "mask", "mask when content contains")
+	)
+	flag.Parse()
+
+	http.HandleFunc("/moderate", func(w http.ResponseWriter, r *http.Request) {
+		var in moderation.Input
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp := moderation.WebhookResponse{Action: moderation.ActionAllow.String()}
+		switch {
+		case strings.Contains(in.Content, *rejectWord):
+			resp = moderation.WebhookResponse{Action: moderation.ActionReject.String(), Rule: *rejectWord, Reason: "stub reject"}
+		case strings.Contains(in.Content, *blockWord):
+			resp = moderation.WebhookResponse{Action: moderation.ActionBlock.String(), Rule: *blockWord, Reason: "stub block"}
+		case strings.Contains(in.Content, *maskWord):
+			resp = moderation.WebhookResponse{
+				Action:  moderation.ActionMask.String(),
+				Content: strings.ReplaceAll(in.Content, *maskWord, strings.Repeat("*", len([]rune(*maskWord)))),
+				Rule:    *maskWord,
+			}
+		}
+		log.Printf("user %v: %q -> %v", in.UserId, in.Content, resp.Action)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+
+	log.Printf("moderation stub listening on %v", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
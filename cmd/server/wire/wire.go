@@ -6,6 +6,7 @@ package wire
 import (
 	"github.com/google/wire"
 	"github.com/ljinf/im_server_standalone/internal/handler"
+	"github.com/ljinf/im_server_standalone/internal/middleware"
 	"github.com/ljinf/im_server_standalone/internal/repository"
 	"github.com/ljinf/im_server_standalone/internal/server"
 	"github.com/ljinf/im_server_standalone/internal/service"
@@ -13,8 +14,12 @@ import (
 	"github.com/ljinf/im_server_standalone/pkg/app"
 	"github.com/ljinf/im_server_standalone/pkg/jwt"
 	"github.com/ljinf/im_server_standalone/pkg/log"
+	"github.com/ljinf/im_server_standalone/pkg/moderation"
+	"github.com/ljinf/im_server_standalone/pkg/rbac"
+	"github.com/ljinf/im_server_standalone/pkg/sender"
 	"github.com/ljinf/im_server_standalone/pkg/server/http"
 	"github.com/ljinf/im_server_standalone/pkg/sid"
+	"github.com/ljinf/im_server_standalone/pkg/token"
 	"github.com/panjf2000/ants"
 	"github.com/spf13/viper"
 )
@@ -27,6 +32,13 @@ var repositorySet = wire.NewSet(
 	repository.NewUserRepository,
 	repository.NewRelationshipRepository,
 	repository.NewChatRepository,
+	repository.NewSessionRepository,
+	repository.NewVerifyCodeRepository,
+	repository.NewLoginGuardRepository,
+	repository.NewSendLimitRepository,
+	repository.NewModerationRepository,
+	repository.NewReportRepository,
+	repository.NewAdminRepository,
 )
 
 var serviceSet = wire.NewSet(
@@ -35,6 +47,14 @@ var serviceSet = wire.NewSet(
 	service.NewWebsocketService,
 	service.NewRelationshipService,
 	service.NewChatService,
+	service.NewSessionService,
+	service.NewVerifyCodeService,
+	service.NewLoginGuardService,
+	service.NewAccountService,
+	service.NewSendLimitService,
+	service.NewModerationService,
+	service.NewReportService,
+	service.NewAdminService,
 )
 
 var handlerSet = wire.NewSet(
@@ -43,22 +63,28 @@ var handlerSet = wire.NewSet(
 	handler.NewWebSocketHandler,
 	handler.NewRelationshipHandler,
 	handler.NewChatHandler,
+	handler.NewAccountHandler,
+	handler.NewReportHandler,
+	handler.NewAdminHandler,
 )
 
 var serverSet = wire.NewSet(
 	server.NewHTTPServer,
 	server.NewJob,
 	ws.NewWsServer,
+	middleware.NewAllowedOrigins,
 )
 
 // build App
 func newApp(
 	httpServer *http.Server,
 	job *server.Job,
+	wss ws.SocketWsServer,
 	// task *server.Task,
 ) *app.App {
 	return app.NewApp(
-		app.WithServer(httpServer, job),
+		// ws先下线，排空期间http仍可用
+		app.WithServer(wss, httpServer, job),
 		app.WithName("demo-server"),
 	)
 }
@@ -71,6 +97,10 @@ func NewWire(*viper.Viper, *log.Logger, *ants.Pool) (*app.App, func(), error) {
 		serverSet,
 		sid.NewSid,
 		jwt.NewJwt,
+		sender.NewSender,
+		token.NewSigner,
+		moderation.NewPipelineFromConf,
+		rbac.NewFromConf,
 		newApp,
 	))
 }
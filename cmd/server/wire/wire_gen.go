@@ -9,6 +9,7 @@ package wire
 import (
 	"github.com/google/wire"
 	"github.com/ljinf/im_server_standalone/internal/handler"
+	"github.com/ljinf/im_server_standalone/internal/middleware"
 	"github.com/ljinf/im_server_standalone/internal/repository"
 	"github.com/ljinf/im_server_standalone/internal/server"
 	"github.com/ljinf/im_server_standalone/internal/service"
@@ -16,8 +17,12 @@ import (
 	"github.com/ljinf/im_server_standalone/pkg/app"
 	"github.com/ljinf/im_server_standalone/pkg/jwt"
 	"github.com/ljinf/im_server_standalone/pkg/log"
+	"github.com/ljinf/im_server_standalone/pkg/moderation"
+	"github.com/ljinf/im_server_standalone/pkg/rbac"
+	"github.com/ljinf/im_server_standalone/pkg/sender"
 	"github.com/ljinf/im_server_standalone/pkg/server/http"
 	"github.com/ljinf/im_server_standalone/pkg/sid"
+	"github.com/ljinf/im_server_standalone/pkg/token"
 	"github.com/panjf2000/ants"
 	"github.com/spf13/viper"
 )
@@ -25,7 +30,10 @@ import (
 // Injectors from wire.go:
 
 func NewWire(viperViper *viper.Viper, logger *log.Logger, pool *ants.Pool) (*app.App, func(), error) {
-	jwtJWT := jwt.NewJwt(viperViper)
+	jwtJWT, err := jwt.NewJwt(viperViper)
+	if err != nil {
+		return nil, nil, err
+	}
 	handlerHandler := handler.NewHandler(logger)
 	db := repository.NewDB(viperViper, logger)
 	client := repository.NewRedis(viperViper)
@@ -34,39 +42,75 @@ func NewWire(viperViper *viper.Viper, logger *log.Logger, pool *ants.Pool) (*app
 	sidSid := sid.NewSid()
 	serviceService := service.NewService(transaction, logger, sidSid, jwtJWT)
 	userRepository := repository.NewUserRepository(repositoryRepository)
-	userService := service.NewUserService(serviceService, userRepository)
+	relationshipRepository := repository.NewRelationshipRepository(repositoryRepository)
+	socketWsServer := ws.NewWsServer(viperViper, logger, client, pool)
+	sessionRepository := repository.NewSessionRepository(repositoryRepository)
+	sessionService := service.NewSessionService(serviceService, viperViper, sessionRepository, userRepository, socketWsServer)
+	verifyCodeRepository := repository.NewVerifyCodeRepository(repositoryRepository)
+	senderSender, err := sender.NewSender(viperViper, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	verifyCodeService := service.NewVerifyCodeService(serviceService, viperViper, verifyCodeRepository, senderSender)
+	signer, err := token.NewSigner(viperViper)
+	if err != nil {
+		return nil, nil, err
+	}
+	loginGuardRepository := repository.NewLoginGuardRepository(repositoryRepository)
+	loginGuardService := service.NewLoginGuardService(serviceService, viperViper, loginGuardRepository)
+	userService := service.NewUserService(serviceService, viperViper, userRepository, relationshipRepository, socketWsServer, sessionService, verifyCodeService, loginGuardService, signer, senderSender)
 	userHandler := handler.NewUserHandler(handlerHandler, userService)
-	socketWsServer := ws.NewWsServer(viperViper, logger)
 	chatRepository := repository.NewChatRepository(repositoryRepository)
-	chatService := service.NewChatService(serviceService, chatRepository)
-	websocketService := service.NewWebsocketService(serviceService, socketWsServer, chatService, pool)
-	webSocketHandler := handler.NewWebSocketHandler(handlerHandler, websocketService)
-	relationshipRepository := repository.NewRelationshipRepository(repositoryRepository)
-	relationshipService := service.NewRelationshipService(serviceService, relationshipRepository)
-	relationshipHandler := handler.NewRelationshipHandler(handlerHandler, relationshipService, chatService)
+	sendLimitRepository := repository.NewSendLimitRepository(repositoryRepository)
+	sendLimitService := service.NewSendLimitService(serviceService, viperViper, sendLimitRepository, userRepository)
+	moderationRepository := repository.NewModerationRepository(repositoryRepository)
+	pipeline, err := moderation.NewPipelineFromConf(viperViper, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	moderationService := service.NewModerationService(serviceService, viperViper, moderationRepository, pipeline)
+	chatService := service.NewChatService(serviceService, viperViper, chatRepository, userRepository, relationshipRepository, sendLimitService, moderationService)
+	websocketService := service.NewWebsocketService(serviceService, viperViper, socketWsServer, chatService, sessionService)
+	allowedOrigins, err := middleware.NewAllowedOrigins(viperViper)
+	if err != nil {
+		return nil, nil, err
+	}
+	webSocketHandler := handler.NewWebSocketHandler(handlerHandler, viperViper, websocketService, allowedOrigins)
+	relationshipService := service.NewRelationshipService(serviceService, viperViper, relationshipRepository, chatService)
+	relationshipHandler := handler.NewRelationshipHandler(handlerHandler, relationshipService, websocketService)
 	chatHandler := handler.NewChatHandler(handlerHandler, chatService, websocketService)
-	httpServer := server.NewHTTPServer(logger, viperViper, jwtJWT, userHandler, webSocketHandler, relationshipHandler, chatHandler)
+	accountService := service.NewAccountService(serviceService, userRepository, relationshipRepository, chatRepository, sessionService, verifyCodeService)
+	accountHandler := handler.NewAccountHandler(handlerHandler, accountService)
+	reportRepository := repository.NewReportRepository(repositoryRepository)
+	adminRepository := repository.NewAdminRepository(repositoryRepository)
+	rbacRBAC := rbac.NewFromConf(viperViper)
+	adminService := service.NewAdminService(serviceService, viperViper, adminRepository, userRepository, chatRepository, sessionService, socketWsServer, rbacRBAC)
+	reportService := service.NewReportService(serviceService, reportRepository, chatRepository, userRepository, sessionService, adminService, socketWsServer)
+	reportHandler := handler.NewReportHandler(handlerHandler, reportService)
+	adminHandler := handler.NewAdminHandler(handlerHandler, adminService)
+	httpServer := server.NewHTTPServer(logger, viperViper, jwtJWT, client, sessionService, userService, userHandler, webSocketHandler, relationshipHandler, chatHandler, accountHandler, reportHandler, adminService, adminHandler, rbacRBAC, allowedOrigins)
 	job := server.NewJob(logger)
-	appApp := newApp(httpServer, job)
+	appApp := newApp(httpServer, job, socketWsServer)
 	return appApp, func() {
 	}, nil
 }
 
 // wire.go:
 
-var repositorySet = wire.NewSet(repository.NewDB, repository.NewRedis, repository.NewRepository, repository.NewTransaction, repository.NewUserRepository, repository.NewRelationshipRepository, repository.NewChatRepository)
+var repositorySet = wire.NewSet(repository.NewDB, repository.NewRedis, repository.NewRepository, repository.NewTransaction, repository.NewUserRepository, repository.NewRelationshipRepository, repository.NewChatRepository, repository.NewSessionRepository, repository.NewVerifyCodeRepository, repository.NewLoginGuardRepository, repository.NewSendLimitRepository, repository.NewModerationRepository, repository.NewReportRepository, repository.NewAdminRepository)
 
-var serviceSet = wire.NewSet(service.NewService, service.NewUserService, service.NewWebsocketService, service.NewRelationshipService, service.NewChatService)
+var serviceSet = wire.NewSet(service.NewService, service.NewUserService, service.NewWebsocketService, service.NewRelationshipService, service.NewChatService, service.NewSessionService, service.NewVerifyCodeService, service.NewLoginGuardService, service.NewAccountService, service.NewSendLimitService, service.NewModerationService, service.NewReportService, service.NewAdminService)
 
-var handlerSet = wire.NewSet(handler.NewHandler, handler.NewUserHandler, handler.NewWebSocketHandler, handler.NewRelationshipHandler, handler.NewChatHandler)
+var handlerSet = wire.NewSet(handler.NewHandler, handler.NewUserHandler, handler.NewWebSocketHandler, handler.NewRelationshipHandler, handler.NewChatHandler, handler.NewAccountHandler, handler.NewReportHandler, handler.NewAdminHandler)
 
-var serverSet = wire.NewSet(server.NewHTTPServer, server.NewJob, ws.NewWsServer)
+var serverSet = wire.NewSet(server.NewHTTPServer, server.NewJob, ws.NewWsServer, middleware.NewAllowedOrigins)
 
 // build App
 func newApp(
 	httpServer *http.Server,
 	job *server.Job,
+	wss ws.SocketWsServer,
 
 ) *app.App {
-	return app.NewApp(app.WithServer(httpServer, job), app.WithName("demo-server"))
+	return app.NewApp(app.WithServer(wss, httpServer, job), app.WithName("demo-server"))
 }
@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"github.com/ljinf/im_server_standalone/internal/model"
+	"github.com/ljinf/im_server_standalone/pkg/contants"
 	"github.com/redis/go-redis/v9"
 	"math"
 	"math/rand"
+	"strconv"
 	"time"
 )
 
@@ -24,6 +26,8 @@ var (
 	ConversationUserListPrefix = cachePrefix + "conversation:userlist:"
 	//会话消息链
 	ConversationMsgListPrefix = cachePrefix + "conversation:msglist:"
+	//单聊会话索引 min_uid:max_uid -> conversation_id
+	C2CConversationPrefix = cachePrefix + "conversation:c2c:"
 
 	//用户会话
 	UserConversationInfoPrefix = cachePrefix + "user:conversation:info:"
@@ -32,6 +36,10 @@ var (
 	//消息
 	MsgInfoCachePrefix = cachePrefix + "msg:info:"
 	MsgExpire          = 604800 //7天
+
+	//每分钟发送的消息数，所有节点共用
+	MsgMinuteCountPrefix = cachePrefix + "stats:msg:minute:"
+	msgMinuteCountExpire = 2 * time.Hour
 )
 
 // 加1
@@ -75,8 +83,13 @@ func GetMsgCache(rdb *redis.Client, msgIds ...interface{}) ([]model.MsgResp, err
 		}
 
 		for _, v := range result {
+			// 部分缓存不存在，当作未命中
+			data, ok := v.(string)
+			if !ok {
+				return nil, redis.Nil
+			}
 			item := model.MsgResp{}
-			if err = json.Unmarshal([]byte(v.(string)), &item); err != nil {
+			if err = json.Unmarshal([]byte(data), &item); err != nil {
 				return nil, err
 			}
 			msgList = append(msgList, item)
@@ -86,6 +99,39 @@ func GetMsgCache(rdb *redis.Client, msgIds ...interface{}) ([]model.MsgResp, err
 	return msgList, nil
 }
 
+func DelMsgCache(rdb *redis.Client, msgId int64) error {
+	return rdb.Del(ctx, fmt.Sprintf("%v%v", MsgInfoCachePrefix, msgId)).Err()
+}
+
+// 按分钟计数，minute为unix时间戳/60
+func IncrMsgMinuteCount(rdb *redis.Client, minute int64) error {
+	key := fmt.Sprintf("%v%v", MsgMinuteCountPrefix, minute)
+	pipe := rdb.TxPipeline()
+	pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, msgMinuteCountExpire)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// 从from开始连续count分钟的消息数，没有记录的为0
+func GetMsgMinuteCounts(rdb *redis.Client, from int64, count int) ([]int64, error) {
+	keys := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		keys = append(keys, fmt.Sprintf("%v%v", MsgMinuteCountPrefix, from+int64(i)))
+	}
+	result, err := rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]int64, count)
+	for i, v := range result {
+		if s, ok := v.(string); ok {
+			counts[i], _ = strconv.ParseInt(s, 10, 64)
+		}
+	}
+	return counts, nil
+}
+
 // 会话  String类型
 func SetConversationCache(rdb *redis.Client, conv *model.ConversationList) error {
 	convData, err := json.Marshal(conv)
@@ -114,8 +160,13 @@ func GetConversationCache(rdb *redis.Client, convIds ...int64) ([]model.Conversa
 		}
 
 		for _, v := range result {
+			// 部分缓存不存在，当作未命中
+			data, ok := v.(string)
+			if !ok {
+				return nil, redis.Nil
+			}
 			item := model.ConversationList{}
-			if err = json.Unmarshal([]byte(v.(string)), &item); err != nil {
+			if err = json.Unmarshal([]byte(data), &item); err != nil {
 				return nil, err
 			}
 			convList = append(convList, item)
@@ -125,6 +176,17 @@ func GetConversationCache(rdb *redis.Client, convIds ...int64) ([]model.Conversa
 	return convList, nil
 }
 
+// 单聊会话索引  String类型
+func SetC2CConversationCache(rdb *redis.Client, pair *model.C2CConversation) error {
+	key := fmt.Sprintf("%v%v:%v", C2CConversationPrefix, pair.MinUserId, pair.MaxUserId)
+	return rdb.Set(ctx, key, pair.ConversationId, time.Duration(rand.Intn(randTime)+ConversationExpire)*time.Second).Err()
+}
+
+func GetC2CConversationCache(rdb *redis.Client, minUserId, maxUserId int64) (int64, error) {
+	key := fmt.Sprintf("%v%v:%v", C2CConversationPrefix, minUserId, maxUserId)
+	return rdb.Get(ctx, key).Int64()
+}
+
 // 用户的会话设置  zset类型
 func SetUserConversationCache(rdb *redis.Client, info ...model.UserConversationList) error {
 	if len(info) > 0 {
@@ -135,6 +197,11 @@ func SetUserConversationCache(rdb *redis.Client, info ...model.UserConversationL
 			if err != nil {
 				return err
 			}
+			// 同一个会话只保留最新的一条
+			score := fmt.Sprintf("%v", v.ConversationId)
+			if err = rdb.ZRemRangeByScore(ctx, key, score, score).Err(); err != nil {
+				return err
+			}
 			list = append(list, redis.Z{
 				Score:  float64(v.ConversationId),
 				Member: string(data),
@@ -148,26 +215,35 @@ func SetUserConversationCache(rdb *redis.Client, info ...model.UserConversationL
 	return nil
 }
 
-// 列表
+// 会话列表，消息请求不在列表中，不计入分页
 func GetUserConversationListCache(rdb *redis.Client, userId, pageNum, pageSize int64) ([]model.UserConversationList, error) {
 	var (
 		list  = make([]model.UserConversationList, 0, pageSize)
 		key   = fmt.Sprintf("%v%v", UserConversationInfoPrefix, userId)
 		start = (pageNum - 1) * pageSize
-		end   = start + pageSize - 1
 	)
 
-	result, err := rdb.ZRange(ctx, key, start, end).Result()
+	result, err := rdb.ZRange(ctx, key, 0, -1).Result()
 	if err != nil {
 		return nil, err
 	}
 
+	var index int64
 	for _, v := range result {
 		item := model.UserConversationList{}
 		if err = json.Unmarshal([]byte(v), &item); err != nil {
 			return nil, err
 		}
-		list = append(list, item)
+		if item.RequestStatus != contants.MsgRequestStatusNone {
+			continue
+		}
+		if index >= start {
+			list = append(list, item)
+			if int64(len(list)) == pageSize {
+				break
+			}
+		}
+		index++
 	}
 
 	return list, nil
@@ -198,6 +274,12 @@ func DelUserConversationCache(rdb *redis.Client, userId int64) error {
 	return rdb.Del(ctx, fmt.Sprintf("%v%v", UserConversationInfoPrefix, userId)).Err()
 }
 
+// 删除会话信息和用户列表缓存，如群组解散后
+func DelConversationCache(rdb *redis.Client, convId int64) error {
+	return rdb.Del(ctx, fmt.Sprintf("%v%v", ConversationInfoPrefix, convId),
+		fmt.Sprintf("%v%v", ConversationUserListPrefix, convId)).Err()
+}
+
 // 会话下的用户列表(群聊)  set类型
 func AddConversationUserListCache(rdb *redis.Client, convId int64, uids ...int64) error {
 	key := fmt.Sprintf("%v%v", ConversationUserListPrefix, convId)
@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+var (
+	LoginWaitPrefix = cachePrefix + "login:wait:" //渐进延迟，存在期间拒绝该账号登录
+	LoginLockPrefix = cachePrefix + "login:lock:" //账号临时锁定
+)
+
+// 失败次数复用限流的固定窗口计数
+const (
+	loginFailAccount = "login_fail_account"
+	loginFailIp      = "login_fail_ip"
+)
+
+// 返回账号剩余锁定时间、剩余等待时间和ip窗口内的失败次数
+func GetLoginGuardCache(rdb *redis.Client, account, ip string) (time.Duration, time.Duration, int64, error) {
+	pipe := rdb.Pipeline()
+	lock := pipe.PTTL(ctx, LoginLockPrefix+account)
+	wait := pipe.PTTL(ctx, LoginWaitPrefix+account)
+	ipFailures := pipe.Get(ctx, fmt.Sprintf("%v%v:%v", RateLimitPrefix, loginFailIp, ip))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, 0, 0, err
+	}
+	n, _ := ipFailures.Int64()
+	return lock.Val(), wait.Val(), n, nil
+}
+
+// 返回窗口内账号和ip的失败次数
+func IncrLoginFailureCache(rdb *redis.Client, account, ip string, window time.Duration) (int64, int64, error) {
+	accountFailures, err := IncrRateLimitCache(rdb, loginFailAccount, account, window)
+	if err != nil {
+		return 0, 0, err
+	}
+	ipFailures, err := IncrRateLimitCache(rdb, loginFailIp, ip, window)
+	return accountFailures, ipFailures, err
+}
+
+func SetLoginWaitCache(rdb *redis.Client, account string, wait time.Duration) error {
+	return rdb.Set(ctx, LoginWaitPrefix+account, 1, wait).Err()
+}
+
+// 锁定后重新计数
+func SetLoginLockCache(rdb *redis.Client, account string, lockout time.Duration) error {
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, LoginLockPrefix+account, 1, lockout)
+		pipe.Del(ctx, LoginWaitPrefix+account, fmt.Sprintf("%v%v:%v", RateLimitPrefix, loginFailAccount, account))
+		return nil
+	})
+	return err
+}
+
+// 登录成功清除账号的失败记录，ip的失败次数保留到窗口结束
+func DelLoginFailureCache(rdb *redis.Client, account string) error {
+	return rdb.Del(ctx, LoginWaitPrefix+account, fmt.Sprintf("%v%v:%v", RateLimitPrefix, loginFailAccount, account)).Err()
+}
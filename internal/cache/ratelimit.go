@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+var RateLimitPrefix = cachePrefix + "rate:limit:"
+
+// 固定窗口计数，返回当前窗口内的请求次数
+func IncrRateLimitCache(rdb *redis.Client, name, key string, window time.Duration) (int64, error) {
+	k := fmt.Sprintf("%v%v:%v", RateLimitPrefix, name, key)
+
+	pipe := rdb.Pipeline()
+	incr := pipe.Incr(ctx, k)
+	ttl := pipe.TTL(ctx, k)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	// 新窗口或过期时间设置失败过，补上过期时间
+	if ttl.Val() < 0 {
+		if err := rdb.Expire(ctx, k, window).Err(); err != nil {
+			return incr.Val(), err
+		}
+	}
+	return incr.Val(), nil
+}
+
+var TokenBucketPrefix = cachePrefix + "rate:bucket:"
+
+// 令牌桶，使用redis时间保证多节点一致。返回是否取到令牌和需要等待的毫秒数
+var takeTokenScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return {allowed, wait}
+`)
+
+// rate每秒补充的令牌数，burst桶容量
+func TakeTokenCache(rdb *redis.Client, name, key string, rate float64, burst int64) (bool, time.Duration, error) {
+	k := fmt.Sprintf("%v%v:%v", TokenBucketPrefix, name, key)
+	result, err := takeTokenScript.Run(ctx, rdb, []string{k}, rate, burst).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(result) != 2 {
+		return false, 0, fmt.Errorf("unexpected token bucket result %v", result)
+	}
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+}
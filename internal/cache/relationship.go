@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+var (
+	//用户拉黑的用户集合
+	BlockListPrefix = cachePrefix + "user:blocklist:"
+	blockListExpire = 259200 //72 hour
+	// 空集合占位，区分未加载和没有拉黑任何人
+	blockListPlaceholder int64 = 0
+
+	//关注数和粉丝数
+	FollowCountPrefix = cachePrefix + "user:follow:count:"
+	followCountExpire = 86400 //24 hour
+)
+
+// 拉黑列表 set类型，加载时写入占位成员
+func SetBlockListCache(rdb *redis.Client, userId int64, targetIds ...int64) error {
+	key := fmt.Sprintf("%v%v", BlockListPrefix, userId)
+	members := make([]interface{}, 0, len(targetIds)+1)
+	members = append(members, blockListPlaceholder)
+	for _, v := range targetIds {
+		members = append(members, v)
+	}
+	if err := rdb.SAdd(ctx, key, members...).Err(); err != nil {
+		return err
+	}
+	return rdb.Expire(ctx, key, time.Duration(rand.Intn(randTime)+blockListExpire)*time.Second).Err()
+}
+
+// 返回是否已拉黑，以及缓存是否存在
+func IsBlockedCache(rdb *redis.Client, userId, targetId int64) (bool, bool, error) {
+	key := fmt.Sprintf("%v%v", BlockListPrefix, userId)
+	exists, err := rdb.Exists(ctx, key).Result()
+	if err != nil || exists == 0 {
+		return false, false, err
+	}
+	blocked, err := rdb.SIsMember(ctx, key, targetId).Result()
+	if err != nil {
+		return false, false, err
+	}
+	return blocked, true, nil
+}
+
+func DelBlockListCache(rdb *redis.Client, userId int64) error {
+	return rdb.Del(ctx, fmt.Sprintf("%v%v", BlockListPrefix, userId)).Err()
+}
+
+// 关注数和粉丝数 hash类型
+func SetFollowCountCache(rdb *redis.Client, userId, following, followers int64) error {
+	key := fmt.Sprintf("%v%v", FollowCountPrefix, userId)
+	if err := rdb.HSet(ctx, key, "following", following, "followers", followers).Err(); err != nil {
+		return err
+	}
+	return rdb.Expire(ctx, key, time.Duration(rand.Intn(randTime)+followCountExpire)*time.Second).Err()
+}
+
+// 返回关注数、粉丝数，以及缓存是否存在
+func GetFollowCountCache(rdb *redis.Client, userId int64) (int64, int64, bool, error) {
+	key := fmt.Sprintf("%v%v", FollowCountPrefix, userId)
+	result, err := rdb.HMGet(ctx, key, "following", "followers").Result()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if len(result) < 2 || result[0] == nil || result[1] == nil {
+		return 0, 0, false, nil
+	}
+	following, err := strconv.ParseInt(result[0].(string), 10, 64)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	followers, err := strconv.ParseInt(result[1].(string), 10, 64)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	return following, followers, true, nil
+}
+
+func DelFollowCountCache(rdb *redis.Client, userIds ...int64) error {
+	if len(userIds) < 1 {
+		return nil
+	}
+	keys := make([]string, 0, len(userIds))
+	for _, v := range userIds {
+		keys = append(keys, fmt.Sprintf("%v%v", FollowCountPrefix, v))
+	}
+	return rdb.Del(ctx, keys...).Err()
+}
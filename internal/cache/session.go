@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ljinf/im_server_standalone/internal/model"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+var (
+	SessionPrefix      = cachePrefix + "session:"
+	UserSessionsPrefix = cachePrefix + "user:sessions:" //zset 用户的会话id，分数为最后活跃时间
+
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrRefreshTokenInvalid = errors.New("refresh token invalid")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+// 过期时间即refresh token的有效期
+func SetSessionCache(rdb *redis.Client, session *model.Session, ttl time.Duration) error {
+	dataBytes, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	userKey := fmt.Sprintf("%v%v", UserSessionsPrefix, session.UserId)
+
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, fmt.Sprintf("%v%v", SessionPrefix, session.SessionId), string(dataBytes), ttl)
+		pipe.ZAdd(ctx, userKey, redis.Z{Score: float64(session.LastSeen), Member: session.SessionId})
+		pipe.Expire(ctx, userKey, ttl)
+		return nil
+	})
+	return err
+}
+
+func GetSessionCache(rdb *redis.Client, sessionId string) (*model.Session, error) {
+	result, err := rdb.Get(ctx, fmt.Sprintf("%v%v", SessionPrefix, sessionId)).Result()
+	if err != nil {
+		return nil, err
+	}
+	session := &model.Session{}
+	if err = json.Unmarshal([]byte(result), session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// 会话存在时刷新最后活跃时间
+func TouchSessionCache(rdb *redis.Client, userId int64, sessionId string) (bool, error) {
+	pipe := rdb.Pipeline()
+	exists := pipe.Exists(ctx, fmt.Sprintf("%v%v", SessionPrefix, sessionId))
+	pipe.ZAddXX(ctx, fmt.Sprintf("%v%v", UserSessionsPrefix, userId),
+		redis.Z{Score: float64(time.Now().Unix()), Member: sessionId})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	return exists.Val() > 0, nil
+}
+
+// 按最后活跃时间倒序，顺带清理已过期的会话id
+func GetUserSessionsCache(rdb *redis.Client, userId int64) ([]model.Session, error) {
+	userKey := fmt.Sprintf("%v%v", UserSessionsPrefix, userId)
+	members, err := rdb.ZRevRangeWithScores(ctx, userKey, 0, -1).Result()
+	if err != nil || len(members) < 1 {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(members))
+	for _, v := range members {
+		keys = append(keys, fmt.Sprintf("%v%v", SessionPrefix, v.Member))
+	}
+	result, err := rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]model.Session, 0, len(result))
+	expired := make([]interface{}, 0)
+	for i, v := range result {
+		data, ok := v.(string)
+		if !ok {
+			expired = append(expired, members[i].Member)
+			continue
+		}
+		var session model.Session
+		if err = json.Unmarshal([]byte(data), &session); err != nil {
+			return nil, err
+		}
+		session.LastSeen = int64(members[i].Score)
+		list = append(list, session)
+	}
+
+	if len(expired) > 0 {
+		if err = rdb.ZRem(ctx, userKey, expired...).Err(); err != nil {
+			return list, err
+		}
+	}
+	return list, nil
+}
+
+func DelSessionCache(rdb *redis.Client, userId int64, sessionId string) error {
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, fmt.Sprintf("%v%v", SessionPrefix, sessionId))
+		pipe.ZRem(ctx, fmt.Sprintf("%v%v", UserSessionsPrefix, userId), sessionId)
+		return nil
+	})
+	return err
+}
+
+// 校验refresh token并轮换为newHash，同一个token并发刷新只有一个成功
+func RotateSessionCache(rdb *redis.Client, sessionId, oldHash, newHash string, ttl time.Duration) (*model.Session, error) {
+	key := fmt.Sprintf("%v%v", SessionPrefix, sessionId)
+	session := &model.Session{}
+
+	err := rdb.Watch(ctx, func(tx *redis.Tx) error {
+		result, err := tx.Get(ctx, key).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				return ErrSessionNotFound
+			}
+			return err
+		}
+		if err = json.Unmarshal([]byte(result), session); err != nil {
+			return err
+		}
+
+		switch oldHash {
+		case session.RefreshHash:
+		case session.PrevHash:
+			return ErrRefreshTokenReused
+		default:
+			return ErrRefreshTokenInvalid
+		}
+
+		session.PrevHash = session.RefreshHash
+		session.RefreshHash = newHash
+		session.RefreshedAt = time.Now().Unix()
+		dataBytes, err := json.Marshal(session)
+		if err != nil {
+			return err
+		}
+		userKey := fmt.Sprintf("%v%v", UserSessionsPrefix, session.UserId)
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, string(dataBytes), ttl)
+			pipe.ZAdd(ctx, userKey, redis.Z{Score: float64(session.RefreshedAt), Member: sessionId})
+			pipe.Expire(ctx, userKey, ttl)
+			return nil
+		})
+		return err
+	}, key)
+	if err != nil {
+		if errors.Is(err, redis.TxFailedErr) {
+			return nil, ErrRefreshTokenInvalid
+		}
+		return nil, err
+	}
+	return session, nil
+}
@@ -51,6 +51,10 @@ func GetAccountInfoCache(rdb *redis.Client, userId int64) (*model.AccountInfo, e
 	return nil, errors.New("not found")
 }
 
+func DelAccountInfoCache(rdb *redis.Client, userId int64) error {
+	return rdb.Del(ctx, fmt.Sprintf("%v%v", AccountInfoCachePrefix, userId)).Err()
+}
+
 func GetUserInfoListCache(rdb *redis.Client, uids ...string) ([]model.UserInfo, error) {
 	var (
 		length = len(uids)
@@ -69,8 +73,13 @@ func GetUserInfoListCache(rdb *redis.Client, uids ...string) ([]model.UserInfo,
 		}
 
 		for _, v := range result {
+			// 部分缓存不存在，当作未命中
+			data, ok := v.(string)
+			if !ok {
+				return nil, redis.Nil
+			}
 			item := model.UserInfo{}
-			if err = json.Unmarshal([]byte(v.(string)), &item); err != nil {
+			if err = json.Unmarshal([]byte(data), &item); err != nil {
 				return nil, err
 			}
 			list = append(list, item)
@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+var (
+	VerifyCodePrefix         = cachePrefix + "verify:code:"     //hash code、attempts
+	VerifyCodeCooldownPrefix = cachePrefix + "verify:cooldown:" //发送间隔
+)
+
+// 校验成功或错误次数达到上限都会删除验证码
+var checkVerifyCodeScript = redis.NewScript(`
+local code = redis.call('HGET', KEYS[1], 'code')
+if not code then
+	return 0
+end
+if code == ARGV[1] then
+	redis.call('DEL', KEYS[1])
+	return 1
+end
+if redis.call('HINCRBY', KEYS[1], 'attempts', 1) >= tonumber(ARGV[2]) then
+	redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// 冷却期内不能重复发送，返回false
+func SetVerifyCodeCache(rdb *redis.Client, scene, target, code string, ttl, cooldown time.Duration) (bool, error) {
+	ok, err := rdb.SetNX(ctx, fmt.Sprintf("%v%v:%v", VerifyCodeCooldownPrefix, scene, target), 1, cooldown).Result()
+	if err != nil || !ok {
+		return false, err
+	}
+
+	key := fmt.Sprintf("%v%v:%v", VerifyCodePrefix, scene, target)
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.HSet(ctx, key, "code", code, "attempts", 0)
+		pipe.Expire(ctx, key, ttl)
+		return nil
+	})
+	return err == nil, err
+}
+
+func CheckVerifyCodeCache(rdb *redis.Client, scene, target, code string, maxAttempts int) (bool, error) {
+	n, err := checkVerifyCodeScript.Run(ctx, rdb, []string{fmt.Sprintf("%v%v:%v", VerifyCodePrefix, scene, target)},
+		code, maxAttempts).Int()
+	return n == 1, err
+}
@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"fmt"
+	"github.com/ljinf/im_server_standalone/internal/model"
+	"github.com/redis/go-redis/v9"
+	"strings"
+	"time"
+)
+
+var (
+	WsRoutePrefix       = cachePrefix + "ws:route:"    //用户连接所在的节点 节点id|会话id
+	WsNodeChannelPrefix = cachePrefix + "ws:node:"     //节点间投递的频道，每个节点订阅自己的
+	WsBroadcastChannel  = cachePrefix + "ws:broadcast" //所有节点都订阅，用于广播
+)
+
+// 仍指向同一连接时才续期或删除，避免覆盖用户在其他节点的新连接
+var (
+	expireWsRouteScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+	delWsRouteScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+)
+
+func wsRouteKey(userId int64) string {
+	return fmt.Sprintf("%v%v", WsRoutePrefix, userId)
+}
+
+func encodeWsRoute(route model.WsRoute) string {
+	return route.NodeId + "|" + route.SessionId
+}
+
+func decodeWsRoute(value string) *model.WsRoute {
+	nodeId, sessionId, ok := strings.Cut(value, "|")
+	if !ok {
+		return nil
+	}
+	return &model.WsRoute{NodeId: nodeId, SessionId: sessionId}
+}
+
+// 返回被替换的旧路由，没有时为nil
+func SetWsRouteCache(rdb *redis.Client, userId int64, route model.WsRoute, ttl time.Duration) (*model.WsRoute, error) {
+	old, err := rdb.SetArgs(ctx, wsRouteKey(userId), encodeWsRoute(route), redis.SetArgs{TTL: ttl, Get: true}).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return decodeWsRoute(old), nil
+}
+
+// 只返回存在路由的用户
+func GetWsRouteCache(rdb *redis.Client, userIds ...int64) (map[int64]model.WsRoute, error) {
+	routes := make(map[int64]model.WsRoute, len(userIds))
+	if len(userIds) == 0 {
+		return routes, nil
+	}
+
+	keys := make([]string, 0, len(userIds))
+	for _, v := range userIds {
+		keys = append(keys, wsRouteKey(v))
+	}
+	result, err := rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range result {
+		if value, ok := v.(string); ok {
+			if route := decodeWsRoute(value); route != nil {
+				routes[userIds[i]] = *route
+			}
+		}
+	}
+	return routes, nil
+}
+
+// 批量续期本节点连接的路由
+func ExpireWsRouteCache(rdb *redis.Client, routes map[int64]model.WsRoute, ttl time.Duration) error {
+	if len(routes) == 0 {
+		return nil
+	}
+	pipe := rdb.Pipeline()
+	for userId, route := range routes {
+		expireWsRouteScript.Run(ctx, pipe, []string{wsRouteKey(userId)}, encodeWsRoute(route), ttl.Milliseconds())
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func DelWsRouteCache(rdb *redis.Client, userId int64, route model.WsRoute) error {
+	return delWsRouteScript.Run(ctx, rdb, []string{wsRouteKey(userId)}, encodeWsRoute(route)).Err()
+}
+
+func PublishWsNodeMsg(rdb *redis.Client, nodeId string, data []byte) error {
+	return rdb.Publish(ctx, WsNodeChannelPrefix+nodeId, data).Err()
+}
+
+func PublishWsBroadcast(rdb *redis.Client, data []byte) error {
+	return rdb.Publish(ctx, WsBroadcastChannel, data).Err()
+}
+
+// 同时订阅本节点频道和广播频道
+func SubscribeWsNode(rdb *redis.Client, nodeId string) *redis.PubSub {
+	return rdb.Subscribe(ctx, WsNodeChannelPrefix+nodeId, WsBroadcastChannel)
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	v1 "github.com/ljinf/im_server_standalone/api/v1"
+	"github.com/ljinf/im_server_standalone/internal/service"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+type AccountHandler struct {
+	*Handler
+	accountService service.AccountService
+}
+
+func NewAccountHandler(handler *Handler, accountService service.AccountService) *AccountHandler {
+	return &AccountHandler{
+		Handler:        handler,
+		accountService: accountService,
+	}
+}
+
+// DeleteAccount godoc
+// @Summary 注销账号
+// @Schemes
+// @Description 需要校验当前密码，未设置过密码的需要已绑定手机号的验证码。删除好友关系和会话，资料匿名化，已发出的消息保留并显示为已注销用户，所有设备下线
+// @Tags 用户模块
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Param request body v1.DeleteAccountRequest true "params"
+// @Success 200 {object} v1.Response
+// @Router /user/account [delete]
+func (h *AccountHandler) DeleteAccount(ctx *gin.Context) {
+	var req v1.DeleteAccountRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	if err := h.accountService.DeleteAccount(ctx, GetUserIdFromCtx(ctx), &req); err != nil {
+		if errors.Is(err, v1.ErrPasswordFailed) || errors.Is(err, v1.ErrVerifyCodeInvalid) {
+			v1.HandleError(ctx, http.StatusBadRequest, err, nil)
+			return
+		}
+		v1.HandleError(ctx, http.StatusInternalServerError, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
+
+// ExportData godoc
+// @Summary 导出个人数据
+// @Schemes
+// @Description zip文件，包含profile.json、contacts.json和每个会话的messages/{conversation_id}.json
+// @Tags 用户模块
+// @Produce application/zip
+// @Security Bearer
+// @Success 200 {file} file
+// @Router /user/export [get]
+func (h *AccountHandler) ExportData(ctx *gin.Context) {
+	userId := GetUserIdFromCtx(ctx)
+
+	ctx.Header("Content-Type", "application/zip")
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="export-%v-%v.zip"`,
+		userId, time.Now().Format("20060102")))
+	if err := h.accountService.ExportData(ctx, userId, ctx.Writer); err != nil {
+		h.logger.WithContext(ctx).Error("accountService.ExportData error", zap.Int64("userId", userId), zap.Error(err))
+		// 已经开始输出时只能中断，客户端收到的是不完整的zip
+		if !ctx.Writer.Written() {
+			ctx.Header("Content-Disposition", "")
+			v1.HandleError(ctx, http.StatusInternalServerError, v1.ErrInternalServerError, nil)
+		}
+		ctx.Abort()
+	}
+}
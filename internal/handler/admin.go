@@ -0,0 +1,402 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	v1 "github.com/ljinf/im_server_standalone/api/v1"
+	"github.com/ljinf/im_server_standalone/internal/middleware"
+	"github.com/ljinf/im_server_standalone/internal/service"
+	"net/http"
+	"strconv"
+)
+
+type AdminHandler struct {
+	*Handler
+	srv service.AdminService
+}
+
+func NewAdminHandler(h *Handler, srv service.AdminService) *AdminHandler {
+	return &AdminHandler{
+		Handler: h,
+		srv:     srv,
+	}
+}
+
+// 角色由AdminAuth写入
+func getAdminOperator(ctx *gin.Context) service.AdminOperator {
+	return service.AdminOperator{
+		UserId: GetUserIdFromCtx(ctx),
+		Role:   ctx.GetString(middleware.AdminRoleKey),
+		Ip:     ctx.ClientIP(),
+	}
+}
+
+func handleAdminError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, v1.ErrBadRequest), errors.Is(err, v1.ErrAdminRoleInvalid), errors.Is(err, v1.ErrAdminSelf):
+		v1.HandleError(ctx, http.StatusBadRequest, err, nil)
+	case errors.Is(err, v1.ErrForbidden):
+		v1.HandleError(ctx, http.StatusForbidden, err, nil)
+	case errors.Is(err, v1.ErrNotFound):
+		v1.HandleError(ctx, http.StatusNotFound, err, nil)
+	default:
+		v1.HandleError(ctx, http.StatusInternalServerError, err, nil)
+	}
+}
+
+// GetAdminInfo godoc
+// @Summary 当前管理员
+// @Schemes
+// @Description 当前管理员的角色和权限，用于后台展示菜单
+// @Tags 管理后台
+// @Produce json
+// @Security Bearer
+// @Success 200 {object} v1.Response
+// @Router /admin/me [get]
+func (h *AdminHandler) GetAdminInfo(ctx *gin.Context) {
+	v1.HandleSuccess(ctx, h.srv.GetAdminInfo(ctx, getAdminOperator(ctx)))
+}
+
+// GetUser godoc
+// @Summary 查询用户
+// @Schemes
+// @Description 按用户ID、邮箱或手机号查询账号状态、在线状态和登录设备
+// @Tags 管理后台
+// @Produce json
+// @Security Bearer
+// @Param user_id query int false "用户ID"
+// @Param email query string false "邮箱"
+// @Param phone query string false "手机号"
+// @Success 200 {object} v1.Response
+// @Router /admin/user [get]
+func (h *AdminHandler) GetUser(ctx *gin.Context) {
+	var req v1.AdminUserQuery
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	resp, err := h.srv.GetUser(ctx, &req)
+	if err != nil {
+		handleAdminError(ctx, err)
+		return
+	}
+	v1.HandleSuccess(ctx, resp)
+}
+
+// BanUser godoc
+// @Summary 封禁用户
+// @Schemes
+// @Description 封禁后所有登录设备下线，不能再登录
+// @Tags 管理后台
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Param request body v1.AdminUserReq true "params"
+// @Success 200 {object} v1.Response
+// @Router /admin/user/ban [post]
+func (h *AdminHandler) BanUser(ctx *gin.Context) {
+	var req v1.AdminUserReq
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	if err := h.srv.BanUser(ctx, getAdminOperator(ctx), &req); err != nil {
+		handleAdminError(ctx, err)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
+
+// UnbanUser godoc
+// @Summary 解封用户
+// @Schemes
+// @Description
+// @Tags 管理后台
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Param request body v1.AdminUserReq true "params"
+// @Success 200 {object} v1.Response
+// @Router /admin/user/unban [post]
+func (h *AdminHandler) UnbanUser(ctx *gin.Context) {
+	var req v1.AdminUserReq
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	if err := h.srv.UnbanUser(ctx, getAdminOperator(ctx), &req); err != nil {
+		handleAdminError(ctx, err)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
+
+// ForceLogout godoc
+// @Summary 强制下线
+// @Schemes
+// @Description 吊销用户所有登录会话并断开websocket连接
+// @Tags 管理后台
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Param request body v1.AdminUserReq true "params"
+// @Success 200 {object} v1.Response
+// @Router /admin/user/logout [post]
+func (h *AdminHandler) ForceLogout(ctx *gin.Context) {
+	var req v1.AdminUserReq
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	if err := h.srv.ForceLogout(ctx, getAdminOperator(ctx), &req); err != nil {
+		handleAdminError(ctx, err)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
+
+// GetGroupList godoc
+// @Summary 群组列表
+// @Schemes
+// @Description 按创建时间倒序
+// @Tags 管理后台
+// @Produce json
+// @Security Bearer
+// @Param page_num query int false "页码"
+// @Param page_size query int false "每页数量"
+// @Success 200 {object} v1.Response
+// @Router /admin/group/list [get]
+func (h *AdminHandler) GetGroupList(ctx *gin.Context) {
+	pageInfo := GetPageInfo(ctx)
+	resp, err := h.srv.GetGroupList(ctx, pageInfo.PageNum, pageInfo.PageSize)
+	if err != nil {
+		handleAdminError(ctx, err)
+		return
+	}
+	v1.HandleSuccess(ctx, resp)
+}
+
+// GetGroup godoc
+// @Summary 群组详情
+// @Schemes
+// @Description 群组信息和成员
+// @Tags 管理后台
+// @Produce json
+// @Security Bearer
+// @Param conversationId path int true "会话ID"
+// @Success 200 {object} v1.Response
+// @Router /admin/group/{conversationId} [get]
+func (h *AdminHandler) GetGroup(ctx *gin.Context) {
+	conversationId, err := strconv.ParseInt(ctx.Param("conversationId"), 10, 64)
+	if err != nil || conversationId <= 0 {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	resp, err := h.srv.GetGroup(ctx, conversationId)
+	if err != nil {
+		handleAdminError(ctx, err)
+		return
+	}
+	v1.HandleSuccess(ctx, resp)
+}
+
+// DissolveGroup godoc
+// @Summary 解散群组
+// @Schemes
+// @Description 移除所有成员并通知在线成员，历史消息保留
+// @Tags 管理后台
+// @Produce json
+// @Security Bearer
+// @Param conversationId path int true "会话ID"
+// @Success 200 {object} v1.Response
+// @Router /admin/group/{conversationId} [delete]
+func (h *AdminHandler) DissolveGroup(ctx *gin.Context) {
+	conversationId, err := strconv.ParseInt(ctx.Param("conversationId"), 10, 64)
+	if err != nil || conversationId <= 0 {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	if err = h.srv.DissolveGroup(ctx, getAdminOperator(ctx), conversationId); err != nil {
+		handleAdminError(ctx, err)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
+
+// Announce godoc
+// @Summary 发布系统公告
+// @Schemes
+// @Description 推送给所有节点的在线用户
+// @Tags 管理后台
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Param request body v1.AnnouncementReq true "params"
+// @Success 200 {object} v1.Response
+// @Router /admin/announcement [post]
+func (h *AdminHandler) Announce(ctx *gin.Context) {
+	var req v1.AnnouncementReq
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	resp, err := h.srv.Announce(ctx, getAdminOperator(ctx), &req)
+	if err != nil {
+		handleAdminError(ctx, err)
+		return
+	}
+	v1.HandleSuccess(ctx, resp)
+}
+
+// GetAnnouncementList godoc
+// @Summary 系统公告
+// @Schemes
+// @Description 按发布时间倒序
+// @Tags 公告模块
+// @Produce json
+// @Security Bearer
+// @Param page_num query int false "页码"
+// @Param page_size query int false "每页数量"
+// @Success 200 {object} v1.Response
+// @Router /announcement/list [get]
+func (h *AdminHandler) GetAnnouncementList(ctx *gin.Context) {
+	pageInfo := GetPageInfo(ctx)
+	list, err := h.srv.GetAnnouncementList(ctx, pageInfo.PageNum, pageInfo.PageSize)
+	if err != nil {
+		handleAdminError(ctx, err)
+		return
+	}
+	v1.HandleSuccess(ctx, list)
+}
+
+// GetConversationMsgList godoc
+// @Summary 会话消息
+// @Schemes
+// @Description 合规审查，按seq升序返回，包含已屏蔽和撤回的消息，查看记录操作日志
+// @Tags 管理后台
+// @Produce json
+// @Security Bearer
+// @Param conversationId path int true "会话ID"
+// @Param seq query int false "从该seq之后开始"
+// @Param limit query int false "数量，默认50，最多200"
+// @Success 200 {object} v1.Response
+// @Router /admin/conversation/{conversationId}/msg [get]
+func (h *AdminHandler) GetConversationMsgList(ctx *gin.Context) {
+	conversationId, err := strconv.ParseInt(ctx.Param("conversationId"), 10, 64)
+	if err != nil || conversationId <= 0 {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+	var req v1.AdminMsgListReq
+	if err = ctx.ShouldBindQuery(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+	if req.Limit <= 0 {
+		req.Limit = 50
+	}
+	if req.Limit > 200 {
+		req.Limit = 200
+	}
+
+	list, err := h.srv.GetConversationMsgList(ctx, getAdminOperator(ctx), conversationId, &req)
+	if err != nil {
+		handleAdminError(ctx, err)
+		return
+	}
+	v1.HandleSuccess(ctx, list)
+}
+
+// GetStats godoc
+// @Summary 实时统计
+// @Schemes
+// @Description 当前节点的连接数和慢连接统计，以及所有节点最近每分钟的消息数
+// @Tags 管理后台
+// @Produce json
+// @Security Bearer
+// @Param minutes query int false "最近的分钟数，默认30，最多120"
+// @Success 200 {object} v1.Response
+// @Router /admin/stats [get]
+func (h *AdminHandler) GetStats(ctx *gin.Context) {
+	minutes, _ := strconv.Atoi(ctx.Query("minutes"))
+	resp, err := h.srv.GetStats(ctx, minutes)
+	if err != nil {
+		handleAdminError(ctx, err)
+		return
+	}
+	v1.HandleSuccess(ctx, resp)
+}
+
+// GetAdminList godoc
+// @Summary 管理员列表
+// @Schemes
+// @Description 包含配置文件中的超级管理员
+// @Tags 管理后台
+// @Produce json
+// @Security Bearer
+// @Success 200 {object} v1.Response
+// @Router /admin/admins [get]
+func (h *AdminHandler) GetAdminList(ctx *gin.Context) {
+	list, err := h.srv.GetAdminList(ctx)
+	if err != nil {
+		handleAdminError(ctx, err)
+		return
+	}
+	v1.HandleSuccess(ctx, list)
+}
+
+// SaveAdmin godoc
+// @Summary 分配角色
+// @Schemes
+// @Description 新增管理员或修改角色，不能修改自己和配置文件中的超级管理员
+// @Tags 管理后台
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Param request body v1.SaveAdminReq true "params"
+// @Success 200 {object} v1.Response
+// @Router /admin/admins [put]
+func (h *AdminHandler) SaveAdmin(ctx *gin.Context) {
+	var req v1.SaveAdminReq
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	if err := h.srv.SaveAdmin(ctx, getAdminOperator(ctx), &req); err != nil {
+		handleAdminError(ctx, err)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
+
+// DelAdmin godoc
+// @Summary 移除管理员
+// @Schemes
+// @Description
+// @Tags 管理后台
+// @Produce json
+// @Security Bearer
+// @Param userId path int true "用户ID"
+// @Success 200 {object} v1.Response
+// @Router /admin/admins/{userId} [delete]
+func (h *AdminHandler) DelAdmin(ctx *gin.Context) {
+	userId, err := strconv.ParseInt(ctx.Param("userId"), 10, 64)
+	if err != nil || userId <= 0 {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	if err = h.srv.DelAdmin(ctx, getAdminOperator(ctx), userId); err != nil {
+		handleAdminError(ctx, err)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
@@ -1,12 +1,15 @@
 package handler
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	v1 "github.com/ljinf/im_server_standalone/api/v1"
 	"github.com/ljinf/im_server_standalone/internal/model"
 	"github.com/ljinf/im_server_standalone/internal/service"
+	"github.com/ljinf/im_server_standalone/pkg/contants"
 	"go.uber.org/zap"
 	"net/http"
+	"strconv"
 )
 
 type ChatHandler struct {
@@ -42,12 +45,26 @@ func (h *ChatHandler) SendChatMessage(ctx *gin.Context) {
 	msgResp, err := h.srv.CreateMsg(ctx, &params)
 	if err != nil {
 		h.logger.Error(err.Error(), zap.Any("param", params))
-		v1.HandleError(ctx, http.StatusOK, v1.ErrInternalServerError, nil)
+		if errors.Is(err, v1.ErrSendTooFrequent) || errors.Is(err, v1.ErrDuplicateMsg) {
+			v1.HandleError(ctx, http.StatusTooManyRequests, err, nil)
+			return
+		}
+		if errors.Is(err, v1.ErrMsgRejected) {
+			v1.HandleError(ctx, http.StatusUnprocessableEntity, err, nil)
+			return
+		}
+		if errors.Is(err, v1.ErrUserMuted) {
+			v1.HandleError(ctx, http.StatusForbidden, err, nil)
+			return
+		}
+		v1.HandleError(ctx, http.StatusOK, err, nil)
 		return
 	}
 
-	// 转发给target
-	h.socketSrv.SyncPushMsg(msgResp, params.TargetId)
+	// 转发给target，屏蔽的消息和进入对方消息请求的不转发
+	if msgResp.Status != contants.MsgStatusBlocked && !msgResp.TargetRequest {
+		h.socketSrv.SyncPushMsg(msgResp, params.TargetId)
+	}
 
 	v1.HandleSuccess(ctx, msgResp)
 }
@@ -77,6 +94,29 @@ func (h *ChatHandler) GetUserConversationList(ctx *gin.Context) {
 	v1.HandleSuccess(ctx, conversationList)
 }
 
+// 与某个用户的单聊会话，不存在则创建
+func (h *ChatHandler) GetC2CConversation(ctx *gin.Context) {
+	userId := GetUserIdFromCtx(ctx)
+	if userId == 0 {
+		v1.HandleError(ctx, http.StatusOK, v1.ErrUnauthorized, nil)
+		return
+	}
+
+	targetId, err := strconv.ParseInt(ctx.Param("userId"), 10, 64)
+	if err != nil || targetId <= 0 || targetId == userId {
+		v1.HandleError(ctx, http.StatusOK, v1.ErrBadRequest, nil)
+		return
+	}
+
+	conversation, err := h.srv.GetC2CConversation(ctx, userId, targetId)
+	if err != nil {
+		h.logger.Error(err.Error(), zap.Any("userId", userId), zap.Any("targetId", targetId))
+		v1.HandleError(ctx, http.StatusOK, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, conversation)
+}
+
 // 消息列表
 func (h *ChatHandler) GetUserMsgList(ctx *gin.Context) {
 
@@ -125,3 +165,72 @@ func (h *ChatHandler) ReportReadMsgSeq(ctx *gin.Context) {
 	}
 	v1.HandleSuccess(ctx, nil)
 }
+
+// 陌生人消息请求列表
+func (h *ChatHandler) GetMsgRequestList(ctx *gin.Context) {
+	userId := GetUserIdFromCtx(ctx)
+	if userId == 0 {
+		v1.HandleError(ctx, http.StatusOK, v1.ErrUnauthorized, nil)
+		return
+	}
+
+	var params v1.ConversationMsgListReq
+	if err := ctx.ShouldBind(&params); err != nil {
+		h.logger.Error(err.Error())
+		v1.HandleError(ctx, http.StatusOK, v1.ErrBadRequest, nil)
+		return
+	}
+
+	requestList, err := h.srv.GetMsgRequestList(ctx, userId, int64(params.PageNum), int64(params.PageSize))
+	if err != nil {
+		h.logger.Error(err.Error(), zap.Any("userId", userId))
+		v1.HandleError(ctx, http.StatusOK, err, nil)
+		return
+	}
+
+	v1.HandleSuccess(ctx, requestList)
+}
+
+// 接受消息请求
+func (h *ChatHandler) AcceptMsgRequest(ctx *gin.Context) {
+	userId := GetUserIdFromCtx(ctx)
+	if userId == 0 {
+		v1.HandleError(ctx, http.StatusOK, v1.ErrUnauthorized, nil)
+		return
+	}
+
+	var params v1.MsgRequestReq
+	if err := ctx.ShouldBind(&params); err != nil {
+		h.logger.Error(err.Error())
+		v1.HandleError(ctx, http.StatusOK, v1.ErrBadRequest, nil)
+		return
+	}
+
+	if err := h.srv.AcceptMsgRequest(ctx, userId, params.ConversationId); err != nil {
+		v1.HandleError(ctx, http.StatusOK, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
+
+// 忽略消息请求
+func (h *ChatHandler) IgnoreMsgRequest(ctx *gin.Context) {
+	userId := GetUserIdFromCtx(ctx)
+	if userId == 0 {
+		v1.HandleError(ctx, http.StatusOK, v1.ErrUnauthorized, nil)
+		return
+	}
+
+	var params v1.MsgRequestReq
+	if err := ctx.ShouldBind(&params); err != nil {
+		h.logger.Error(err.Error())
+		v1.HandleError(ctx, http.StatusOK, v1.ErrBadRequest, nil)
+		return
+	}
+
+	if err := h.srv.IgnoreMsgRequest(ctx, userId, params.ConversationId); err != nil {
+		v1.HandleError(ctx, http.StatusOK, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
@@ -26,6 +26,16 @@ func GetUserIdFromCtx(ctx *gin.Context) int64 {
 	return userInfo.UserId
 }
 
+// access token的jti即登录会话id
+func GetSessionIdFromCtx(ctx *gin.Context) string {
+	v, exists := ctx.Get("claims")
+	if !exists {
+		return ""
+	}
+	userInfo := v.(*jwt.MyCustomClaims)
+	return userInfo.ID
+}
+
 type PageInfo struct {
 	PageNum  int `json:"page_num"`
 	PageSize int `json:"page_size"`
@@ -7,20 +7,19 @@ import (
 	"github.com/ljinf/im_server_standalone/pkg/contants"
 	"go.uber.org/zap"
 	"net/http"
-	"time"
 )
 
 type RelationshipHandler struct {
 	*Handler
-	srv   service.RelationshipService
-	imSrv service.ChatService
+	srv       service.RelationshipService
+	socketSrv service.WebsocketService
 }
 
-func NewRelationshipHandler(h *Handler, srv service.RelationshipService, imsvr service.ChatService) *RelationshipHandler {
+func NewRelationshipHandler(h *Handler, srv service.RelationshipService, socket service.WebsocketService) *RelationshipHandler {
 	return &RelationshipHandler{
-		Handler: h,
-		srv:     srv,
-		imSrv:   imsvr,
+		Handler:   h,
+		srv:       srv,
+		socketSrv: socket,
 	}
 }
 
@@ -74,23 +73,15 @@ func (h *RelationshipHandler) UpdateApplyFriendshipInfo(ctx *gin.Context) {
 	}
 
 	param.UserId = userId
-	if err := h.srv.UpdateApplyFriendshipInfo(ctx, &param); err != nil {
+	greeting, err := h.srv.UpdateApplyFriendshipInfo(ctx, &param)
+	if err != nil {
 		v1.HandleError(ctx, http.StatusOK, err, nil)
 		return
 	}
 
-	if param.Status == contants.ApplyFriendshipStatusApproved {
-		msgReq := &v1.SendMsgReq{
-			UserId:      userId,
-			TargetId:    param.TargetId,
-			Content:     contants.ChatSayHello,
-			ContentType: contants.MsgContentTypeTxt,
-			SendTime:    time.Now().Unix(),
-		}
-		_, err := h.imSrv.CreateMsg(ctx, msgReq)
-		if err != nil {
-			h.logger.Error(err.Error())
-		}
+	// 通过申请，通知双方新的会话
+	if greeting != nil {
+		h.socketSrv.SyncPushMsg(greeting, userId, param.TargetId)
 	}
 
 	v1.HandleSuccess(ctx, nil)
@@ -140,7 +131,7 @@ func (h *RelationshipHandler) GetRelationshipList(ctx *gin.Context) {
 		param.PageSize = 30
 	}
 
-	list, err := h.srv.GetRelationshipList(ctx, userId, param.RelationshipType, param.PageNum, param.PageSize)
+	list, err := h.srv.GetRelationshipList(ctx, userId, param.RelationshipType, param.TagId, param.PageNum, param.PageSize)
 	if err != nil {
 		v1.HandleError(ctx, http.StatusOK, err, nil)
 		return
@@ -165,13 +156,104 @@ func (h *RelationshipHandler) AddRelationshipFollow(ctx *gin.Context) {
 	param.UserId = userId
 	param.RelationshipType = contants.RelationshipTypeFollow
 
-	if err := h.srv.AddRelationshipFollow(ctx, &param); err != nil {
+	greeting, err := h.srv.AddRelationshipFollow(ctx, &param)
+	if err != nil {
 		v1.HandleError(ctx, http.StatusOK, err, nil)
 		return
 	}
+
+	// 互相关注自动成为好友，通知双方新的会话
+	if greeting != nil {
+		h.socketSrv.SyncPushMsg(greeting, userId, param.TargetId)
+	}
+
 	v1.HandleSuccess(ctx, nil)
 }
 
+// 取消关注
+func (h *RelationshipHandler) DelRelationshipFollow(ctx *gin.Context) {
+	userId := GetUserIdFromCtx(ctx)
+	if userId == 0 {
+		v1.HandleError(ctx, http.StatusOK, v1.ErrUnauthorized, nil)
+		return
+	}
+
+	var param v1.FollowRequest
+	if err := ctx.ShouldBind(&param); err != nil {
+		h.logger.Error(err.Error())
+		v1.HandleError(ctx, http.StatusOK, v1.ErrBadRequest, nil)
+		return
+	}
+	param.UserId = userId
+
+	if err := h.srv.DelRelationshipFollow(ctx, &param); err != nil {
+		v1.HandleError(ctx, http.StatusOK, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
+
+// 关注列表
+func (h *RelationshipHandler) GetFollowingList(ctx *gin.Context) {
+	h.getFollowList(ctx, false)
+}
+
+// 粉丝列表
+func (h *RelationshipHandler) GetFollowerList(ctx *gin.Context) {
+	h.getFollowList(ctx, true)
+}
+
+func (h *RelationshipHandler) getFollowList(ctx *gin.Context, followers bool) {
+	userId := GetUserIdFromCtx(ctx)
+	if userId == 0 {
+		v1.HandleError(ctx, http.StatusOK, v1.ErrUnauthorized, nil)
+		return
+	}
+
+	var param v1.FollowListReq
+	if err := ctx.ShouldBind(&param); err != nil {
+		h.logger.Error(err.Error())
+		v1.HandleError(ctx, http.StatusOK, v1.ErrBadRequest, nil)
+		return
+	}
+	if param.PageSize <= 0 || param.PageSize > 100 {
+		param.PageSize = 30
+	}
+
+	list, err := h.srv.GetFollowList(ctx, userId, followers, param.Cursor, param.PageSize)
+	if err != nil {
+		v1.HandleError(ctx, http.StatusOK, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, list)
+}
+
+// 关注数和粉丝数
+func (h *RelationshipHandler) GetFollowCount(ctx *gin.Context) {
+	userId := GetUserIdFromCtx(ctx)
+	if userId == 0 {
+		v1.HandleError(ctx, http.StatusOK, v1.ErrUnauthorized, nil)
+		return
+	}
+
+	var param v1.FollowCountReq
+	if err := ctx.ShouldBind(&param); err != nil {
+		h.logger.Error(err.Error())
+		v1.HandleError(ctx, http.StatusOK, v1.ErrBadRequest, nil)
+		return
+	}
+	if param.UserId == 0 {
+		param.UserId = userId
+	}
+
+	count, err := h.srv.GetFollowCount(ctx, param.UserId)
+	if err != nil {
+		v1.HandleError(ctx, http.StatusOK, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, count)
+}
+
 // 修改关系
 func (h *RelationshipHandler) UpdateRelationship(ctx *gin.Context) {
 
@@ -223,3 +305,200 @@ func (h *RelationshipHandler) DelRelationship(ctx *gin.Context) {
 
 	v1.HandleSuccess(ctx, nil)
 }
+
+// 通讯录增量同步
+func (h *RelationshipHandler) SyncContacts(ctx *gin.Context) {
+	userId := GetUserIdFromCtx(ctx)
+	if userId == 0 {
+		v1.HandleError(ctx, http.StatusOK, v1.ErrUnauthorized, nil)
+		return
+	}
+
+	var param v1.ContactSyncReq
+	if err := ctx.ShouldBind(&param); err != nil {
+		h.logger.Error(err.Error())
+		v1.HandleError(ctx, http.StatusOK, v1.ErrBadRequest, nil)
+		return
+	}
+	if param.PageSize <= 0 || param.PageSize > 500 {
+		param.PageSize = 200
+	}
+
+	resp, err := h.srv.SyncContacts(ctx, userId, param.Version, param.PageSize)
+	if err != nil {
+		v1.HandleError(ctx, http.StatusOK, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, resp)
+}
+
+// 新建分组标签
+func (h *RelationshipHandler) AddTag(ctx *gin.Context) {
+	userId := GetUserIdFromCtx(ctx)
+	if userId == 0 {
+		v1.HandleError(ctx, http.StatusOK, v1.ErrUnauthorized, nil)
+		return
+	}
+
+	var param v1.TagRequest
+	if err := ctx.ShouldBind(&param); err != nil {
+		h.logger.Error(err.Error())
+		v1.HandleError(ctx, http.StatusOK, v1.ErrBadRequest, nil)
+		return
+	}
+
+	param.UserId = userId
+	tag, err := h.srv.AddTag(ctx, &param)
+	if err != nil {
+		v1.HandleError(ctx, http.StatusOK, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, tag)
+}
+
+// 重命名分组标签
+func (h *RelationshipHandler) UpdateTag(ctx *gin.Context) {
+	userId := GetUserIdFromCtx(ctx)
+	if userId == 0 {
+		v1.HandleError(ctx, http.StatusOK, v1.ErrUnauthorized, nil)
+		return
+	}
+
+	var param v1.TagRequest
+	if err := ctx.ShouldBind(&param); err != nil || param.TagId == 0 {
+		v1.HandleError(ctx, http.StatusOK, v1.ErrBadRequest, nil)
+		return
+	}
+
+	param.UserId = userId
+	if err := h.srv.UpdateTag(ctx, &param); err != nil {
+		v1.HandleError(ctx, http.StatusOK, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
+
+// 删除分组标签
+func (h *RelationshipHandler) DelTag(ctx *gin.Context) {
+	userId := GetUserIdFromCtx(ctx)
+	if userId == 0 {
+		v1.HandleError(ctx, http.StatusOK, v1.ErrUnauthorized, nil)
+		return
+	}
+
+	var param v1.DelTagRequest
+	if err := ctx.ShouldBind(&param); err != nil {
+		h.logger.Error(err.Error())
+		v1.HandleError(ctx, http.StatusOK, v1.ErrBadRequest, nil)
+		return
+	}
+
+	param.UserId = userId
+	if err := h.srv.DelTag(ctx, &param); err != nil {
+		v1.HandleError(ctx, http.StatusOK, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
+
+// 分组标签列表
+func (h *RelationshipHandler) GetTagList(ctx *gin.Context) {
+	userId := GetUserIdFromCtx(ctx)
+	if userId == 0 {
+		v1.HandleError(ctx, http.StatusOK, v1.ErrUnauthorized, nil)
+		return
+	}
+
+	list, err := h.srv.GetTagList(ctx, userId)
+	if err != nil {
+		v1.HandleError(ctx, http.StatusOK, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, list)
+}
+
+// 设置好友所属分组
+func (h *RelationshipHandler) SetRelationshipTags(ctx *gin.Context) {
+	userId := GetUserIdFromCtx(ctx)
+	if userId == 0 {
+		v1.HandleError(ctx, http.StatusOK, v1.ErrUnauthorized, nil)
+		return
+	}
+
+	var param v1.SetRelationshipTagsRequest
+	if err := ctx.ShouldBind(&param); err != nil {
+		h.logger.Error(err.Error())
+		v1.HandleError(ctx, http.StatusOK, v1.ErrBadRequest, nil)
+		return
+	}
+
+	param.UserId = userId
+	if err := h.srv.SetRelationshipTags(ctx, &param); err != nil {
+		v1.HandleError(ctx, http.StatusOK, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
+
+// 拉黑
+func (h *RelationshipHandler) AddBlock(ctx *gin.Context) {
+	userId := GetUserIdFromCtx(ctx)
+	if userId == 0 {
+		v1.HandleError(ctx, http.StatusOK, v1.ErrUnauthorized, nil)
+		return
+	}
+
+	var param v1.BlockRequest
+	if err := ctx.ShouldBind(&param); err != nil {
+		h.logger.Error(err.Error())
+		v1.HandleError(ctx, http.StatusOK, v1.ErrBadRequest, nil)
+		return
+	}
+
+	param.UserId = userId
+	if err := h.srv.AddBlock(ctx, &param); err != nil {
+		v1.HandleError(ctx, http.StatusOK, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
+
+// 移出黑名单
+func (h *RelationshipHandler) DelBlock(ctx *gin.Context) {
+	userId := GetUserIdFromCtx(ctx)
+	if userId == 0 {
+		v1.HandleError(ctx, http.StatusOK, v1.ErrUnauthorized, nil)
+		return
+	}
+
+	var param v1.BlockRequest
+	if err := ctx.ShouldBind(&param); err != nil {
+		h.logger.Error(err.Error())
+		v1.HandleError(ctx, http.StatusOK, v1.ErrBadRequest, nil)
+		return
+	}
+
+	param.UserId = userId
+	if err := h.srv.DelBlock(ctx, &param); err != nil {
+		v1.HandleError(ctx, http.StatusOK, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
+
+// 黑名单列表
+func (h *RelationshipHandler) GetBlockList(ctx *gin.Context) {
+	userId := GetUserIdFromCtx(ctx)
+	if userId == 0 {
+		v1.HandleError(ctx, http.StatusOK, v1.ErrUnauthorized, nil)
+		return
+	}
+
+	pageInfo := GetPageInfo(ctx)
+	list, err := h.srv.GetBlockList(ctx, userId, pageInfo.PageNum, pageInfo.PageSize)
+	if err != nil {
+		v1.HandleError(ctx, http.StatusOK, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, list)
+}
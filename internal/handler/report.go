@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	v1 "github.com/ljinf/im_server_standalone/api/v1"
+	"github.com/ljinf/im_server_standalone/internal/service"
+	"net/http"
+)
+
+type ReportHandler struct {
+	*Handler
+	srv service.ReportService
+}
+
+func NewReportHandler(h *Handler, srv service.ReportService) *ReportHandler {
+	return &ReportHandler{
+		Handler: h,
+		srv:     srv,
+	}
+}
+
+// CreateReport godoc
+// @Summary 举报
+// @Schemes
+// @Description 举报消息、用户或群组，同一对象的举报处理前不能重复提交
+// @Tags 举报模块
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Param request body v1.CreateReportReq true "params"
+// @Success 200 {object} v1.Response
+// @Router /report [post]
+func (h *ReportHandler) CreateReport(ctx *gin.Context) {
+	var req v1.CreateReportReq
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+	req.UserId = GetUserIdFromCtx(ctx)
+
+	resp, err := h.srv.CreateReport(ctx, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, v1.ErrBadRequest):
+			v1.HandleError(ctx, http.StatusBadRequest, err, nil)
+		case errors.Is(err, v1.ErrNotFound):
+			v1.HandleError(ctx, http.StatusNotFound, err, nil)
+		case errors.Is(err, v1.ErrReportDuplicate):
+			v1.HandleError(ctx, http.StatusConflict, err, nil)
+		default:
+			v1.HandleError(ctx, http.StatusInternalServerError, err, nil)
+		}
+		return
+	}
+	v1.HandleSuccess(ctx, resp)
+}
+
+// GetUserReportList godoc
+// @Summary 我的举报
+// @Schemes
+// @Description 自己提交的举报及处理状态
+// @Tags 举报模块
+// @Produce json
+// @Security Bearer
+// @Param page_num query int false "页码"
+// @Param page_size query int false "每页数量"
+// @Success 200 {object} v1.Response
+// @Router /report/list [get]
+func (h *ReportHandler) GetUserReportList(ctx *gin.Context) {
+	pageInfo := GetPageInfo(ctx)
+	list, err := h.srv.GetUserReportList(ctx, GetUserIdFromCtx(ctx), pageInfo.PageNum, pageInfo.PageSize)
+	if err != nil {
+		v1.HandleError(ctx, http.StatusInternalServerError, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, list)
+}
+
+// GetReportList godoc
+// @Summary 举报审核队列
+// @Schemes
+// @Description 默认返回待处理的举报，按提交时间先后排列
+// @Tags 管理后台
+// @Produce json
+// @Security Bearer
+// @Param status query int false "状态 0待处理 1已处理 2已驳回，-1全部"
+// @Param target_type query int false "举报对象 1消息 2用户 3群组"
+// @Param target_id query int false "对象ID"
+// @Param page_num query int false "页码"
+// @Param page_size query int false "每页数量"
+// @Success 200 {object} v1.Response
+// @Router /admin/report/list [get]
+func (h *ReportHandler) GetReportList(ctx *gin.Context) {
+	var req v1.ReportListReq
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+	if req.PageNum <= 0 {
+		req.PageNum = 1
+	}
+	if req.PageSize <= 0 || req.PageSize > 100 {
+		req.PageSize = 30
+	}
+
+	resp, err := h.srv.GetReportList(ctx, &req)
+	if err != nil {
+		v1.HandleError(ctx, http.StatusInternalServerError, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, resp)
+}
+
+// ResolveReport godoc
+// @Summary 处理举报
+// @Schemes
+// @Description 驳回、屏蔽消息、禁言或封禁被举报的用户，处理结果通知举报人。禁言和封禁需要user:ban权限
+// @Tags 管理后台
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Param request body v1.ResolveReportReq true "params"
+// @Success 200 {object} v1.Response
+// @Router /admin/report/resolve [post]
+func (h *ReportHandler) ResolveReport(ctx *gin.Context) {
+	var req v1.ResolveReportReq
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+	req.HandlerId = GetUserIdFromCtx(ctx)
+
+	if err := h.srv.ResolveReport(ctx, getAdminOperator(ctx), &req); err != nil {
+		switch {
+		case errors.Is(err, v1.ErrForbidden):
+			v1.HandleError(ctx, http.StatusForbidden, err, nil)
+		case errors.Is(err, v1.ErrNotFound):
+			v1.HandleError(ctx, http.StatusNotFound, err, nil)
+		case errors.Is(err, v1.ErrReportHandled):
+			v1.HandleError(ctx, http.StatusConflict, err, nil)
+		case errors.Is(err, v1.ErrReportAction):
+			v1.HandleError(ctx, http.StatusBadRequest, err, nil)
+		default:
+			v1.HandleError(ctx, http.StatusInternalServerError, err, nil)
+		}
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
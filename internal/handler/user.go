@@ -1,11 +1,13 @@
 package handler
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/ljinf/im_server_standalone/api/v1"
 	"github.com/ljinf/im_server_standalone/internal/service"
 	"go.uber.org/zap"
 	"net/http"
+	"strconv"
 )
 
 type UserHandler struct {
@@ -62,15 +64,252 @@ func (h *UserHandler) Login(ctx *gin.Context) {
 		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
 		return
 	}
+	req.Ip = ctx.ClientIP()
+	req.UserAgent = ctx.Request.UserAgent()
 
 	token, err := h.userService.Login(ctx, &req)
 	if err != nil {
+		if errors.Is(err, v1.ErrLoginTooFrequent) || errors.Is(err, v1.ErrAccountLocked) {
+			v1.HandleError(ctx, http.StatusTooManyRequests, err, nil)
+			return
+		}
+		if errors.Is(err, v1.ErrUserBanned) {
+			v1.HandleError(ctx, http.StatusForbidden, err, nil)
+			return
+		}
 		v1.HandleError(ctx, http.StatusUnauthorized, v1.ErrUnauthorized, nil)
 		return
 	}
-	v1.HandleSuccess(ctx, v1.LoginResponseData{
-		AccessToken: token,
-	})
+	v1.HandleSuccess(ctx, token)
+}
+
+// RefreshToken godoc
+// @Summary 刷新登录令牌
+// @Schemes
+// @Description refreshToken只能使用一次，重复使用已刷新过的refreshToken会吊销整个会话
+// @Tags 用户模块
+// @Accept json
+// @Produce json
+// @Param request body v1.RefreshTokenRequest true "params"
+// @Success 200 {object} v1.LoginResponse
+// @Router /token/refresh [post]
+func (h *UserHandler) RefreshToken(ctx *gin.Context) {
+	var req v1.RefreshTokenRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	token, err := h.userService.RefreshToken(ctx, &req)
+	if err != nil {
+		if errors.Is(err, v1.ErrUnauthorized) {
+			v1.HandleError(ctx, http.StatusUnauthorized, v1.ErrUnauthorized, nil)
+			return
+		}
+		v1.HandleError(ctx, http.StatusInternalServerError, v1.ErrInternalServerError, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, token)
+}
+
+// GetSessionList godoc
+// @Summary 登录设备列表
+// @Schemes
+// @Description
+// @Tags 用户模块
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Success 200 {object} []v1.SessionResp
+// @Router /session/list [get]
+func (h *UserHandler) GetSessionList(ctx *gin.Context) {
+	userId := GetUserIdFromCtx(ctx)
+	if userId == 0 {
+		v1.HandleError(ctx, http.StatusUnauthorized, v1.ErrUnauthorized, nil)
+		return
+	}
+
+	list, err := h.userService.GetSessionList(ctx, userId, GetSessionIdFromCtx(ctx))
+	if err != nil {
+		v1.HandleError(ctx, http.StatusInternalServerError, v1.ErrInternalServerError, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, list)
+}
+
+// RevokeSession godoc
+// @Summary 下线登录设备
+// @Schemes
+// @Description 吊销该设备的会话，在线时断开其websocket连接
+// @Tags 用户模块
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Param request body v1.RevokeSessionRequest true "params"
+// @Success 200 {object} v1.Response
+// @Router /session [delete]
+func (h *UserHandler) RevokeSession(ctx *gin.Context) {
+	userId := GetUserIdFromCtx(ctx)
+	if userId == 0 {
+		v1.HandleError(ctx, http.StatusUnauthorized, v1.ErrUnauthorized, nil)
+		return
+	}
+
+	var req v1.RevokeSessionRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	if err := h.userService.RevokeSession(ctx, userId, req.SessionId); err != nil {
+		if errors.Is(err, v1.ErrNotFound) {
+			v1.HandleError(ctx, http.StatusNotFound, v1.ErrNotFound, nil)
+			return
+		}
+		v1.HandleError(ctx, http.StatusInternalServerError, v1.ErrInternalServerError, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
+
+// Logout godoc
+// @Summary 退出登录
+// @Schemes
+// @Description 吊销当前会话，并断开该会话的websocket连接
+// @Tags 用户模块
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Success 200 {object} v1.Response
+// @Router /logout [post]
+func (h *UserHandler) Logout(ctx *gin.Context) {
+	userId := GetUserIdFromCtx(ctx)
+	if userId == 0 {
+		v1.HandleError(ctx, http.StatusUnauthorized, v1.ErrUnauthorized, nil)
+		return
+	}
+
+	if err := h.userService.Logout(ctx, userId, GetSessionIdFromCtx(ctx)); err != nil {
+		v1.HandleError(ctx, http.StatusInternalServerError, v1.ErrInternalServerError, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
+
+// SendCode godoc
+// @Summary 发送手机验证码
+// @Schemes
+// @Description 同一手机号有发送间隔和每日上限
+// @Tags 用户模块
+// @Accept json
+// @Produce json
+// @Param request body v1.SendCodeRequest true "params"
+// @Success 200 {object} v1.Response
+// @Router /verify/code [post]
+func (h *UserHandler) SendCode(ctx *gin.Context) {
+	var req v1.SendCodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	if err := h.userService.SendCode(ctx, &req); err != nil {
+		if errors.Is(err, v1.ErrInternalServerError) {
+			v1.HandleError(ctx, http.StatusInternalServerError, err, nil)
+			return
+		}
+		v1.HandleError(ctx, http.StatusBadRequest, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
+
+// SendAccountCode godoc
+// @Summary 发送验证码到已绑定手机号
+// @Schemes
+// @Description 未设置过密码的账号修改邮箱、手机号、密码或注销账号时代替密码校验
+// @Tags 用户模块
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Param request body v1.SendAccountCodeRequest true "params"
+// @Success 200 {object} v1.Response
+// @Router /verify/code/account [post]
+func (h *UserHandler) SendAccountCode(ctx *gin.Context) {
+	var req v1.SendAccountCodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	if err := h.userService.SendAccountCode(ctx, GetUserIdFromCtx(ctx), &req); err != nil {
+		if errors.Is(err, v1.ErrInternalServerError) {
+			v1.HandleError(ctx, http.StatusInternalServerError, err, nil)
+			return
+		}
+		v1.HandleError(ctx, http.StatusBadRequest, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
+
+// RegisterByPhone godoc
+// @Summary 手机号注册
+// @Schemes
+// @Description 先以register场景发送验证码
+// @Tags 用户模块
+// @Accept json
+// @Produce json
+// @Param request body v1.PhoneRegisterRequest true "params"
+// @Success 200 {object} v1.Response
+// @Router /register/phone [post]
+func (h *UserHandler) RegisterByPhone(ctx *gin.Context) {
+	var req v1.PhoneRegisterRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	if err := h.userService.RegisterByPhone(ctx, &req); err != nil {
+		if errors.Is(err, v1.ErrVerifyCodeInvalid) || errors.Is(err, v1.ErrPhoneAlreadyUse) {
+			v1.HandleError(ctx, http.StatusBadRequest, err, nil)
+			return
+		}
+		v1.HandleError(ctx, http.StatusInternalServerError, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
+
+// LoginByPhone godoc
+// @Summary 手机验证码登录
+// @Schemes
+// @Description 先以login场景发送验证码
+// @Tags 用户模块
+// @Accept json
+// @Produce json
+// @Param request body v1.PhoneLoginRequest true "params"
+// @Success 200 {object} v1.LoginResponse
+// @Router /login/phone [post]
+func (h *UserHandler) LoginByPhone(ctx *gin.Context) {
+	var req v1.PhoneLoginRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+	req.Ip = ctx.ClientIP()
+	req.UserAgent = ctx.Request.UserAgent()
+
+	token, err := h.userService.LoginByPhone(ctx, &req)
+	if err != nil {
+		if errors.Is(err, v1.ErrUserBanned) {
+			v1.HandleError(ctx, http.StatusForbidden, err, nil)
+			return
+		}
+		v1.HandleError(ctx, http.StatusUnauthorized, v1.ErrUnauthorized, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, token)
 }
 
 // GetProfile godoc
@@ -99,10 +338,47 @@ func (h *UserHandler) GetProfile(ctx *gin.Context) {
 	v1.HandleSuccess(ctx, user)
 }
 
+// GetUserProfile godoc
+// @Summary 查看他人资料
+// @Schemes
+// @Description 存在拉黑关系时返回404
+// @Tags 用户模块
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Param userId path int true "用户ID"
+// @Success 200 {object} v1.GetUserProfileResponseData
+// @Router /user/{userId} [get]
+func (h *UserHandler) GetUserProfile(ctx *gin.Context) {
+	viewerId := GetUserIdFromCtx(ctx)
+	if viewerId == 0 {
+		v1.HandleError(ctx, http.StatusUnauthorized, v1.ErrUnauthorized, nil)
+		return
+	}
+
+	userId, err := strconv.ParseInt(ctx.Param("userId"), 10, 64)
+	if err != nil || userId <= 0 {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	user, err := h.userService.GetUserProfile(ctx, viewerId, userId)
+	if err != nil {
+		if errors.Is(err, v1.ErrNotFound) {
+			v1.HandleError(ctx, http.StatusNotFound, v1.ErrNotFound, nil)
+			return
+		}
+		v1.HandleError(ctx, http.StatusInternalServerError, v1.ErrInternalServerError, nil)
+		return
+	}
+
+	v1.HandleSuccess(ctx, user)
+}
+
 // UpdateProfile godoc
 // @Summary 修改用户信息
 // @Schemes
-// @Description
+// @Description 签名、生日、地区和自定义字段可分别设置可见范围
 // @Tags 用户模块
 // @Accept json
 // @Produce json
@@ -120,9 +396,232 @@ func (h *UserHandler) UpdateProfile(ctx *gin.Context) {
 	}
 
 	if err := h.userService.UpdateProfile(ctx, userId, &req); err != nil {
+		if errors.Is(err, v1.ErrProfileFieldInvalid) {
+			v1.HandleError(ctx, http.StatusBadRequest, err, nil)
+			return
+		}
+		v1.HandleError(ctx, http.StatusInternalServerError, v1.ErrInternalServerError, nil)
+		return
+	}
+
+	v1.HandleSuccess(ctx, nil)
+}
+
+// GetPrivacy godoc
+// @Summary 获取隐私设置
+// @Schemes
+// @Description
+// @Tags 用户模块
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Success 200 {object} v1.GetPrivacyResponseData
+// @Router /user/privacy [get]
+func (h *UserHandler) GetPrivacy(ctx *gin.Context) {
+	userId := GetUserIdFromCtx(ctx)
+
+	privacy, err := h.userService.GetPrivacy(ctx, userId)
+	if err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	v1.HandleSuccess(ctx, privacy)
+}
+
+// SearchUser godoc
+// @Summary 搜索用户
+// @Schemes
+// @Description 邮箱、手机号精确匹配，其他按昵称前缀匹配。对方关闭了对应的搜索方式或拉黑了自己时不返回
+// @Tags 用户模块
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Param keyword query string true "关键字"
+// @Success 200 {object} []v1.SearchUserItem
+// @Router /user/search [get]
+func (h *UserHandler) SearchUser(ctx *gin.Context) {
+	userId := GetUserIdFromCtx(ctx)
+	if userId == 0 {
+		v1.HandleError(ctx, http.StatusUnauthorized, v1.ErrUnauthorized, nil)
+		return
+	}
+
+	var req v1.SearchUserRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	list, err := h.userService.SearchUser(ctx, userId, req.Keyword)
+	if err != nil {
+		if errors.Is(err, v1.ErrBadRequest) {
+			v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+			return
+		}
 		v1.HandleError(ctx, http.StatusInternalServerError, v1.ErrInternalServerError, nil)
 		return
 	}
 
+	v1.HandleSuccess(ctx, list)
+}
+
+// UpdatePrivacy godoc
+// @Summary 修改隐私设置
+// @Schemes
+// @Description 谁可以给我发消息，不允许的用户发来的消息进入消息请求
+// @Tags 用户模块
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Param request body v1.UpdatePrivacyRequest true "params"
+// @Success 200 {object} v1.Response
+// @Router /user/privacy [put]
+func (h *UserHandler) UpdatePrivacy(ctx *gin.Context) {
+	userId := GetUserIdFromCtx(ctx)
+
+	var req v1.UpdatePrivacyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	if err := h.userService.UpdatePrivacy(ctx, userId, &req); err != nil {
+		v1.HandleError(ctx, http.StatusInternalServerError, v1.ErrInternalServerError, nil)
+		return
+	}
+
+	v1.HandleSuccess(ctx, nil)
+}
+
+// UpdateRegisterInfo godoc
+// @Summary 修改邮箱、手机号、密码
+// @Schemes
+// @Description 需要校验当前密码，未设置过密码的需要已绑定手机号的验证码，修改邮箱后需重新验证，修改密码后其他设备需要重新登录
+// @Tags 用户模块
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Param request body v1.UpdateRegisterInfoRequest true "params"
+// @Success 200 {object} v1.Response
+// @Router /user/account [put]
+func (h *UserHandler) UpdateRegisterInfo(ctx *gin.Context) {
+	var req v1.UpdateRegisterInfoRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	if err := h.userService.UpdateRegisterInfo(ctx, GetUserIdFromCtx(ctx), GetSessionIdFromCtx(ctx), &req); err != nil {
+		if errors.Is(err, v1.ErrPasswordFailed) || errors.Is(err, v1.ErrVerifyCodeInvalid) ||
+			errors.Is(err, v1.ErrEmailAlreadyUse) || errors.Is(err, v1.ErrPhoneAlreadyUse) {
+			v1.HandleError(ctx, http.StatusBadRequest, err, nil)
+			return
+		}
+		v1.HandleError(ctx, http.StatusInternalServerError, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
+
+// SendVerifyEmail godoc
+// @Summary 重新发送验证邮件
+// @Schemes
+// @Description
+// @Tags 用户模块
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Success 200 {object} v1.Response
+// @Router /email/verify/send [post]
+func (h *UserHandler) SendVerifyEmail(ctx *gin.Context) {
+	if err := h.userService.SendVerifyEmail(ctx, GetUserIdFromCtx(ctx)); err != nil {
+		if errors.Is(err, v1.ErrBadRequest) {
+			v1.HandleError(ctx, http.StatusBadRequest, err, nil)
+			return
+		}
+		v1.HandleError(ctx, http.StatusInternalServerError, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
+
+// VerifyEmail godoc
+// @Summary 验证邮箱
+// @Schemes
+// @Description token来自验证邮件中的链接
+// @Tags 用户模块
+// @Accept json
+// @Produce json
+// @Param request body v1.VerifyEmailRequest true "params"
+// @Success 200 {object} v1.Response
+// @Router /email/verify [post]
+func (h *UserHandler) VerifyEmail(ctx *gin.Context) {
+	var req v1.VerifyEmailRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	if err := h.userService.VerifyEmail(ctx, &req); err != nil {
+		if errors.Is(err, v1.ErrTokenInvalid) {
+			v1.HandleError(ctx, http.StatusBadRequest, err, nil)
+			return
+		}
+		v1.HandleError(ctx, http.StatusInternalServerError, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
+
+// ForgotPassword godoc
+// @Summary 找回密码
+// @Schemes
+// @Description 向注册邮箱发送重置密码链接，邮箱未注册时同样返回成功
+// @Tags 用户模块
+// @Accept json
+// @Produce json
+// @Param request body v1.ForgotPasswordRequest true "params"
+// @Success 200 {object} v1.Response
+// @Router /password/forgot [post]
+func (h *UserHandler) ForgotPassword(ctx *gin.Context) {
+	var req v1.ForgotPasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	if err := h.userService.ForgotPassword(ctx, &req); err != nil {
+		v1.HandleError(ctx, http.StatusInternalServerError, err, nil)
+		return
+	}
+	v1.HandleSuccess(ctx, nil)
+}
+
+// ResetPassword godoc
+// @Summary 重置密码
+// @Schemes
+// @Description token来自找回密码邮件中的链接，重置后所有设备需要重新登录
+// @Tags 用户模块
+// @Accept json
+// @Produce json
+// @Param request body v1.ResetPasswordRequest true "params"
+// @Success 200 {object} v1.Response
+// @Router /password/reset [post]
+func (h *UserHandler) ResetPassword(ctx *gin.Context) {
+	var req v1.ResetPasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		v1.HandleError(ctx, http.StatusBadRequest, v1.ErrBadRequest, nil)
+		return
+	}
+
+	if err := h.userService.ResetPassword(ctx, &req); err != nil {
+		if errors.Is(err, v1.ErrTokenInvalid) {
+			v1.HandleError(ctx, http.StatusBadRequest, err, nil)
+			return
+		}
+		v1.HandleError(ctx, http.StatusInternalServerError, err, nil)
+		return
+	}
 	v1.HandleSuccess(ctx, nil)
 }
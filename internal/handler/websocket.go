@@ -3,17 +3,14 @@ package handler
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/ljinf/im_server_standalone/internal/middleware"
 	"github.com/ljinf/im_server_standalone/internal/service"
+	"github.com/ljinf/im_server_standalone/internal/ws"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
 	"net/http"
-)
-
-var (
-	wsUpgrader = websocket.Upgrader{
-		// 允许所有CORS跨域请求
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
-	}
+	"net/url"
+	"strings"
 )
 
 type WebSocketHandler interface {
@@ -22,22 +19,57 @@ type WebSocketHandler interface {
 
 type webSocketHandler struct {
 	*Handler
-	srv service.WebsocketService
+	srv      service.WebsocketService
+	upgrader websocket.Upgrader
 }
 
-func NewWebSocketHandler(h *Handler, s service.WebsocketService) WebSocketHandler {
+func NewWebSocketHandler(h *Handler, conf *viper.Viper, s service.WebsocketService, origins middleware.AllowedOrigins) WebSocketHandler {
 	return &webSocketHandler{
 		Handler: h,
 		srv:     s,
+		upgrader: websocket.Upgrader{
+			// 按客户端Sec-WebSocket-Protocol协商帧格式
+			Subprotocols: ws.Subprotocols(),
+			// 客户端支持时协商permessage-deflate
+			EnableCompression: conf.GetBool("ws_server.compression.enable"),
+			// 与HTTP跨域共用允许的来源，同源的页面总是允许
+			CheckOrigin: func(r *http.Request) bool {
+				origin := r.Header.Get("Origin")
+				if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+					return true
+				}
+				return origins.Allow(origin)
+			},
+		},
 	}
 }
 
 func (h *webSocketHandler) AcceptConn(ctx *gin.Context) {
-	conn, err := wsUpgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	// 下线中让客户端连接其他节点
+	if h.srv.Draining() {
+		ctx.Header("Retry-After", "1")
+		ctx.AbortWithStatus(http.StatusServiceUnavailable)
+		return
+	}
+	ip := ctx.ClientIP()
+	if err := h.srv.AcquireSlot(ip); err != nil {
+		h.logger.Warn(err.Error(), zap.String("ip", ip))
+		ctx.Header("Retry-After", "5")
+		ctx.AbortWithStatus(http.StatusServiceUnavailable)
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
 	if err != nil {
 		h.logger.Error(err.Error())
+		h.srv.ReleaseSlot(ip)
 		return
 	}
 	userId := GetUserIdFromCtx(ctx)
-	h.srv.InitConn(userId, conn)
+	if userId == 0 {
+		// 握手时未携带token，等待首帧认证
+		h.srv.InitPendingConn(ip, conn)
+		return
+	}
+	h.srv.InitConn(userId, GetSessionIdFromCtx(ctx), ip, conn)
 }
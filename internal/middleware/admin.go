@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	v1 "github.com/ljinf/im_server_standalone/api/v1"
+	"github.com/ljinf/im_server_standalone/pkg/jwt"
+	"github.com/ljinf/im_server_standalone/pkg/log"
+	"github.com/ljinf/im_server_standalone/pkg/rbac"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// 管理员角色在gin.Context中的key
+const AdminRoleKey = "admin_role"
+
+// AdminRoleGetter 查询用户的管理员角色，不是管理员返回空
+type AdminRoleGetter interface {
+	GetAdminRole(ctx context.Context, userId int64) (string, error)
+}
+
+// AdminAuth 只允许管理员从admin.allowed_ips访问，需放在StrictAuth之后。
+// allowed_ips支持IP和CIDR，为空时不限制
+func AdminAuth(admins AdminRoleGetter, conf *viper.Viper, logger *log.Logger) gin.HandlerFunc {
+	var allowed []*net.IPNet
+	for _, v := range conf.GetStringSlice("admin.allowed_ips") {
+		if !strings.Contains(v, "/") {
+			if strings.Contains(v, ":") {
+				v += "/128"
+			} else {
+				v += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(v)
+		if err != nil {
+			logger.Warn("invalid admin.allowed_ips", zap.String("ip", v), zap.Error(err))
+			continue
+		}
+		allowed = append(allowed, ipNet)
+	}
+
+	return func(ctx *gin.Context) {
+		if len(allowed) > 0 && !ipAllowed(allowed, ctx.ClientIP()) {
+			v1.HandleError(ctx, http.StatusForbidden, v1.ErrForbidden, nil)
+			ctx.Abort()
+			return
+		}
+
+		var userId int64
+		if claims, ok := ctx.Get("claims"); ok {
+			if userInfo, ok := claims.(*jwt.MyCustomClaims); ok {
+				userId = userInfo.UserId
+			}
+		}
+		role, err := admins.GetAdminRole(ctx, userId)
+		if err != nil {
+			logger.WithContext(ctx).Error("get admin role error", zap.Int64("userId", userId), zap.Error(err))
+			v1.HandleError(ctx, http.StatusInternalServerError, v1.ErrInternalServerError, nil)
+			ctx.Abort()
+			return
+		}
+		if role == "" {
+			v1.HandleError(ctx, http.StatusForbidden, v1.ErrForbidden, nil)
+			ctx.Abort()
+			return
+		}
+		ctx.Set(AdminRoleKey, role)
+		ctx.Next()
+	}
+}
+
+// RequirePermission 当前管理员的角色需要有perm权限，需放在AdminAuth之后
+func RequirePermission(r *rbac.RBAC, perm string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !r.Can(ctx.GetString(AdminRoleKey), perm) {
+			v1.HandleError(ctx, http.StatusForbidden, v1.ErrForbidden, nil)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func ipAllowed(allowed []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, v := range allowed {
+		if v.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
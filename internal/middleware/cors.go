@@ -1,15 +1,81 @@
 package middleware
 
 import (
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
-func CORSMiddleware() gin.HandlerFunc {
+// AllowedOrigins 允许的跨域来源，HTTP跨域和ws升级共用，跨域请求允许携带cookie。
+// 支持完整来源 https://app.example.com、子域名通配 https://*.example.com(省略协议时为https) 和 *，
+// 为空时不允许任何跨域来源
+type AllowedOrigins []string
+
+// local、dev以外的环境必须配置来源且不能使用*，否则任意网站都能带着用户的cookie跨域调用接口
+func NewAllowedOrigins(conf *viper.Viper) (AllowedOrigins, error) {
+	origins := AllowedOrigins(conf.GetStringSlice("cors.allowed_origins"))
+	if env := conf.GetString("env"); env != "local" && env != "dev" {
+		if len(origins) == 0 {
+			return nil, fmt.Errorf("cors: cors.allowed_origins is empty in env %q", env)
+		}
+		for _, v := range origins {
+			if v == "*" {
+				return nil, fmt.Errorf("cors: cors.allowed_origins \"*\" is not allowed in env %q", env)
+			}
+		}
+	}
+	return origins, nil
+}
+
+// 没有Origin的请求不是浏览器跨域请求，直接放行
+func (a AllowedOrigins) Allow(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, v := range a {
+		switch {
+		case v == "*":
+			return true
+		case strings.Contains(v, "*."):
+			scheme, host, ok := strings.Cut(v, "://")
+			if !ok {
+				scheme, host = "https", v
+			}
+			if strings.EqualFold(u.Scheme, scheme) && strings.HasPrefix(host, "*.") &&
+				strings.HasSuffix(strings.ToLower(u.Hostname()), strings.ToLower(host[1:])) {
+				return true
+			}
+		case strings.EqualFold(strings.TrimSuffix(v, "/"), origin):
+			return true
+		}
+	}
+	return false
+}
+
+func CORSMiddleware(origins AllowedOrigins) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		method := c.Request.Method
-		c.Header("Access-Control-Allow-Origin", c.GetHeader("Origin"))
+		origin := c.GetHeader("Origin")
+		if !origins.Allow(origin) {
+			// 不返回跨域头，由浏览器拦截
+			if method == "OPTIONS" {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		c.Header("Access-Control-Allow-Origin", origin)
 		c.Header("Access-Control-Allow-Credentials", "true")
+		c.Header("Vary", "Origin")
 
 		if method == "OPTIONS" {
 			c.Header("Access-Control-Allow-Methods", c.GetHeader("Access-Control-Request-Method"))
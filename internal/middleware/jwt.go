@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
 	"github.com/ljinf/im_server_standalone/api/v1"
 	"github.com/ljinf/im_server_standalone/pkg/jwt"
@@ -9,7 +10,13 @@ import (
 	"net/http"
 )
 
-func StrictAuth(j *jwt.JWT, logger *log.Logger) gin.HandlerFunc {
+// SessionChecker 校验token对应的登录会话是否已被吊销，以及用户是否已被封禁
+type SessionChecker interface {
+	SessionActive(ctx context.Context, userId int64, sessionId string) (bool, error)
+	UserBanned(ctx context.Context, userId int64) (bool, error)
+}
+
+func StrictAuth(j *jwt.JWT, sessions SessionChecker, logger *log.Logger) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		tokenString := ctx.Request.Header.Get("Authorization")
 		if tokenString == "" {
@@ -33,13 +40,30 @@ func StrictAuth(j *jwt.JWT, logger *log.Logger) gin.HandlerFunc {
 			return
 		}
 
+		active, err := sessions.SessionActive(ctx, claims.UserId, claims.ID)
+		if err != nil {
+			logger.WithContext(ctx).Error("session check error", zap.Int64("userId", claims.UserId), zap.Error(err))
+			v1.HandleError(ctx, http.StatusInternalServerError, v1.ErrInternalServerError, nil)
+			ctx.Abort()
+			return
+		}
+		if !active {
+			v1.HandleError(ctx, http.StatusUnauthorized, v1.ErrUnauthorized, nil)
+			ctx.Abort()
+			return
+		}
+		if rejectBanned(ctx, sessions, logger, claims.UserId) {
+			return
+		}
+
 		ctx.Set("claims", claims)
 		recoveryLoggerFunc(ctx, logger)
 		ctx.Next()
 	}
 }
 
-func NoStrictAuth(j *jwt.JWT, logger *log.Logger) gin.HandlerFunc {
+// 会话已吊销时按未登录处理
+func NoStrictAuth(j *jwt.JWT, sessions SessionChecker, logger *log.Logger) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		tokenString := ctx.Request.Header.Get("Authorization")
 		if tokenString == "" {
@@ -58,6 +82,14 @@ func NoStrictAuth(j *jwt.JWT, logger *log.Logger) gin.HandlerFunc {
 			ctx.Next()
 			return
 		}
+		if active, err := sessions.SessionActive(ctx, claims.UserId, claims.ID); err != nil || !active {
+			ctx.Next()
+			return
+		}
+		if banned, err := sessions.UserBanned(ctx, claims.UserId); err != nil || banned {
+			ctx.Next()
+			return
+		}
 
 		ctx.Set("claims", claims)
 		recoveryLoggerFunc(ctx, logger)
@@ -65,6 +97,23 @@ func NoStrictAuth(j *jwt.JWT, logger *log.Logger) gin.HandlerFunc {
 	}
 }
 
+// 已封禁时返回403并中止，返回true
+func rejectBanned(ctx *gin.Context, sessions SessionChecker, logger *log.Logger, userId int64) bool {
+	banned, err := sessions.UserBanned(ctx, userId)
+	if err != nil {
+		logger.WithContext(ctx).Error("ban check error", zap.Int64("userId", userId), zap.Error(err))
+		v1.HandleError(ctx, http.StatusInternalServerError, v1.ErrInternalServerError, nil)
+		ctx.Abort()
+		return true
+	}
+	if banned {
+		v1.HandleError(ctx, http.StatusForbidden, v1.ErrUserBanned, nil)
+		ctx.Abort()
+		return true
+	}
+	return false
+}
+
 func recoveryLoggerFunc(ctx *gin.Context, logger *log.Logger) {
 	if userInfo, ok := ctx.MustGet("claims").(*jwt.MyCustomClaims); ok {
 		logger.WithValue(ctx, zap.Int64("UserId", userInfo.UserId))
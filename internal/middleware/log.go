@@ -2,15 +2,49 @@ package middleware
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/duke-git/lancet/v2/cryptor"
 	"github.com/duke-git/lancet/v2/random"
 	"github.com/gin-gonic/gin"
 	"github.com/ljinf/im_server_standalone/pkg/log"
 	"go.uber.org/zap"
 	"io"
+	"net/url"
 	"time"
 )
 
+// AccessLog 替换gin默认的Logger，日志中的accessToken参数替换为redacted，
+// ws升级请求和NoStrictAuth都接受query中的token，原样记录会把token写进日志
+func AccessLog() gin.HandlerFunc {
+	return gin.LoggerWithConfig(gin.LoggerConfig{
+		Formatter: func(param gin.LogFormatterParams) string {
+			return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+				param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+				param.StatusCode,
+				param.Latency,
+				param.ClientIP,
+				param.Method,
+				redactQuery(param.Path),
+				param.ErrorMessage,
+			)
+		},
+	})
+}
+
+func redactQuery(path string) string {
+	u, err := url.Parse(path)
+	if err != nil || u.RawQuery == "" {
+		return path
+	}
+	query := u.Query()
+	if query.Get("accessToken") == "" {
+		return path
+	}
+	query.Set("accessToken", "redacted")
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
 func RequestLogMiddleware(logger *log.Logger) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		// The configuration is initialized once per request
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	v1 "github.com/ljinf/im_server_standalone/api/v1"
+	"github.com/ljinf/im_server_standalone/internal/cache"
+	"github.com/ljinf/im_server_standalone/pkg/jwt"
+	"github.com/ljinf/im_server_standalone/pkg/log"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// RateLimit 按登录用户限流，未登录按IP，window内最多limit次。redis异常时放行
+func RateLimit(rdb *redis.Client, logger *log.Logger, name string, limit int64, window time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := "ip:" + ctx.ClientIP()
+		if claims, ok := ctx.Get("claims"); ok {
+			if userInfo, ok := claims.(*jwt.MyCustomClaims); ok {
+				key = fmt.Sprintf("user:%v", userInfo.UserId)
+			}
+		}
+
+		count, err := cache.IncrRateLimitCache(rdb, name, key, window)
+		if err != nil {
+			logger.WithContext(ctx).Error("rate limit error", zap.String("name", name), zap.Error(err))
+			ctx.Next()
+			return
+		}
+		if count > limit {
+			logger.WithContext(ctx).Warn("rate limited", zap.String("name", name), zap.String("key", key))
+			v1.HandleError(ctx, http.StatusTooManyRequests, v1.ErrTooManyRequests, nil)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// GlobalRateLimit 所有请求共用一个计数，用于限制重连风暴等整体流量。redis异常时放行
+func GlobalRateLimit(rdb *redis.Client, logger *log.Logger, name string, limit int64, window time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		count, err := cache.IncrRateLimitCache(rdb, name, "global", window)
+		if err != nil {
+			logger.WithContext(ctx).Error("rate limit error", zap.String("name", name), zap.Error(err))
+			ctx.Next()
+			return
+		}
+		if count > limit {
+			logger.WithContext(ctx).Warn("global rate limited", zap.String("name", name))
+			v1.HandleError(ctx, http.StatusTooManyRequests, v1.ErrTooManyRequests, nil)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
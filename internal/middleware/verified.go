@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	v1 "github.com/ljinf/im_server_standalone/api/v1"
+	"github.com/ljinf/im_server_standalone/pkg/jwt"
+	"github.com/ljinf/im_server_standalone/pkg/log"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// VerifiedChecker 查询用户是否已验证邮箱或手机号
+type VerifiedChecker interface {
+	IsVerified(ctx context.Context, userId int64) (bool, error)
+}
+
+// RequireVerified 未验证的账号不能使用，需放在StrictAuth之后
+func RequireVerified(users VerifiedChecker, logger *log.Logger) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var userId int64
+		if claims, ok := ctx.Get("claims"); ok {
+			if userInfo, ok := claims.(*jwt.MyCustomClaims); ok {
+				userId = userInfo.UserId
+			}
+		}
+
+		verified, err := users.IsVerified(ctx, userId)
+		if err != nil {
+			logger.WithContext(ctx).Error("check verified error", zap.Int64("userId", userId), zap.Error(err))
+			v1.HandleError(ctx, http.StatusInternalServerError, v1.ErrInternalServerError, nil)
+			ctx.Abort()
+			return
+		}
+		if !verified {
+			v1.HandleError(ctx, http.StatusForbidden, v1.ErrNotVerified, nil)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
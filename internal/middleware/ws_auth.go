@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ljinf/im_server_standalone/api/v1"
+	"github.com/ljinf/im_server_standalone/pkg/jwt"
+	"github.com/ljinf/im_server_standalone/pkg/log"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+)
+
+// 浏览器不能在升级请求上设置header，token放在子协议中时的前缀，如 Sec-WebSocket-Protocol: im.v1.json, auth.<token>
+const WsTokenSubprotocolPrefix = "auth."
+
+// WsAuth 升级请求依次从Authorization、accessToken参数、子协议中取token。
+// accessToken参数只为兼容旧客户端保留，URL会出现在代理和浏览器的记录中，浏览器应使用子协议或首帧认证。
+// allowDeferred为true时没有token也放行，由连接的首帧认证
+func WsAuth(j *jwt.JWT, sessions SessionChecker, logger *log.Logger, allowDeferred bool) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		tokenString := wsToken(ctx)
+		if tokenString == "" {
+			if allowDeferred {
+				ctx.Next()
+				return
+			}
+			v1.HandleError(ctx, http.StatusUnauthorized, v1.ErrUnauthorized, nil)
+			ctx.Abort()
+			return
+		}
+
+		claims, err := j.ParseToken(tokenString)
+		if err != nil {
+			logger.WithContext(ctx).Warn("ws token error", zap.Error(err))
+			v1.HandleError(ctx, http.StatusUnauthorized, v1.ErrUnauthorized, nil)
+			ctx.Abort()
+			return
+		}
+
+		active, err := sessions.SessionActive(ctx, claims.UserId, claims.ID)
+		if err != nil {
+			logger.WithContext(ctx).Error("session check error", zap.Int64("userId", claims.UserId), zap.Error(err))
+			v1.HandleError(ctx, http.StatusInternalServerError, v1.ErrInternalServerError, nil)
+			ctx.Abort()
+			return
+		}
+		if !active {
+			v1.HandleError(ctx, http.StatusUnauthorized, v1.ErrUnauthorized, nil)
+			ctx.Abort()
+			return
+		}
+		if rejectBanned(ctx, sessions, logger, claims.UserId) {
+			return
+		}
+
+		ctx.Set("claims", claims)
+		recoveryLoggerFunc(ctx, logger)
+		ctx.Next()
+	}
+}
+
+func wsToken(ctx *gin.Context) string {
+	if token := ctx.Request.Header.Get("Authorization"); token != "" {
+		return token
+	}
+	if token := ctx.Query("accessToken"); token != "" {
+		return token
+	}
+	for _, v := range strings.Split(ctx.Request.Header.Get("Sec-WebSocket-Protocol"), ",") {
+		if v = strings.TrimSpace(v); strings.HasPrefix(v, WsTokenSubprotocolPrefix) {
+			return strings.TrimPrefix(v, WsTokenSubprotocolPrefix)
+		}
+	}
+	return ""
+}
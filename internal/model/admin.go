@@ -0,0 +1,43 @@
+package model
+
+// 管理员，角色对应的权限见pkg/rbac
+type AdminUser struct {
+	Id        int64  `json:"id"`
+	UserId    int64  `json:"user_id"`
+	Role      string `json:"role"`
+	CreatedBy int64  `json:"created_by"` //分配角色的管理员
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+func (a *AdminUser) TableName() string {
+	return "admin_user"
+}
+
+// 管理员操作记录
+type AdminOpLog struct {
+	Id        int64  `json:"id"`
+	AdminId   int64  `json:"admin_id"`
+	Action    string `json:"action"`    //对应的权限，如user:ban
+	TargetId  int64  `json:"target_id"` //用户ID或会话ID
+	Detail    string `json:"detail"`    //操作参数，JSON
+	Ip        string `json:"ip"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+func (a *AdminOpLog) TableName() string {
+	return "admin_op_log"
+}
+
+// 系统公告
+type Announcement struct {
+	Id        int64  `json:"id"`
+	AdminId   int64  `json:"admin_id"`
+	Title     string `json:"title"`
+	Content   string `json:"content"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+func (a *Announcement) TableName() string {
+	return "announcement"
+}
@@ -7,6 +7,12 @@ type WsMessage struct {
 	Payload []byte `json:"payload"`
 }
 
+// 用户长连接所在的节点
+type WsRoute struct {
+	NodeId    string
+	SessionId string
+}
+
 type ChatMessage struct {
 	ConversationId int64     `json:"conversation_id"` //会话ID
 	UserId         int64     `json:"user_id"`         //发送者ID
@@ -43,6 +49,7 @@ type ConversationList struct {
 	Avatar         string `json:"avatar"`          //群组头像
 	Announcement   string `json:"announcement"`    //群公告
 	RecentMsgTime  int64  `json:"recent_msg_time"` //此会话最新产生消息的时间
+	Status         int    `json:"status"`          //会话状态 0正常 1已解散
 	CreatedAt      int64  `json:"created_at"`
 }
 
@@ -50,6 +57,19 @@ func (c *ConversationList) TableName() string {
 	return "conversation_list"
 }
 
+// 单聊会话索引  (min_user_id,max_user_id)唯一，保证两个用户之间只有一个单聊会话
+type C2CConversation struct {
+	Id             int64 `json:"id"`
+	MinUserId      int64 `json:"min_user_id"`     //较小的用户ID
+	MaxUserId      int64 `json:"max_user_id"`     //较大的用户ID
+	ConversationId int64 `json:"conversation_id"` //会话ID
+	CreatedAt      int64 `json:"created_at"`
+}
+
+func (c *C2CConversation) TableName() string {
+	return "c2c_conversation"
+}
+
 // 会话消息链  读扩散
 type ConversationMsgList struct {
 	Id             int64 `json:"id"`
@@ -85,6 +105,7 @@ type UserConversationList struct {
 	LastReadSeq    int64 `json:"last_read_seq"`   //此会话用户已读的最后一条消息
 	NotifyType     int   `json:"notify_type"`     //会话收到消息的提醒类型，0未屏蔽，正常提醒 1屏蔽 2强提醒
 	IsTop          int   `json:"is_top"`          //会话是否被置顶展示 0否 1是
+	RequestStatus  int   `json:"request_status"`  //消息请求状态 0正常会话 1陌生人消息待处理 2已忽略
 	CreatedAt      int64 `json:"created_at"`
 	UpdatedAt      int64 `json:"updated_at"`
 }
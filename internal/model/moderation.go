@@ -0,0 +1,20 @@
+package model
+
+// 消息审核记录
+type ModerationAudit struct {
+	Id             int64  `json:"id"`
+	MsgId          int64  `json:"msg_id"` //拒绝发送的为0
+	UserId         int64  `json:"user_id"`
+	TargetId       int64  `json:"target_id"`
+	ConversationId int64  `json:"conversation_id"`
+	Moderator      string `json:"moderator"` //做出决定的审核器
+	Rule           string `json:"rule"`      //命中的规则
+	Action         string `json:"action"`    //allow mask block reject
+	Reason         string `json:"reason"`
+	Content        string `json:"content"` //原始内容
+	CreatedAt      int64  `json:"created_at"`
+}
+
+func (m *ModerationAudit) TableName() string {
+	return "moderation_audit"
+}
@@ -11,9 +11,10 @@ type RelationshipList struct {
 	UserId           int64          `json:"user_id"`           //用户id 拥有者
 	TargetId         int64          `json:"target_id"`         //用户id 对方
 	Remark           string         `json:"remark"`            //对方的别名备注
-	RelationshipType int            `json:"relationship_type"` //关系类型  1好友 2关注
+	RelationshipType int            `json:"relationship_type"` //关系类型  1好友 2关注 3黑名单
 	Status           int            `json:"status"`            //状态 1正常 2拉黑 3删除
 	Extra            string         `json:"extra"`             //其他信息
+	Version          int64          `json:"version"`           //通讯录版本号，增量同步用
 	CreatedAt        time.Time      `json:"-"`
 	UpdatedAt        time.Time      `json:"-"`
 	DeletedAt        gorm.DeletedAt `json:"-"`
@@ -23,6 +24,42 @@ func (t *RelationshipList) TableName() string {
 	return "relationship_list"
 }
 
+// 用户通讯录版本号，关系每变更一条递增一次
+type ContactVersion struct {
+	UserId  int64 `json:"user_id" gorm:"primaryKey;autoIncrement:false"`
+	Version int64 `json:"version"`
+}
+
+func (t *ContactVersion) TableName() string {
+	return "contact_version"
+}
+
+// 好友分组标签
+type RelationshipTag struct {
+	Id        int64     `json:"id"`
+	UserId    int64     `json:"user_id"` //用户id 拥有者
+	Name      string    `json:"name"`    //标签名
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+}
+
+func (t *RelationshipTag) TableName() string {
+	return "relationship_tag"
+}
+
+// 标签下的好友
+type RelationshipTagMember struct {
+	Id        int64     `json:"id"`
+	UserId    int64     `json:"user_id"`   //用户id 拥有者
+	TagId     int64     `json:"tag_id"`    //标签id
+	TargetId  int64     `json:"target_id"` //好友id
+	CreatedAt time.Time `json:"-"`
+}
+
+func (t *RelationshipTagMember) TableName() string {
+	return "relationship_tag_member"
+}
+
 // 好友申请记录表
 type ApplyFriendshipList struct {
 	Id          int64          `json:"id"`
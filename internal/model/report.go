@@ -0,0 +1,23 @@
+package model
+
+// 举报
+type Report struct {
+	Id             int64  `json:"id"`
+	ReporterId     int64  `json:"reporter_id"`      //举报人ID
+	TargetType     int    `json:"target_type"`      //举报对象 1消息 2用户 3群组
+	TargetId       int64  `json:"target_id"`        //对象ID，消息ID、用户ID或群组会话ID
+	ReportedUserId int64  `json:"reported_user_id"` //被举报的用户，举报消息时为发送者
+	Reason         int    `json:"reason"`           //举报原因
+	Description    string `json:"description"`      //补充说明
+	Content        string `json:"content"`          //举报消息时的消息内容快照
+	Status         int    `json:"status"`           //状态 0待处理 1已处理 2已驳回
+	Action         int    `json:"action"`           //处理动作 0不处理 1屏蔽消息 2禁言 3封禁
+	HandlerId      int64  `json:"handler_id"`       //处理人ID
+	Note           string `json:"note"`             //处理备注
+	CreatedAt      int64  `json:"created_at"`
+	HandledAt      int64  `json:"handled_at"`
+}
+
+func (r *Report) TableName() string {
+	return "report"
+}
@@ -0,0 +1,16 @@
+package model
+
+// 登录会话，存redis，access token的jti即SessionId
+type Session struct {
+	SessionId   string `json:"session_id"`
+	UserId      int64  `json:"user_id"`
+	RefreshHash string `json:"refresh_hash"` //当前refresh token的sha256
+	PrevHash    string `json:"prev_hash"`    //上一个已轮换掉的refresh token，再次使用视为泄露
+	Device      string `json:"device"`       //设备名
+	Platform    string `json:"platform"`     //平台 ios android web等
+	Ip          string `json:"ip"`           //登录ip
+	UserAgent   string `json:"user_agent"`   //登录时的UA
+	CreatedAt   int64  `json:"created_at"`
+	RefreshedAt int64  `json:"refreshed_at"`
+	LastSeen    int64  `json:"last_seen"` //最后活跃时间，记录在用户会话列表的分数上
+}
@@ -7,14 +7,16 @@ import (
 
 // 注册表
 type Register struct {
-	Id        int64          `json:"id" gorm:"primarykey"`
-	UserId    int64          `json:"user_id"`
-	Phone     string         `json:"phone"`
-	Email     string         `json:"email"`
-	Password  string         `json:"password"`
-	CreatedAt time.Time      `json:"-"`
-	UpdatedAt time.Time      `json:"-"`
-	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	Id            int64          `json:"id" gorm:"primarykey"`
+	UserId        int64          `json:"user_id"`
+	Phone         string         `json:"phone"`
+	Email         string         `json:"email"`
+	Password      string         `json:"password"`
+	EmailVerified bool           `json:"email_verified"` //邮箱已验证
+	PhoneVerified bool           `json:"phone_verified"` //手机号已验证
+	CreatedAt     time.Time      `json:"-"`
+	UpdatedAt     time.Time      `json:"-"`
+	DeletedAt     gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
 func (r *Register) TableName() string {
@@ -23,15 +25,25 @@ func (r *Register) TableName() string {
 
 // 用户信息表
 type UserInfo struct {
-	Id        int64          `json:"id" gorm:"primarykey"`
-	UserId    int64          `json:"user_id"`
-	NickName  string         `json:"nick_name"` //昵称
-	Avatar    string         `json:"avatar"`    //头像
-	Gender    int            `json:"gender"`    //性别
-	Status    int            `json:"status"`    //用户状态  0:异常  1:正常
-	CreatedAt time.Time      `json:"-"`
-	UpdatedAt time.Time      `json:"-"`
-	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	Id                  int64          `json:"id" gorm:"primarykey"`
+	UserId              int64          `json:"user_id"`
+	NickName            string         `json:"nick_name"`                              //昵称
+	Avatar              string         `json:"avatar"`                                 //头像
+	Gender              int            `json:"gender"`                                 //性别 1男 2女 3未知
+	Signature           string         `json:"signature" gorm:"column:self_signature"` //个性签名
+	Birthday            string         `json:"birthday" gorm:"column:birth_day"`       //生日 yyyy-mm-dd
+	Region              string         `json:"region"`                                 //地区
+	SignatureVisibility int            `json:"signature_visibility" gorm:"default:1"`  //可见范围 1所有人 2仅好友 3仅自己
+	BirthdayVisibility  int            `json:"birthday_visibility" gorm:"default:1"`
+	RegionVisibility    int            `json:"region_visibility" gorm:"default:1"`
+	Status              int            `json:"status"`                        //用户状态  0:异常  1:正常
+	SilentFlag          int            `json:"silent_flag"`                   //禁言标识 1禁言
+	SilentUntil         int64          `json:"silent_until"`                  //禁言截止时间，0为永久
+	MsgAllowType        int            `json:"msg_allow_type"`                //谁可以给我发消息 0服务端配置 1所有人 2仅好友 3好友和关注我的人
+	Discoverable        int            `json:"discoverable" gorm:"default:7"` //允许被搜索的方式 1邮箱 2手机号 4昵称，按位组合
+	CreatedAt           time.Time      `json:"-"`
+	UpdatedAt           time.Time      `json:"-"`
+	DeletedAt           gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
 func (u *UserInfo) TableName() string {
@@ -39,13 +51,45 @@ func (u *UserInfo) TableName() string {
 }
 
 type AccountInfo struct {
-	UserId   int64  `json:"user_id"`
-	Phone    string `json:"phone"`
-	Email    string `json:"email"`
-	NickName string `json:"nick_name"` //昵称
-	Avatar   string `json:"avatar"`    //头像
-	Gender   int    `json:"gender"`    //性别
-	Password string `json:"password"`
-	Salt     string `json:"salt"`
-	Status   int    `json:"status"` //用户状态  0:异常  1:正常
+	UserId    int64  `json:"user_id"`
+	Phone     string `json:"phone"`
+	Email     string `json:"email"`
+	NickName  string `json:"nick_name"` //昵称
+	Avatar    string `json:"avatar"`    //头像
+	Gender    int    `json:"gender"`    //性别
+	Signature string `json:"signature"` //个性签名
+	Birthday  string `json:"birthday"`  //生日
+	Region    string `json:"region"`    //地区
+	Password  string `json:"password"`
+	Salt      string `json:"salt"`
+	Status    int    `json:"status"` //用户状态  0:异常  1:正常
+
+	SilentFlag  int   `json:"silent_flag"`  //禁言标识 1禁言
+	SilentUntil int64 `json:"silent_until"` //禁言截止时间，0为永久
+
+	EmailVerified bool `json:"email_verified"` //邮箱已验证
+	PhoneVerified bool `json:"phone_verified"` //手机号已验证
+
+	MsgAllowType int `json:"msg_allow_type"` //谁可以给我发消息 0服务端配置 1所有人 2仅好友 3好友和关注我的人
+
+	RegisteredAt time.Time `json:"registered_at"` //注册时间
+
+	SignatureVisibility int `json:"signature_visibility"` //可见范围 1所有人 2仅好友 3仅自己
+	BirthdayVisibility  int `json:"birthday_visibility"`
+	RegionVisibility    int `json:"region_visibility"`
+}
+
+// 用户自定义资料字段，可用的字段由profile.custom_fields配置
+type UserProfileField struct {
+	Id         int64     `json:"id"`
+	UserId     int64     `json:"user_id"`
+	FieldKey   string    `json:"field_key"`  //字段名
+	Value      string    `json:"value"`      //字段值
+	Visibility int       `json:"visibility"` //可见范围 1所有人 2仅好友 3仅自己
+	CreatedAt  time.Time `json:"-"`
+	UpdatedAt  time.Time `json:"-"`
+}
+
+func (f *UserProfileField) TableName() string {
+	return "user_profile_field"
 }
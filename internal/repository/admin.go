@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/ljinf/im_server_standalone/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AdminRepository interface {
+	// 不是管理员时返回nil
+	GetAdminUser(ctx context.Context, userId int64) (*model.AdminUser, error)
+	SelectAdminUsers(ctx context.Context) ([]model.AdminUser, error)
+	SaveAdminUser(ctx context.Context, admin *model.AdminUser) error //已存在时更新角色
+	DelAdminUser(ctx context.Context, userId int64) error
+
+	CreateOpLog(ctx context.Context, log *model.AdminOpLog) error
+
+	CreateAnnouncement(ctx context.Context, announcement *model.Announcement) error
+	SelectAnnouncementList(ctx context.Context, pageNum, pageSize int) ([]model.Announcement, error)
+}
+
+type adminRepository struct {
+	*Repository
+}
+
+func NewAdminRepository(r *Repository) AdminRepository {
+	return &adminRepository{
+		Repository: r,
+	}
+}
+
+func (r *adminRepository) GetAdminUser(ctx context.Context, userId int64) (*model.AdminUser, error) {
+	var admin model.AdminUser
+	if err := r.DB(ctx).Where("user_id=?", userId).First(&admin).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &admin, nil
+}
+
+func (r *adminRepository) SelectAdminUsers(ctx context.Context) ([]model.AdminUser, error) {
+	var list []model.AdminUser
+	err := r.DB(ctx).Order("user_id asc").Find(&list).Error
+	return list, err
+}
+
+func (r *adminRepository) SaveAdminUser(ctx context.Context, admin *model.AdminUser) error {
+	return r.DB(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"role", "created_by", "updated_at"}),
+	}).Create(admin).Error
+}
+
+func (r *adminRepository) DelAdminUser(ctx context.Context, userId int64) error {
+	return r.DB(ctx).Where("user_id=?", userId).Delete(&model.AdminUser{}).Error
+}
+
+func (r *adminRepository) CreateOpLog(ctx context.Context, log *model.AdminOpLog) error {
+	return r.DB(ctx).Create(log).Error
+}
+
+func (r *adminRepository) CreateAnnouncement(ctx context.Context, announcement *model.Announcement) error {
+	return r.DB(ctx).Create(announcement).Error
+}
+
+func (r *adminRepository) SelectAnnouncementList(ctx context.Context, pageNum, pageSize int) ([]model.Announcement, error) {
+	var list []model.Announcement
+	err := r.DB(ctx).Order("id desc").Limit(pageSize).Offset((pageNum - 1) * pageSize).Find(&list).Error
+	return list, err
+}
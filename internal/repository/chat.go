@@ -4,10 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	v1 "github.com/ljinf/im_server_standalone/api/v1"
 	"github.com/ljinf/im_server_standalone/internal/cache"
 	"github.com/ljinf/im_server_standalone/internal/model"
+	"github.com/ljinf/im_server_standalone/pkg/contants"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
+	"time"
 )
 
 type ChatRepository interface {
@@ -15,11 +19,16 @@ type ChatRepository interface {
 	CreateConversation(ctx context.Context, req *model.ConversationList) error
 	SelectConversation(ctx context.Context, conversationId ...int64) ([]model.ConversationList, error)
 	UpdateConversation(ctx context.Context, req *model.ConversationList) error
+	// 单聊会话索引
+	CreateC2CConversation(ctx context.Context, req *model.C2CConversation) (bool, error)
+	SelectC2CConversationId(ctx context.Context, userId, targetId int64) (int64, error) //两个用户之间的单聊会话
 
 	// 消息
 	CreateMsg(ctx context.Context, req *model.MsgList, seq int64) error
 	SelectMsgList(ctx context.Context, msgId ...interface{}) ([]model.MsgResp, error)
 	UpdateMsg(ctx context.Context, req *model.MsgList) error
+	GetMsg(ctx context.Context, msgId int64) (*model.MsgList, error)
+	UpdateMsgStatus(ctx context.Context, msgId int64, status int) error //如屏蔽、撤回，同时清除消息缓存
 
 	// 会话消息
 	CreateConversationMsg(ctx context.Context, req *model.ConversationMsgList) error
@@ -33,9 +42,23 @@ type ChatRepository interface {
 	// 用户会话链
 	CreateUserConversationList(ctx context.Context, req ...*model.UserConversationList) error
 	UpdateUserConversationList(ctx context.Context, req *model.UserConversationList) error
+	UpdateUserConversationRequestStatus(ctx context.Context, userId, conversationId int64, status int) error
+	SelectUserConversation(ctx context.Context, userId, conversationId int64) (*model.UserConversationList, error)
 	// 用户会话列表
 	SelectUserConversationList(ctx context.Context, userId, pageNum, pageSize int64) ([]model.UserConversationList, error)
-	SelectConversationUsers(ctx context.Context, conversationId int64) ([]model.UserInfo, error) //会话下的用户列表
+	SelectUserConversationRequestList(ctx context.Context, userId, pageNum, pageSize int64) ([]model.UserConversationList, error) //陌生人消息请求
+	SelectConversationUsers(ctx context.Context, conversationId int64) ([]model.UserInfo, error)                                  //会话下的用户列表
+
+	// 导出和注销
+	SelectUserConversationIds(ctx context.Context, userId int64) ([]int64, error)
+	SelectConversationMsgAfter(ctx context.Context, conversationId, seq int64, limit int) ([]model.MsgResp, error) //seq之后的消息，按seq升序
+	DelUserConversations(ctx context.Context, userId int64) error                                                  //退出所有会话，删除用户消息链
+
+	// 管理后台
+	SelectGroupList(ctx context.Context, pageNum, pageSize int) ([]model.ConversationList, int64, error)
+	DissolveConversation(ctx context.Context, conversationId int64) ([]int64, error) //标记为已解散并移除所有成员，返回原成员
+	IncrMsgMinuteCount(ctx context.Context, t time.Time) error
+	SelectMsgMinuteCounts(ctx context.Context, from time.Time, minutes int) ([]int64, error) //from起每分钟的消息数
 }
 
 type chatRepository struct {
@@ -90,6 +113,54 @@ func (r *chatRepository) UpdateConversation(ctx context.Context, req *model.Conv
 	return r.DB(ctx).Where("conversation_id=?", req.ConversationId).Updates(req).Error
 }
 
+// 创建单聊会话索引，返回是否新建；索引已存在时req.ConversationId为已有的会话ID
+func (r *chatRepository) CreateC2CConversation(ctx context.Context, req *model.C2CConversation) (bool, error) {
+	result := r.DB(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(req)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	created := result.RowsAffected > 0
+	if !created {
+		// 并发创建，加锁读取已提交的索引
+		var pair model.C2CConversation
+		if err := r.DB(ctx).Clauses(clause.Locking{Strength: "SHARE"}).
+			Where("min_user_id=? and max_user_id=?", req.MinUserId, req.MaxUserId).First(&pair).Error; err != nil {
+			return false, err
+		}
+		req.ConversationId = pair.ConversationId
+	}
+	// 创建时处于事务中，缓存在提交后由SelectC2CConversationId读取时写入
+	return created, nil
+}
+
+// 两个用户之间的单聊会话，不存在返回0
+func (r *chatRepository) SelectC2CConversationId(ctx context.Context, userId, targetId int64) (int64, error) {
+	minId, maxId := userId, targetId
+	if minId > maxId {
+		minId, maxId = maxId, minId
+	}
+
+	if convId, err := cache.GetC2CConversationCache(r.rdb, minId, maxId); err == nil && convId > 0 {
+		return convId, nil
+	}
+
+	var pair model.C2CConversation
+	if err := r.DB(ctx).Where("min_user_id=? and max_user_id=?", minId, maxId).First(&pair).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	if !r.inTx(ctx) {
+		if err := cache.SetC2CConversationCache(r.rdb, &pair); err != nil {
+			r.logger.Error(err.Error(), zap.Any("SetC2CConversationCache", pair))
+		}
+	}
+	return pair.ConversationId, nil
+}
+
 func (r *chatRepository) CreateMsg(ctx context.Context, req *model.MsgList, seq int64) error {
 	if err := r.DB(ctx).Create(req).Error; err != nil {
 		return err
@@ -163,6 +234,27 @@ func (r *chatRepository) UpdateMsg(ctx context.Context, req *model.MsgList) erro
 	return r.DB(ctx).Where("msg_id=?", req.MsgId).Updates(req).Error
 }
 
+func (r *chatRepository) GetMsg(ctx context.Context, msgId int64) (*model.MsgList, error) {
+	var msg model.MsgList
+	if err := r.DB(ctx).Where("msg_id=?", msgId).First(&msg).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, v1.ErrNotFound
+		}
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (r *chatRepository) UpdateMsgStatus(ctx context.Context, msgId int64, status int) error {
+	if err := r.DB(ctx).Model(&model.MsgList{}).Where("msg_id=?", msgId).Update("status", status).Error; err != nil {
+		return err
+	}
+	if err := cache.DelMsgCache(r.rdb, msgId); err != nil {
+		r.logger.Error(err.Error(), zap.Any("DelMsgCache", msgId))
+	}
+	return nil
+}
+
 // 创建会话消息，并生成一个消息序列号
 func (r *chatRepository) CreateConversationMsg(ctx context.Context, req *model.ConversationMsgList) error {
 	msgSeq := cache.IncrConversationMsg(r.rdb, req.ConversationId)
@@ -201,7 +293,7 @@ func (r *chatRepository) CreateUserMsgList(ctx context.Context, req *model.UserM
 // 创建会话信息
 func (r *chatRepository) CreateUserConversationList(ctx context.Context, req ...*model.UserConversationList) error {
 	if err := r.DB(ctx).Clauses(clause.OnConflict{
-		DoUpdates: clause.AssignmentColumns([]string{"last_read_seq", "updated_at"}),
+		DoUpdates: clause.AssignmentColumns([]string{"last_read_seq", "request_status", "updated_at"}),
 	}).Create(req).Error; err != nil {
 		return err
 	}
@@ -242,7 +334,42 @@ func (r *chatRepository) UpdateUserConversationList(ctx context.Context, req *mo
 	return nil
 }
 
-// 获取用户会话链信息
+// 修改会话的消息请求状态
+func (r *chatRepository) UpdateUserConversationRequestStatus(ctx context.Context, userId, conversationId int64, status int) error {
+	if err := r.DB(ctx).Model(&model.UserConversationList{}).Where("user_id=? and conversation_id=?", userId, conversationId).
+		Updates(map[string]interface{}{"request_status": status, "updated_at": time.Now().Unix()}).Error; err != nil {
+		return err
+	}
+
+	r.afterCommit(ctx, func() {
+		if err := cache.DelUserConversationCache(r.rdb, userId); err != nil {
+			r.logger.Error(err.Error(), zap.Any("DelUserConversationCache", userId))
+		}
+	})
+	return nil
+}
+
+// 获取用户的某个会话
+func (r *chatRepository) SelectUserConversation(ctx context.Context, userId, conversationId int64) (*model.UserConversationList, error) {
+	if info, err := cache.GetUserConversationCache(r.rdb, userId, conversationId); err == nil {
+		return info, nil
+	}
+
+	var info model.UserConversationList
+	if err := r.DB(ctx).Where("user_id=? and conversation_id=?", userId, conversationId).First(&info).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, v1.ErrNotFound
+		}
+		return nil, err
+	}
+
+	if err := cache.SetUserConversationCache(r.rdb, info); err != nil {
+		r.logger.Error(err.Error(), zap.Any("SetUserConversationCache", info))
+	}
+	return &info, nil
+}
+
+// 获取用户会话链信息，不包含消息请求
 func (r *chatRepository) SelectUserConversationList(ctx context.Context, userId, pageNum, pageSize int64) ([]model.UserConversationList, error) {
 
 	list, err := cache.GetUserConversationListCache(r.rdb, userId, pageNum, pageSize)
@@ -254,7 +381,8 @@ func (r *chatRepository) SelectUserConversationList(ctx context.Context, userId,
 		return list, nil
 	}
 
-	err = r.DB(ctx).Where("user_id=?", userId).Limit(int(pageSize)).Offset(int((pageNum - 1) * pageSize)).Find(&list).Error
+	err = r.DB(ctx).Where("user_id=? and request_status=?", userId, contants.MsgRequestStatusNone).
+		Limit(int(pageSize)).Offset(int((pageNum - 1) * pageSize)).Find(&list).Error
 	if len(list) > 0 {
 		if err = cache.SetUserConversationCache(r.rdb, list...); err != nil {
 			r.logger.Error(err.Error())
@@ -264,6 +392,14 @@ func (r *chatRepository) SelectUserConversationList(ctx context.Context, userId,
 	return list, err
 }
 
+// 待处理的陌生人消息请求，按最近更新排序
+func (r *chatRepository) SelectUserConversationRequestList(ctx context.Context, userId, pageNum, pageSize int64) ([]model.UserConversationList, error) {
+	var list []model.UserConversationList
+	err := r.DB(ctx).Where("user_id=? and request_status=?", userId, contants.MsgRequestStatusPending).Order("updated_at desc").
+		Limit(int(pageSize)).Offset(int((pageNum - 1) * pageSize)).Find(&list).Error
+	return list, err
+}
+
 // 会话下的所有用户
 func (r *chatRepository) SelectConversationUsers(ctx context.Context, conversationId int64) ([]model.UserInfo, error) {
 
@@ -277,7 +413,7 @@ func (r *chatRepository) SelectConversationUsers(ctx context.Context, conversati
 	}
 
 	var info []model.AccountInfo
-	querySql := "SELECT u.`user_id`,u.`nick_name`,u.`avatar`,u.`gender`,u.`status`,r.`email`,r.`phone` " +
+	querySql := "SELECT " + accountInfoColumns + " " +
 		"FROM `user_info` u INNER JOIN `register` r ON u.`user_id`=r.`user_id` " +
 		"WHERE u.`user_id` IN (SELECT uc.`user_id` FROM `user_conversation_list` uc WHERE uc.`conversation_id`=?)"
 	if err = r.DB(ctx).Raw(querySql, conversationId).Scan(&info).Error; err != nil {
@@ -336,3 +472,94 @@ func (r *chatRepository) SelectLastConversationMsg(ctx context.Context, conversa
 	}
 	return nil, errors.New("ConversationNewestMsg Not Found")
 }
+
+func (r *chatRepository) SelectUserConversationIds(ctx context.Context, userId int64) ([]int64, error) {
+	var ids []int64
+	err := r.DB(ctx).Model(&model.UserConversationList{}).Where("user_id=?", userId).Order("conversation_id asc").
+		Pluck("conversation_id", &ids).Error
+	return ids, err
+}
+
+func (r *chatRepository) SelectConversationMsgAfter(ctx context.Context, conversationId, seq int64, limit int) ([]model.MsgResp, error) {
+	var list []model.MsgResp
+	querySql := "SELECT cml.`seq`,ml.* FROM `conversation_msg_list` cml INNER JOIN `msg_list` ml ON cml.`msg_id`=ml.`msg_id` " +
+		"WHERE cml.`conversation_id`=? AND cml.`seq`>? ORDER BY cml.seq ASC LIMIT ?"
+	err := r.DB(ctx).Raw(querySql, conversationId, seq, limit).Scan(&list).Error
+	return list, err
+}
+
+// 会话和消息本身保留，其他成员仍能看到历史消息
+func (r *chatRepository) DelUserConversations(ctx context.Context, userId int64) error {
+	var convIds []int64
+	if err := r.Transaction(ctx, func(ctx context.Context) error {
+		if err := r.DB(ctx).Model(&model.UserConversationList{}).Where("user_id=?", userId).
+			Pluck("conversation_id", &convIds).Error; err != nil {
+			return err
+		}
+		if err := r.DB(ctx).Where("user_id=?", userId).Delete(&model.UserConversationList{}).Error; err != nil {
+			return err
+		}
+		return r.DB(ctx).Where("user_id=?", userId).Delete(&model.UserMsgList{}).Error
+	}); err != nil {
+		return err
+	}
+
+	if err := cache.DelUserConversationCache(r.rdb, userId); err != nil {
+		r.logger.Error(err.Error(), zap.Any("DelUserConversationCache", userId))
+	}
+	for _, v := range convIds {
+		if err := cache.RemConversationUserListCache(r.rdb, v, userId); err != nil {
+			r.logger.Error(err.Error(), zap.Any("convId", v), zap.Any("RemConversationUserListCache", userId))
+		}
+	}
+	return nil
+}
+
+func (r *chatRepository) SelectGroupList(ctx context.Context, pageNum, pageSize int) ([]model.ConversationList, int64, error) {
+	db := r.DB(ctx).Model(&model.ConversationList{}).Where("type=?", contants.ConversationTypeGroup)
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var list []model.ConversationList
+	if err := db.Order("created_at desc").Limit(pageSize).Offset((pageNum - 1) * pageSize).Find(&list).Error; err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+// 消息保留，解散后仍可用于合规审查
+func (r *chatRepository) DissolveConversation(ctx context.Context, conversationId int64) ([]int64, error) {
+	var userIds []int64
+	if err := r.Transaction(ctx, func(ctx context.Context) error {
+		if err := r.DB(ctx).Model(&model.UserConversationList{}).Where("conversation_id=?", conversationId).
+			Pluck("user_id", &userIds).Error; err != nil {
+			return err
+		}
+		if err := r.DB(ctx).Where("conversation_id=?", conversationId).Delete(&model.UserConversationList{}).Error; err != nil {
+			return err
+		}
+		return r.DB(ctx).Model(&model.ConversationList{}).Where("conversation_id=?", conversationId).
+			Updates(map[string]interface{}{"status": contants.ConversationStatusDissolved, "member": 0}).Error
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := cache.DelConversationCache(r.rdb, conversationId); err != nil {
+		r.logger.Error(err.Error(), zap.Any("DelConversationCache", conversationId))
+	}
+	for _, v := range userIds {
+		if err := cache.DelUserConversationCache(r.rdb, v); err != nil {
+			r.logger.Error(err.Error(), zap.Any("DelUserConversationCache", v))
+		}
+	}
+	return userIds, nil
+}
+
+func (r *chatRepository) IncrMsgMinuteCount(ctx context.Context, t time.Time) error {
+	return cache.IncrMsgMinuteCount(r.rdb, t.Unix()/60)
+}
+
+func (r *chatRepository) SelectMsgMinuteCounts(ctx context.Context, from time.Time, minutes int) ([]int64, error) {
+	return cache.GetMsgMinuteCounts(r.rdb, from.Unix()/60, minutes)
+}
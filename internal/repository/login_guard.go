@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"github.com/ljinf/im_server_standalone/internal/cache"
+	"time"
+)
+
+// 登录失败记录只存redis
+type LoginGuardRepository interface {
+	// 账号剩余锁定时间、剩余等待时间、ip窗口内失败次数
+	GetLoginState(ctx context.Context, account, ip string) (time.Duration, time.Duration, int64, error)
+	// 窗口内账号和ip的失败次数
+	IncrLoginFailure(ctx context.Context, account, ip string, window time.Duration) (int64, int64, error)
+	SetLoginWait(ctx context.Context, account string, wait time.Duration) error
+	LockAccount(ctx context.Context, account string, lockout time.Duration) error
+	ClearLoginFailure(ctx context.Context, account string) error
+}
+
+type loginGuardRepository struct {
+	*Repository
+}
+
+func NewLoginGuardRepository(r *Repository) LoginGuardRepository {
+	return &loginGuardRepository{
+		Repository: r,
+	}
+}
+
+func (r *loginGuardRepository) GetLoginState(ctx context.Context, account, ip string) (time.Duration, time.Duration, int64, error) {
+	return cache.GetLoginGuardCache(r.rdb, account, ip)
+}
+
+func (r *loginGuardRepository) IncrLoginFailure(ctx context.Context, account, ip string, window time.Duration) (int64, int64, error) {
+	return cache.IncrLoginFailureCache(r.rdb, account, ip, window)
+}
+
+func (r *loginGuardRepository) SetLoginWait(ctx context.Context, account string, wait time.Duration) error {
+	return cache.SetLoginWaitCache(r.rdb, account, wait)
+}
+
+func (r *loginGuardRepository) LockAccount(ctx context.Context, account string, lockout time.Duration) error {
+	return cache.SetLoginLockCache(r.rdb, account, lockout)
+}
+
+func (r *loginGuardRepository) ClearLoginFailure(ctx context.Context, account string) error {
+	return cache.DelLoginFailureCache(r.rdb, account)
+}
@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"context"
+	"github.com/ljinf/im_server_standalone/internal/model"
+)
+
+type ModerationRepository interface {
+	CreateAudit(ctx context.Context, audit *model.ModerationAudit) error
+}
+
+type moderationRepository struct {
+	*Repository
+}
+
+func NewModerationRepository(r *Repository) ModerationRepository {
+	return &moderationRepository{
+		Repository: r,
+	}
+}
+
+func (r *moderationRepository) CreateAudit(ctx context.Context, audit *model.ModerationAudit) error {
+	return r.DB(ctx).Create(audit).Error
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	v1 "github.com/ljinf/im_server_standalone/api/v1"
+	"github.com/ljinf/im_server_standalone/internal/cache"
 	"github.com/ljinf/im_server_standalone/internal/model"
+	"github.com/ljinf/im_server_standalone/pkg/contants"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -22,10 +24,35 @@ type RelationshipRepository interface {
 
 	// 关系
 	CreateRelationship(ctx context.Context, list ...model.RelationshipList) error
-	SelectRelationshipList(ctx context.Context, userId int64, relationshipType, page, pageSize int) ([]model.RelationshipList, int, error)
+	SelectRelationshipList(ctx context.Context, userId int64, relationshipType int, tagId int64, page, pageSize int) ([]model.RelationshipList, int, error)
 	SelectRelationshipOne(ctx context.Context, userId, targetId int64, relationshipType int) (*model.RelationshipList, error)
 	UpdateRelationship(ctx context.Context, info *model.RelationshipList) error
 	DelRelationship(ctx context.Context, userId, targetId int64, relationshipType int) error
+	SelectContactChanges(ctx context.Context, userId, version int64, pageSize int) ([]model.RelationshipList, error) //版本号之后变更的关系，含已删除的
+
+	// 分组标签
+	CreateTag(ctx context.Context, tag *model.RelationshipTag) error
+	UpdateTag(ctx context.Context, userId, tagId int64, name string) error
+	DelTag(ctx context.Context, userId, tagId int64) error
+	SelectTagList(ctx context.Context, userId int64) ([]model.RelationshipTag, error)
+	SetRelationshipTags(ctx context.Context, userId, targetId int64, tagIds []int64) error                   //覆盖设置好友的标签
+	SelectRelationshipTags(ctx context.Context, userId int64, targetIds ...int64) (map[int64][]int64, error) //targetId->tagIds
+
+	// 关注
+	SelectFollowList(ctx context.Context, userId int64, followers bool, cursor int64, pageSize int) ([]model.RelationshipList, error)
+	SelectFollowingIn(ctx context.Context, userId int64, targetIds ...int64) ([]int64, error) //targetIds中userId关注了的
+	SelectFollowersIn(ctx context.Context, userId int64, userIds ...int64) ([]int64, error)   //userIds中关注了userId的
+	SelectFollowCount(ctx context.Context, userId int64) (int64, int64, error)                //关注数、粉丝数
+
+	// 黑名单
+	CreateBlock(ctx context.Context, userId, targetId int64) error
+	DelBlock(ctx context.Context, userId, targetId int64) error
+	SelectBlockList(ctx context.Context, userId int64, page, pageSize int) ([]model.RelationshipList, int, error)
+	SelectBlocked(ctx context.Context, userId, targetId int64) (bool, error) //双方任意一方拉黑了对方
+	IsBlocked(ctx context.Context, userId, targetId int64) (bool, error)     //userId是否拉黑了targetId
+
+	// 注销账号时删除与该用户相关的所有关系
+	DelUserRelationships(ctx context.Context, userId int64) error
 }
 
 type relationshipRepository struct {
@@ -54,7 +81,7 @@ func (r *relationshipRepository) UpdateApplyFriendship(ctx context.Context, info
 
 func (r *relationshipRepository) SelectApplyOne(ctx context.Context, userId, targetId int64) (*model.ApplyFriendshipList, error) {
 	var info model.ApplyFriendshipList
-	if err := r.DB(ctx).Where("user_id=? and target_id=?", userId, targetId).Find(&info).Error; err != nil {
+	if err := r.DB(ctx).Where("user_id=? and target_id=?", userId, targetId).First(&info).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, v1.ErrNotFound
 		}
@@ -76,24 +103,85 @@ func (r *relationshipRepository) DelApplyFriendship(ctx context.Context, userId,
 	return r.DB(ctx).Where("user_id=? and target_id=?", userId, targetId).Delete(&model.ApplyFriendshipList{}).Error
 }
 
-// 关系相关
+// 关系相关，之前删除过的关系会被恢复
 func (r *relationshipRepository) CreateRelationship(ctx context.Context, list ...model.RelationshipList) error {
 	if len(list) > 0 {
-		result := r.DB(ctx).Create(&list)
-		if result.Error != nil {
-			return result.Error
-		}
-		if int(result.RowsAffected) != len(list) {
-			return errors.New("create relationship failed")
+		if err := r.Transaction(ctx, func(ctx context.Context) error {
+			for i := range list {
+				version, err := r.incrContactVersion(ctx, list[i].UserId)
+				if err != nil {
+					return err
+				}
+				list[i].Version = version
+			}
+
+			result := r.DB(ctx).Clauses(clause.OnConflict{
+				DoUpdates: clause.Assignments(map[string]interface{}{"remark": gorm.Expr("VALUES(remark)"),
+					"status": gorm.Expr("VALUES(status)"), "extra": gorm.Expr("VALUES(extra)"), "version": gorm.Expr("VALUES(version)"),
+					"updated_at": gorm.Expr("VALUES(updated_at)"), "deleted_at": nil}),
+			}).Create(&list)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errors.New("create relationship failed")
+			}
+			return nil
+		}); err != nil {
+			return err
 		}
+		r.delFollowCountCache(list...)
 	}
 	return nil
 }
 
-func (r *relationshipRepository) SelectRelationshipList(ctx context.Context, userId int64, relationshipType, page, pageSize int) ([]model.RelationshipList, int, error) {
+// 递增用户的通讯录版本号，须在事务中调用，行锁保证同一用户的版本号按提交顺序递增
+func (r *relationshipRepository) incrContactVersion(ctx context.Context, userId int64) (int64, error) {
+	if err := r.DB(ctx).Clauses(clause.OnConflict{
+		DoUpdates: clause.Assignments(map[string]interface{}{"version": gorm.Expr("version+1")}),
+	}).Create(&model.ContactVersion{UserId: userId, Version: 1}).Error; err != nil {
+		return 0, err
+	}
+
+	var info model.ContactVersion
+	if err := r.DB(ctx).Where("user_id=?", userId).First(&info).Error; err != nil {
+		return 0, err
+	}
+	return info.Version, nil
+}
+
+// 按条件更新userId的关系，每条记录分配一个新的版本号
+func (r *relationshipRepository) updateWithVersion(ctx context.Context, userId int64, updates map[string]interface{}, query string, args ...interface{}) error {
+	return r.Transaction(ctx, func(ctx context.Context) error {
+		var ids []int64
+		if err := r.DB(ctx).Model(&model.RelationshipList{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id=?", userId).Where(query, args...).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			version, err := r.incrContactVersion(ctx, userId)
+			if err != nil {
+				return err
+			}
+			updates["version"] = version
+			if err = r.DB(ctx).Model(&model.RelationshipList{}).Where("id=?", id).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *relationshipRepository) SelectRelationshipList(ctx context.Context, userId int64, relationshipType int, tagId int64, page, pageSize int) ([]model.RelationshipList, int, error) {
 
 	conds := []string{"user_id=?", "status=?", "relationship_type=?"}
 	values := []interface{}{userId, 1, relationshipType}
+	if tagId > 0 {
+		conds = append(conds, "target_id in (?)")
+		values = append(values, r.DB(ctx).Model(&model.RelationshipTagMember{}).Select("target_id").
+			Where("user_id=? and tag_id=?", userId, tagId))
+	}
 	lists, total, err := r.doSelectRelationship(ctx, conds, values, page, pageSize)
 	if err != nil {
 		return nil, 0, err
@@ -133,11 +221,364 @@ func (r *relationshipRepository) doSelectRelationship(ctx context.Context, conds
 }
 
 func (r *relationshipRepository) UpdateRelationship(ctx context.Context, info *model.RelationshipList) error {
-	return r.DB(ctx).Where("user_id=? and target_id=? and relationship_type=?",
-		info.UserId, info.TargetId, info.RelationshipType).Updates(info).Error
+	// 只更新非零值字段
+	updates := make(map[string]interface{})
+	if info.Remark != "" {
+		updates["remark"] = info.Remark
+	}
+	if info.Status != 0 {
+		updates["status"] = info.Status
+	}
+	if info.Extra != "" {
+		updates["extra"] = info.Extra
+	}
+	if len(updates) < 1 {
+		return nil
+	}
+
+	if err := r.updateWithVersion(ctx, info.UserId, updates, "target_id=? and relationship_type=?",
+		info.TargetId, info.RelationshipType); err != nil {
+		return err
+	}
+	// 状态可能改为拉黑
+	r.delBlockListCache(ctx, info.UserId)
+	r.delFollowCountCache(*info)
+	return nil
 }
 
+// 软删除，同步时客户端据此删除本地记录
 func (r *relationshipRepository) DelRelationship(ctx context.Context, userId, targetId int64, relationshipType int) error {
-	return r.DB(ctx).Where("user_id=? and target_id=? and relationship_type=?", userId, targetId, relationshipType).
-		Delete(&model.RelationshipList{}).Error
+	if err := r.Transaction(ctx, func(ctx context.Context) error {
+		if err := r.updateWithVersion(ctx, userId, map[string]interface{}{"deleted_at": time.Now()},
+			"target_id=? and relationship_type=?", targetId, relationshipType); err != nil {
+			return err
+		}
+		if relationshipType != contants.RelationshipTypeFriend {
+			return nil
+		}
+		// 删除好友同时移出所有分组
+		return r.DB(ctx).Where("user_id=? and target_id=?", userId, targetId).Delete(&model.RelationshipTagMember{}).Error
+	}); err != nil {
+		return err
+	}
+	r.delFollowCountCache(model.RelationshipList{UserId: userId, TargetId: targetId, RelationshipType: relationshipType})
+	return nil
+}
+
+func (r *relationshipRepository) SelectContactChanges(ctx context.Context, userId, version int64, pageSize int) ([]model.RelationshipList, error) {
+	db := r.DB(ctx).Unscoped().Where("user_id=? and version>?", userId, version)
+	// 全量同步时不需要已删除的
+	if version == 0 {
+		db = db.Where("deleted_at is null")
+	}
+
+	var list []model.RelationshipList
+	if err := db.Order("version asc").Limit(pageSize).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// 在事务中时提交后再删除，否则提交前并发读到的旧黑名单会重新写入缓存，拉黑不生效
+func (r *relationshipRepository) delBlockListCache(ctx context.Context, userIds ...int64) {
+	r.afterCommit(ctx, func() {
+		for _, v := range userIds {
+			if err := cache.DelBlockListCache(r.rdb, v); err != nil {
+				r.logger.Error(err.Error(), zap.Any("DelBlockListCache", v))
+			}
+		}
+	})
+}
+
+// 关注关系变化后删除双方的关注数缓存
+func (r *relationshipRepository) delFollowCountCache(list ...model.RelationshipList) {
+	userIds := make([]int64, 0, len(list)*2)
+	for _, v := range list {
+		if v.RelationshipType == contants.RelationshipTypeFollow {
+			userIds = append(userIds, v.UserId, v.TargetId)
+		}
+	}
+	if err := cache.DelFollowCountCache(r.rdb, userIds...); err != nil {
+		r.logger.Error(err.Error(), zap.Any("DelFollowCountCache", userIds))
+	}
+}
+
+// 关注列表或粉丝列表，按id倒序游标分页
+func (r *relationshipRepository) SelectFollowList(ctx context.Context, userId int64, followers bool, cursor int64, pageSize int) ([]model.RelationshipList, error) {
+	column := "user_id"
+	if followers {
+		column = "target_id"
+	}
+	db := r.DB(ctx).Where(column+"=? and relationship_type=? and status=?", userId,
+		contants.RelationshipTypeFollow, contants.RelationshipStatusNormal)
+	if cursor > 0 {
+		db = db.Where("id<?", cursor)
+	}
+
+	var list []model.RelationshipList
+	if err := db.Order("id desc").Limit(pageSize).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *relationshipRepository) SelectFollowingIn(ctx context.Context, userId int64, targetIds ...int64) ([]int64, error) {
+	var ids []int64
+	if len(targetIds) < 1 {
+		return ids, nil
+	}
+	err := r.DB(ctx).Model(&model.RelationshipList{}).Where("user_id=? and target_id in ? and relationship_type=? and status=?",
+		userId, targetIds, contants.RelationshipTypeFollow, contants.RelationshipStatusNormal).Pluck("target_id", &ids).Error
+	return ids, err
+}
+
+func (r *relationshipRepository) SelectFollowersIn(ctx context.Context, userId int64, userIds ...int64) ([]int64, error) {
+	var ids []int64
+	if len(userIds) < 1 {
+		return ids, nil
+	}
+	err := r.DB(ctx).Model(&model.RelationshipList{}).Where("target_id=? and user_id in ? and relationship_type=? and status=?",
+		userId, userIds, contants.RelationshipTypeFollow, contants.RelationshipStatusNormal).Pluck("user_id", &ids).Error
+	return ids, err
+}
+
+func (r *relationshipRepository) SelectFollowCount(ctx context.Context, userId int64) (int64, int64, error) {
+	following, followers, exists, err := cache.GetFollowCountCache(r.rdb, userId)
+	if err != nil {
+		r.logger.Error(err.Error(), zap.Any("userId", userId))
+	}
+	if exists {
+		return following, followers, nil
+	}
+
+	if err = r.DB(ctx).Model(&model.RelationshipList{}).Where("user_id=? and relationship_type=? and status=?",
+		userId, contants.RelationshipTypeFollow, contants.RelationshipStatusNormal).Count(&following).Error; err != nil {
+		return 0, 0, err
+	}
+	if err = r.DB(ctx).Model(&model.RelationshipList{}).Where("target_id=? and relationship_type=? and status=?",
+		userId, contants.RelationshipTypeFollow, contants.RelationshipStatusNormal).Count(&followers).Error; err != nil {
+		return 0, 0, err
+	}
+
+	if err = cache.SetFollowCountCache(r.rdb, userId, following, followers); err != nil {
+		r.logger.Error(err.Error(), zap.Any("SetFollowCountCache", userId))
+	}
+	return following, followers, nil
+}
+
+// 黑名单
+func (r *relationshipRepository) CreateBlock(ctx context.Context, userId, targetId int64) error {
+	now := time.Now()
+	info := model.RelationshipList{
+		UserId:           userId,
+		TargetId:         targetId,
+		RelationshipType: contants.RelationshipTypeBlock,
+		Status:           contants.RelationshipStatusBlock,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	// 曾经拉黑过(已软删除)则恢复
+	if err := r.Transaction(ctx, func(ctx context.Context) error {
+		version, err := r.incrContactVersion(ctx, userId)
+		if err != nil {
+			return err
+		}
+		info.Version = version
+		return r.DB(ctx).Clauses(clause.OnConflict{
+			DoUpdates: clause.Assignments(map[string]interface{}{"status": info.Status, "version": version,
+				"updated_at": now, "deleted_at": nil}),
+		}).Create(&info).Error
+	}); err != nil {
+		return err
+	}
+	r.delBlockListCache(ctx, userId)
+	return nil
+}
+
+// 移出黑名单，同时恢复通过修改关系状态拉黑的记录
+func (r *relationshipRepository) DelBlock(ctx context.Context, userId, targetId int64) error {
+	if err := r.Transaction(ctx, func(ctx context.Context) error {
+		if err := r.updateWithVersion(ctx, userId, map[string]interface{}{"deleted_at": time.Now()},
+			"target_id=? and relationship_type=?", targetId, contants.RelationshipTypeBlock); err != nil {
+			return err
+		}
+		return r.updateWithVersion(ctx, userId, map[string]interface{}{"status": contants.RelationshipStatusNormal},
+			"target_id=? and status=?", targetId, contants.RelationshipStatusBlock)
+	}); err != nil {
+		return err
+	}
+	r.delBlockListCache(ctx, userId)
+	return nil
+}
+
+func (r *relationshipRepository) SelectBlockList(ctx context.Context, userId int64, page, pageSize int) ([]model.RelationshipList, int, error) {
+	conds := []string{"user_id=?", "status=?", "relationship_type=?"}
+	values := []interface{}{userId, contants.RelationshipStatusBlock, contants.RelationshipTypeBlock}
+	return r.doSelectRelationship(ctx, conds, values, page, pageSize)
+}
+
+func (r *relationshipRepository) SelectBlocked(ctx context.Context, userId, targetId int64) (bool, error) {
+	blocked, err := r.IsBlocked(ctx, userId, targetId)
+	if err != nil || blocked {
+		return blocked, err
+	}
+	return r.IsBlocked(ctx, targetId, userId)
+}
+
+// userId是否拉黑了targetId
+func (r *relationshipRepository) IsBlocked(ctx context.Context, userId, targetId int64) (bool, error) {
+	blocked, exists, err := cache.IsBlockedCache(r.rdb, userId, targetId)
+	if err != nil {
+		r.logger.Error(err.Error(), zap.Any("userId", userId))
+	}
+	if exists {
+		return blocked, nil
+	}
+
+	var targetIds []int64
+	if err = r.DB(ctx).Model(&model.RelationshipList{}).Distinct("target_id").
+		Where("user_id=? and status=?", userId, contants.RelationshipStatusBlock).Pluck("target_id", &targetIds).Error; err != nil {
+		return false, err
+	}
+	if err = cache.SetBlockListCache(r.rdb, userId, targetIds...); err != nil {
+		r.logger.Error(err.Error(), zap.Any("SetBlockListCache", userId))
+	}
+
+	for _, v := range targetIds {
+		if v == targetId {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// 分组标签
+func (r *relationshipRepository) CreateTag(ctx context.Context, tag *model.RelationshipTag) error {
+	return r.DB(ctx).Create(tag).Error
+}
+
+func (r *relationshipRepository) UpdateTag(ctx context.Context, userId, tagId int64, name string) error {
+	result := r.DB(ctx).Model(&model.RelationshipTag{}).Where("id=? and user_id=?", tagId, userId).Update("name", name)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return v1.ErrNotFound
+	}
+	return nil
+}
+
+// 删除标签，标签下好友的版本号随之递增
+func (r *relationshipRepository) DelTag(ctx context.Context, userId, tagId int64) error {
+	return r.Transaction(ctx, func(ctx context.Context) error {
+		result := r.DB(ctx).Where("id=? and user_id=?", tagId, userId).Delete(&model.RelationshipTag{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return v1.ErrNotFound
+		}
+
+		var targetIds []int64
+		if err := r.DB(ctx).Model(&model.RelationshipTagMember{}).Where("tag_id=?", tagId).
+			Pluck("target_id", &targetIds).Error; err != nil {
+			return err
+		}
+		if len(targetIds) < 1 {
+			return nil
+		}
+		if err := r.DB(ctx).Where("tag_id=?", tagId).Delete(&model.RelationshipTagMember{}).Error; err != nil {
+			return err
+		}
+		return r.updateWithVersion(ctx, userId, map[string]interface{}{}, "target_id in ?", targetIds)
+	})
+}
+
+func (r *relationshipRepository) SelectTagList(ctx context.Context, userId int64) ([]model.RelationshipTag, error) {
+	var list []model.RelationshipTag
+	if err := r.DB(ctx).Where("user_id=?", userId).Order("id asc").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *relationshipRepository) SetRelationshipTags(ctx context.Context, userId, targetId int64, tagIds []int64) error {
+	return r.Transaction(ctx, func(ctx context.Context) error {
+		if err := r.DB(ctx).Where("user_id=? and target_id=?", userId, targetId).
+			Delete(&model.RelationshipTagMember{}).Error; err != nil {
+			return err
+		}
+
+		if len(tagIds) > 0 {
+			now := time.Now()
+			members := make([]model.RelationshipTagMember, 0, len(tagIds))
+			for _, v := range tagIds {
+				members = append(members, model.RelationshipTagMember{
+					UserId:    userId,
+					TagId:     v,
+					TargetId:  targetId,
+					CreatedAt: now,
+				})
+			}
+			if err := r.DB(ctx).Create(&members).Error; err != nil {
+				return err
+			}
+		}
+		return r.updateWithVersion(ctx, userId, map[string]interface{}{}, "target_id=?", targetId)
+	})
+}
+
+func (r *relationshipRepository) SelectRelationshipTags(ctx context.Context, userId int64, targetIds ...int64) (map[int64][]int64, error) {
+	tags := make(map[int64][]int64)
+	if len(targetIds) < 1 {
+		return tags, nil
+	}
+
+	var members []model.RelationshipTagMember
+	if err := r.DB(ctx).Where("user_id=? and target_id in ?", userId, targetIds).
+		Order("tag_id asc").Find(&members).Error; err != nil {
+		return nil, err
+	}
+	for _, v := range members {
+		tags[v.TargetId] = append(tags[v.TargetId], v.TagId)
+	}
+	return tags, nil
+}
+
+// 删除用户自己的关系、分组标签和好友申请，其他人关系中的该用户也一并删除并递增对方的版本号
+func (r *relationshipRepository) DelUserRelationships(ctx context.Context, userId int64) error {
+	var ownerIds []int64
+	if err := r.Transaction(ctx, func(ctx context.Context) error {
+		now := time.Now()
+		if err := r.updateWithVersion(ctx, userId, map[string]interface{}{"deleted_at": now}, "1=1"); err != nil {
+			return err
+		}
+
+		if err := r.DB(ctx).Model(&model.RelationshipList{}).Distinct("user_id").Where("target_id=?", userId).
+			Pluck("user_id", &ownerIds).Error; err != nil {
+			return err
+		}
+		for _, ownerId := range ownerIds {
+			if err := r.updateWithVersion(ctx, ownerId, map[string]interface{}{"deleted_at": now}, "target_id=?", userId); err != nil {
+				return err
+			}
+		}
+
+		if err := r.DB(ctx).Where("user_id=? or target_id=?", userId, userId).Delete(&model.RelationshipTagMember{}).Error; err != nil {
+			return err
+		}
+		if err := r.DB(ctx).Where("user_id=?", userId).Delete(&model.RelationshipTag{}).Error; err != nil {
+			return err
+		}
+		return r.DB(ctx).Where("user_id=? or target_id=?", userId, userId).Delete(&model.ApplyFriendshipList{}).Error
+	}); err != nil {
+		return err
+	}
+
+	userIds := append(ownerIds, userId)
+	r.delBlockListCache(ctx, userIds...)
+	if err := cache.DelFollowCountCache(r.rdb, userIds...); err != nil {
+		r.logger.Error(err.Error(), zap.Any("DelFollowCountCache", userIds))
+	}
+	return nil
 }
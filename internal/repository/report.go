@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	v1 "github.com/ljinf/im_server_standalone/api/v1"
+	"github.com/ljinf/im_server_standalone/internal/model"
+	"github.com/ljinf/im_server_standalone/pkg/contants"
+	"gorm.io/gorm"
+)
+
+type ReportRepository interface {
+	CreateReport(ctx context.Context, report *model.Report) error
+	GetReport(ctx context.Context, id int64) (*model.Report, error)
+	// 举报人对同一对象是否还有待处理的举报
+	ExistsPendingReport(ctx context.Context, reporterId int64, targetType int, targetId int64) (bool, error)
+	// 审核队列，status为-1时不筛选状态，targetType和targetId为0时不筛选
+	SelectReportList(ctx context.Context, status, targetType int, targetId int64, pageNum, pageSize int) ([]model.Report, int64, error)
+	SelectUserReportList(ctx context.Context, reporterId int64, pageNum, pageSize int) ([]model.Report, error)
+	// 只更新待处理的举报，已被处理时返回false
+	HandleReport(ctx context.Context, report *model.Report) (bool, error)
+}
+
+type reportRepository struct {
+	*Repository
+}
+
+func NewReportRepository(r *Repository) ReportRepository {
+	return &reportRepository{
+		Repository: r,
+	}
+}
+
+func (r *reportRepository) CreateReport(ctx context.Context, report *model.Report) error {
+	return r.DB(ctx).Create(report).Error
+}
+
+func (r *reportRepository) GetReport(ctx context.Context, id int64) (*model.Report, error) {
+	var report model.Report
+	if err := r.DB(ctx).Where("id=?", id).First(&report).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, v1.ErrNotFound
+		}
+		return nil, err
+	}
+	return &report, nil
+}
+
+func (r *reportRepository) ExistsPendingReport(ctx context.Context, reporterId int64, targetType int, targetId int64) (bool, error) {
+	var count int64
+	if err := r.DB(ctx).Model(&model.Report{}).Where("reporter_id=? and target_type=? and target_id=? and status=?",
+		reporterId, targetType, targetId, contants.ReportStatusPending).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *reportRepository) SelectReportList(ctx context.Context, status, targetType int, targetId int64, pageNum, pageSize int) ([]model.Report, int64, error) {
+	db := r.DB(ctx).Model(&model.Report{})
+	if status >= 0 {
+		db = db.Where("status=?", status)
+	}
+	if targetType > 0 {
+		db = db.Where("target_type=?", targetType)
+	}
+	if targetId > 0 {
+		db = db.Where("target_id=?", targetId)
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	// 待处理的先处理早提交的
+	order := "id desc"
+	if status == contants.ReportStatusPending {
+		order = "id asc"
+	}
+	var list []model.Report
+	if err := db.Order(order).Limit(pageSize).Offset((pageNum - 1) * pageSize).Find(&list).Error; err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+func (r *reportRepository) SelectUserReportList(ctx context.Context, reporterId int64, pageNum, pageSize int) ([]model.Report, error) {
+	var list []model.Report
+	if err := r.DB(ctx).Where("reporter_id=?", reporterId).Order("id desc").
+		Limit(pageSize).Offset((pageNum - 1) * pageSize).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *reportRepository) HandleReport(ctx context.Context, report *model.Report) (bool, error) {
+	result := r.DB(ctx).Model(&model.Report{}).Where("id=? and status=?", report.Id, contants.ReportStatusPending).
+		Updates(map[string]interface{}{
+			"status":     report.Status,
+			"action":     report.Action,
+			"handler_id": report.HandlerId,
+			"note":       report.Note,
+			"handled_at": report.HandledAt,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
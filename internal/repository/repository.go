@@ -12,7 +12,15 @@ import (
 	"time"
 )
 
-const ctxTxKey = "TxKey"
+const (
+	ctxTxKey          = "TxKey"
+	ctxAfterCommitKey = "AfterCommitKey"
+)
+
+// 最外层事务提交后执行的函数
+type afterCommitHooks struct {
+	fns []func()
+}
 
 type Repository struct {
 	db             *gorm.DB
@@ -57,11 +65,39 @@ func (r *Repository) DB(ctx context.Context) *gorm.DB {
 	return r.db.WithContext(ctx)
 }
 
+// 事务中读写的数据在提交前可能回滚，不能写入缓存
+func (r *Repository) inTx(ctx context.Context) bool {
+	_, ok := ctx.Value(ctxTxKey).(*gorm.DB)
+	return ok
+}
+
+// 在事务中时推迟到最外层事务提交后执行，回滚时不执行；不在事务中时立即执行。
+// 用于删除缓存：提交前删除的话，并发的读请求会把旧数据重新写入缓存
+func (r *Repository) afterCommit(ctx context.Context, fn func()) {
+	if hooks, ok := ctx.Value(ctxAfterCommitKey).(*afterCommitHooks); ok && r.inTx(ctx) {
+		hooks.fns = append(hooks.fns, fn)
+		return
+	}
+	fn()
+}
+
+// Transaction 已处于事务中时复用外层事务(savepoint)，保证跨repository操作的原子性
 func (r *Repository) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
-	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+	hooks, nested := ctx.Value(ctxAfterCommitKey).(*afterCommitHooks)
+	if !nested {
+		hooks = &afterCommitHooks{}
+		ctx = context.WithValue(ctx, ctxAfterCommitKey, hooks)
+	}
+	err := r.DB(ctx).Transaction(func(tx *gorm.DB) error {
 		ctx = context.WithValue(ctx, ctxTxKey, tx)
 		return fn(ctx)
 	})
+	if err == nil && !nested {
+		for _, f := range hooks.fns {
+			f()
+		}
+	}
+	return err
 }
 
 func NewDB(conf *viper.Viper, l *log.Logger) *gorm.DB {
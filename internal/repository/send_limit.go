@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/ljinf/im_server_standalone/internal/cache"
+	"time"
+)
+
+// 发送频率和重复消息计数只存redis
+type SendLimitRepository interface {
+	// 取一个令牌，返回是否成功和需要等待的时间
+	TakeToken(ctx context.Context, name, key string, rate float64, burst int64) (bool, time.Duration, error)
+	// 窗口内用户发送相同内容的次数
+	IncrDuplicateMsg(ctx context.Context, userId int64, digest string, window time.Duration) (int64, error)
+}
+
+type sendLimitRepository struct {
+	*Repository
+}
+
+func NewSendLimitRepository(r *Repository) SendLimitRepository {
+	return &sendLimitRepository{
+		Repository: r,
+	}
+}
+
+func (r *sendLimitRepository) TakeToken(ctx context.Context, name, key string, rate float64, burst int64) (bool, time.Duration, error) {
+	return cache.TakeTokenCache(r.rdb, name, key, rate, burst)
+}
+
+func (r *sendLimitRepository) IncrDuplicateMsg(ctx context.Context, userId int64, digest string, window time.Duration) (int64, error) {
+	return cache.IncrRateLimitCache(r.rdb, "msg_dup", fmt.Sprintf("%v:%v", userId, digest), window)
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/ljinf/im_server_standalone/internal/cache"
+	"github.com/ljinf/im_server_standalone/internal/model"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+var (
+	ErrSessionNotFound     = cache.ErrSessionNotFound
+	ErrRefreshTokenInvalid = cache.ErrRefreshTokenInvalid
+	ErrRefreshTokenReused  = cache.ErrRefreshTokenReused
+)
+
+// 登录会话只存redis
+type SessionRepository interface {
+	CreateSession(ctx context.Context, session *model.Session, ttl time.Duration) error
+	GetSession(ctx context.Context, sessionId string) (*model.Session, error)
+	TouchSession(ctx context.Context, userId int64, sessionId string) (bool, error) //会话是否有效，有效则更新最后活跃时间
+	SelectUserSessions(ctx context.Context, userId int64) ([]model.Session, error)
+	RotateSession(ctx context.Context, sessionId, oldHash, newHash string, ttl time.Duration) (*model.Session, error)
+	DelSession(ctx context.Context, userId int64, sessionId string) error
+}
+
+type sessionRepository struct {
+	*Repository
+}
+
+func NewSessionRepository(r *Repository) SessionRepository {
+	return &sessionRepository{
+		Repository: r,
+	}
+}
+
+func (r *sessionRepository) CreateSession(ctx context.Context, session *model.Session, ttl time.Duration) error {
+	return cache.SetSessionCache(r.rdb, session, ttl)
+}
+
+func (r *sessionRepository) GetSession(ctx context.Context, sessionId string) (*model.Session, error) {
+	session, err := cache.GetSessionCache(r.rdb, sessionId)
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	return session, nil
+}
+
+func (r *sessionRepository) TouchSession(ctx context.Context, userId int64, sessionId string) (bool, error) {
+	return cache.TouchSessionCache(r.rdb, userId, sessionId)
+}
+
+func (r *sessionRepository) SelectUserSessions(ctx context.Context, userId int64) ([]model.Session, error) {
+	return cache.GetUserSessionsCache(r.rdb, userId)
+}
+
+func (r *sessionRepository) RotateSession(ctx context.Context, sessionId, oldHash, newHash string, ttl time.Duration) (*model.Session, error) {
+	return cache.RotateSessionCache(r.rdb, sessionId, oldHash, newHash, ttl)
+}
+
+func (r *sessionRepository) DelSession(ctx context.Context, userId int64, sessionId string) error {
+	return cache.DelSessionCache(r.rdb, userId, sessionId)
+}
@@ -6,24 +6,41 @@ import (
 	v1 "github.com/ljinf/im_server_standalone/api/v1"
 	"github.com/ljinf/im_server_standalone/internal/cache"
 	"github.com/ljinf/im_server_standalone/internal/model"
+	"github.com/ljinf/im_server_standalone/pkg/contants"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
 	"time"
 )
 
 type UserRepository interface {
 	//创建注册信息
 	CreateRegister(ctx context.Context, req *model.AccountInfo) error
-	UpdateRegister(ctx context.Context, req *model.Register) error
+	UpdateRegisterColumns(ctx context.Context, userId int64, columns map[string]interface{}) error //按列更新，可以更新为零值
+	GetRegisterByID(ctx context.Context, userId int64) (*model.Register, error)
 	GetByEmail(ctx context.Context, email string) (*model.Register, error)
 	GetByPhone(ctx context.Context, phone string) (*model.Register, error)
 
 	GetByID(ctx context.Context, id int64) (*model.UserInfo, error)
 	UpdateUserInfo(ctx context.Context, req *model.UserInfo) error
+	UpdateUserInfoColumns(ctx context.Context, userId int64, columns map[string]interface{}) error
 
 	GetAccountInfoByID(ctx context.Context, userId int64) (*model.AccountInfo, error)
+	SearchByNickName(ctx context.Context, prefix string, limit int) ([]model.UserInfo, error) //昵称前缀搜索，只返回允许被昵称搜索的用户
+	DeleteAccount(ctx context.Context, userId int64) error                                    //注销，清除个人信息后软删除
+
+	// 自定义资料字段
+	SelectProfileFields(ctx context.Context, userId int64) ([]model.UserProfileField, error)
+	SaveProfileFields(ctx context.Context, userId int64, fields []model.UserProfileField, delKeys []string) error //按字段名覆盖，delKeys删除
 }
 
+// 查询AccountInfo的列，缓存中的AccountInfo都由这些列组成
+const accountInfoColumns = "u.`user_id`,u.`nick_name`,u.`avatar`,u.`gender`,u.`status`,u.`silent_flag`,u.`silent_until`,u.`msg_allow_type`," +
+	"u.`self_signature` AS `signature`,u.`birth_day` AS `birthday`,u.`region`," +
+	"u.`signature_visibility`,u.`birthday_visibility`,u.`region_visibility`," +
+	"r.`email`,r.`phone`,r.`email_verified`,r.`phone_verified`,r.`created_at` AS `registered_at`"
+
 func NewUserRepository(r *Repository) UserRepository {
 	return &userRepository{
 		Repository: r,
@@ -39,12 +56,14 @@ func (r *userRepository) CreateRegister(ctx context.Context, req *model.AccountI
 		now := time.Now()
 		//注册信息
 		registerInfo := model.Register{
-			UserId:    req.UserId,
-			Phone:     req.Phone,
-			Email:     req.Email,
-			Password:  req.Password,
-			CreatedAt: now,
-			UpdatedAt: now,
+			UserId:        req.UserId,
+			Phone:         req.Phone,
+			Email:         req.Email,
+			Password:      req.Password,
+			EmailVerified: req.EmailVerified,
+			PhoneVerified: req.PhoneVerified,
+			CreatedAt:     now,
+			UpdatedAt:     now,
 		}
 
 		if err := tx.Create(&registerInfo).Error; err != nil {
@@ -84,12 +103,49 @@ func (r *userRepository) GetByPhone(ctx context.Context, phone string) (*model.R
 	return &user, nil
 }
 
-func (r *userRepository) UpdateRegister(ctx context.Context, req *model.Register) error {
-	return r.DB(ctx).Where("user_id=?", req.UserId).Updates(req).Error
+func (r *userRepository) GetRegisterByID(ctx context.Context, userId int64) (*model.Register, error) {
+	var user model.Register
+	if err := r.DB(ctx).Where("user_id = ?", userId).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, v1.ErrNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *userRepository) UpdateRegisterColumns(ctx context.Context, userId int64, columns map[string]interface{}) error {
+	if err := r.DB(ctx).Model(&model.Register{}).Where("user_id=?", userId).Updates(columns).Error; err != nil {
+		return err
+	}
+	r.delAccountInfoCache(ctx, userId)
+	return nil
 }
 
 func (r *userRepository) UpdateUserInfo(ctx context.Context, req *model.UserInfo) error {
-	return r.DB(ctx).Where("user_id=?", req.UserId).Updates(req).Error
+	if err := r.DB(ctx).Where("user_id=?", req.UserId).Updates(req).Error; err != nil {
+		return err
+	}
+	r.delAccountInfoCache(ctx, req.UserId)
+	return nil
+}
+
+// 按列更新，可以更新为零值
+func (r *userRepository) UpdateUserInfoColumns(ctx context.Context, userId int64, columns map[string]interface{}) error {
+	if err := r.DB(ctx).Model(&model.UserInfo{}).Where("user_id=?", userId).Updates(columns).Error; err != nil {
+		return err
+	}
+	r.delAccountInfoCache(ctx, userId)
+	return nil
+}
+
+// 在事务中时提交后再删除，否则提交前并发读到的旧状态会重新写入缓存，如封禁不生效
+func (r *userRepository) delAccountInfoCache(ctx context.Context, userId int64) {
+	r.afterCommit(ctx, func() {
+		if err := cache.DelAccountInfoCache(r.rdb, userId); err != nil {
+			r.logger.Error(err.Error(), zap.Any("DelAccountInfoCache", userId))
+		}
+	})
 }
 
 func (r *userRepository) GetByID(ctx context.Context, userId int64) (*model.UserInfo, error) {
@@ -115,7 +171,7 @@ func (r *userRepository) GetAccountInfoByID(ctx context.Context, userId int64) (
 	}
 
 	var info model.AccountInfo
-	querySql := "SELECT u.`user_id`,u.`nick_name`,u.`avatar`,u.`gender`,r.`email`,r.`phone` " +
+	querySql := "SELECT " + accountInfoColumns + " " +
 		"FROM `user_info` u INNER JOIN `register` r ON u.`user_id`=r.`user_id` WHERE u.`user_id`=?"
 	if err := r.DB(ctx).Raw(querySql, userId).Scan(&info).Error; err != nil {
 		return nil, err
@@ -127,3 +183,67 @@ func (r *userRepository) GetAccountInfoByID(ctx context.Context, userId int64) (
 
 	return &info, nil
 }
+
+func (r *userRepository) SearchByNickName(ctx context.Context, prefix string, limit int) ([]model.UserInfo, error) {
+	// 转义LIKE通配符，只做前缀匹配以便走索引
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix)
+
+	var list []model.UserInfo
+	if err := r.DB(ctx).Where("nick_name like ? and discoverable & ? != 0 and status=?", escaped+"%",
+		contants.DiscoverByNickName, contants.UserStatusNormal).Order("nick_name asc").Limit(limit).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// 注册信息和资料都软删除，资料匿名化保留，其他人的聊天记录里显示为已注销用户
+func (r *userRepository) DeleteAccount(ctx context.Context, userId int64) error {
+	now := time.Now()
+	if err := r.Transaction(ctx, func(ctx context.Context) error {
+		if err := r.DB(ctx).Model(&model.Register{}).Where("user_id=?", userId).Updates(map[string]interface{}{
+			"email":          "",
+			"phone":          "",
+			"password":       "",
+			"email_verified": false,
+			"phone_verified": false,
+			"deleted_at":     now,
+		}).Error; err != nil {
+			return err
+		}
+		return r.DB(ctx).Model(&model.UserInfo{}).Where("user_id=?", userId).Updates(map[string]interface{}{
+			"nick_name":    contants.DeletedUserNickName,
+			"avatar":       "",
+			"gender":       0,
+			"status":       contants.UserStatusDeleted,
+			"discoverable": 0,
+			"deleted_at":   now,
+		}).Error
+	}); err != nil {
+		return err
+	}
+	r.delAccountInfoCache(ctx, userId)
+	return nil
+}
+
+func (r *userRepository) SelectProfileFields(ctx context.Context, userId int64) ([]model.UserProfileField, error) {
+	var list []model.UserProfileField
+	err := r.DB(ctx).Where("user_id=?", userId).Order("field_key asc").Find(&list).Error
+	return list, err
+}
+
+func (r *userRepository) SaveProfileFields(ctx context.Context, userId int64, fields []model.UserProfileField, delKeys []string) error {
+	return r.Transaction(ctx, func(ctx context.Context) error {
+		if len(delKeys) > 0 {
+			if err := r.DB(ctx).Where("user_id=? and field_key in ?", userId, delKeys).
+				Delete(&model.UserProfileField{}).Error; err != nil {
+				return err
+			}
+		}
+		if len(fields) == 0 {
+			return nil
+		}
+		return r.DB(ctx).Clauses(clause.OnConflict{
+			DoUpdates: clause.AssignmentColumns([]string{"value", "visibility", "updated_at"}),
+		}).Create(&fields).Error
+	})
+}
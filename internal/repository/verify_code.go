@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"context"
+	"github.com/ljinf/im_server_standalone/internal/cache"
+	"time"
+)
+
+// 验证码只存redis
+type VerifyCodeRepository interface {
+	CreateCode(ctx context.Context, scene, target, code string, ttl, cooldown time.Duration) (bool, error) //冷却期内返回false
+	CheckCode(ctx context.Context, scene, target, code string, maxAttempts int) (bool, error)
+	IncrSendCount(ctx context.Context, target string, window time.Duration) (int64, error) //窗口内向target发送的次数
+}
+
+type verifyCodeRepository struct {
+	*Repository
+}
+
+func NewVerifyCodeRepository(r *Repository) VerifyCodeRepository {
+	return &verifyCodeRepository{
+		Repository: r,
+	}
+}
+
+func (r *verifyCodeRepository) CreateCode(ctx context.Context, scene, target, code string, ttl, cooldown time.Duration) (bool, error) {
+	return cache.SetVerifyCodeCache(r.rdb, scene, target, code, ttl, cooldown)
+}
+
+func (r *verifyCodeRepository) CheckCode(ctx context.Context, scene, target, code string, maxAttempts int) (bool, error) {
+	return cache.CheckVerifyCodeCache(r.rdb, scene, target, code, maxAttempts)
+}
+
+func (r *verifyCodeRepository) IncrSendCount(ctx context.Context, target string, window time.Duration) (int64, error) {
+	return cache.IncrRateLimitCache(r.rdb, "verify_code_target", target, window)
+}
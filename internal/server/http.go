@@ -1,14 +1,19 @@
 package server
 
 import (
+	nethttp "net/http"
+
 	"github.com/gin-gonic/gin"
 	apiV1 "github.com/ljinf/im_server_standalone/api/v1"
 	"github.com/ljinf/im_server_standalone/docs"
 	"github.com/ljinf/im_server_standalone/internal/handler"
 	"github.com/ljinf/im_server_standalone/internal/middleware"
+	"github.com/ljinf/im_server_standalone/internal/service"
 	"github.com/ljinf/im_server_standalone/pkg/jwt"
 	"github.com/ljinf/im_server_standalone/pkg/log"
+	"github.com/ljinf/im_server_standalone/pkg/rbac"
 	"github.com/ljinf/im_server_standalone/pkg/server/http"
+	"github.com/redis/go-redis/v9"
 	"github.com/spf13/viper"
 	swaggerfiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -18,14 +23,30 @@ func NewHTTPServer(
 	logger *log.Logger,
 	conf *viper.Viper,
 	jwt *jwt.JWT,
+	rdb *redis.Client,
+	sessionSrv service.SessionService,
+	userSrv service.UserService,
 	userHandler *handler.UserHandler,
 	wsHandler handler.WebSocketHandler,
 	relationHandler *handler.RelationshipHandler,
 	chatHandler *handler.ChatHandler,
+	accountHandler *handler.AccountHandler,
+	reportHandler *handler.ReportHandler,
+	adminSrv service.AdminService,
+	adminHandler *handler.AdminHandler,
+	roles *rbac.RBAC,
+	origins middleware.AllowedOrigins,
 ) *http.Server {
 	gin.SetMode(gin.DebugMode)
+	engine := gin.New()
+	engine.Use(middleware.AccessLog(), gin.Recovery())
+	// 只信任这些反向代理传来的X-Forwarded-For，否则ClientIP可被客户端伪造，
+	// 登录失败计数、ws连接数限制和管理后台IP白名单都依赖ClientIP
+	if err := engine.SetTrustedProxies(conf.GetStringSlice("http.trusted_proxies")); err != nil {
+		panic(err)
+	}
 	s := http.NewServer(
-		gin.Default(),
+		engine,
 		logger,
 		http.WithServerHost(conf.GetString("http.host")),
 		http.WithServerPort(conf.GetInt("http.port")),
@@ -41,7 +62,7 @@ func NewHTTPServer(
 	))
 
 	s.Use(
-		middleware.CORSMiddleware(),
+		middleware.CORSMiddleware(origins),
 		//middleware.ResponseLogMiddleware(logger),
 		//middleware.RequestLogMiddleware(logger),
 		//middleware.SignMiddleware(log),
@@ -53,7 +74,27 @@ func NewHTTPServer(
 		})
 	})
 
-	s.GET("/ws", middleware.StrictAuth(jwt, logger), wsHandler.AcceptConn)
+	// 供其他服务校验access token
+	s.GET("/.well-known/jwks.json", func(ctx *gin.Context) {
+		ctx.Header("Cache-Control", "public, max-age=300")
+		ctx.JSON(nethttp.StatusOK, jwt.JWKS())
+	})
+
+	// 浏览器不能设置header，token可放在参数或子协议中，也可以连接后首帧认证
+	// 升级频率按用户限制，首帧认证的连接按IP
+	s.GET("/ws",
+		middleware.GlobalRateLimit(rdb, logger, "ws_upgrade_global",
+			conf.GetInt64("ws_server.limits.global_upgrade.limit"), conf.GetDuration("ws_server.limits.global_upgrade.window")),
+		middleware.WsAuth(jwt, sessionSrv, logger, conf.GetBool("ws_server.auth.first_frame")),
+		middleware.RateLimit(rdb, logger, "ws_upgrade",
+			conf.GetInt64("ws_server.limits.upgrade.limit"), conf.GetDuration("ws_server.limits.upgrade.window")),
+		wsHandler.AcceptConn)
+
+	// 开启后未验证邮箱或手机号的账号不能添加好友
+	requireVerified := func(ctx *gin.Context) { ctx.Next() }
+	if conf.GetBool("account.require_verified") {
+		requireVerified = middleware.RequireVerified(userSrv, logger)
+	}
 
 	v1 := s.Group("/v1")
 	{
@@ -62,23 +103,54 @@ func NewHTTPServer(
 		{
 			noAuthRouter.POST("/register", userHandler.Register)
 			noAuthRouter.POST("/login", userHandler.Login)
+			noAuthRouter.POST("/token/refresh", userHandler.RefreshToken)
+			noAuthRouter.POST("/verify/code", middleware.RateLimit(rdb, logger, "verify_code",
+				conf.GetInt64("rate_limit.verify_code.limit"), conf.GetDuration("rate_limit.verify_code.window")),
+				userHandler.SendCode)
+			noAuthRouter.POST("/register/phone", userHandler.RegisterByPhone)
+			noAuthRouter.POST("/login/phone", userHandler.LoginByPhone)
+			noAuthRouter.POST("/email/verify", userHandler.VerifyEmail)
+			noAuthRouter.POST("/password/forgot", middleware.RateLimit(rdb, logger, "password_forgot",
+				conf.GetInt64("rate_limit.password_forgot.limit"), conf.GetDuration("rate_limit.password_forgot.window")),
+				userHandler.ForgotPassword)
+			noAuthRouter.POST("/password/reset", userHandler.ResetPassword)
 		}
 		// Non-strict permission routing group
-		noStrictAuthRouter := v1.Group("/").Use(middleware.NoStrictAuth(jwt, logger))
+		noStrictAuthRouter := v1.Group("/").Use(middleware.NoStrictAuth(jwt, sessionSrv, logger))
 		{
 			noStrictAuthRouter.GET("/user", userHandler.GetProfile)
 		}
 
 		// Strict permission routing group
-		strictAuthRouter := v1.Group("/").Use(middleware.StrictAuth(jwt, logger))
+		strictAuthRouter := v1.Group("/").Use(middleware.StrictAuth(jwt, sessionSrv, logger))
 		{
+			strictAuthRouter.POST("/logout", userHandler.Logout)
+			strictAuthRouter.GET("/session/list", userHandler.GetSessionList)
+			strictAuthRouter.DELETE("/session", userHandler.RevokeSession)
 			strictAuthRouter.PUT("/user", userHandler.UpdateProfile)
+			strictAuthRouter.POST("/verify/code/account", middleware.RateLimit(rdb, logger, "verify_code",
+				conf.GetInt64("rate_limit.verify_code.limit"), conf.GetDuration("rate_limit.verify_code.window")),
+				userHandler.SendAccountCode)
+			strictAuthRouter.PUT("/user/account", userHandler.UpdateRegisterInfo)
+			strictAuthRouter.DELETE("/user/account", accountHandler.DeleteAccount)
+			strictAuthRouter.GET("/user/export", middleware.RateLimit(rdb, logger, "user_export",
+				conf.GetInt64("rate_limit.user_export.limit"), conf.GetDuration("rate_limit.user_export.window")),
+				accountHandler.ExportData)
+			strictAuthRouter.POST("/email/verify/send", middleware.RateLimit(rdb, logger, "verify_email",
+				conf.GetInt64("rate_limit.verify_email.limit"), conf.GetDuration("rate_limit.verify_email.window")),
+				userHandler.SendVerifyEmail)
+			strictAuthRouter.GET("/user/:userId", userHandler.GetUserProfile)
+			strictAuthRouter.GET("/user/search", middleware.RateLimit(rdb, logger, "user_search",
+				conf.GetInt64("rate_limit.user_search.limit"), conf.GetDuration("rate_limit.user_search.window")),
+				userHandler.SearchUser)
+			strictAuthRouter.GET("/user/privacy", userHandler.GetPrivacy)
+			strictAuthRouter.PUT("/user/privacy", userHandler.UpdatePrivacy)
 		}
 
-		relationGroup := v1.Group("/relationship").Use(middleware.StrictAuth(jwt, logger))
+		relationGroup := v1.Group("/relationship").Use(middleware.StrictAuth(jwt, sessionSrv, logger))
 		{
 			//好友关系申请
-			relationGroup.POST("/apply/add", relationHandler.AddApplyFriendship)
+			relationGroup.POST("/apply/add", requireVerified, relationHandler.AddApplyFriendship)
 			relationGroup.GET("/apply/list", relationHandler.GetApplyFriendshipList)
 			relationGroup.PUT("/apply/edit", relationHandler.UpdateApplyFriendshipInfo)
 			relationGroup.DELETE("/apply/del", relationHandler.DelApplyFriendshipInfo)
@@ -88,15 +160,74 @@ func NewHTTPServer(
 			relationGroup.PUT("/relation/edit", relationHandler.UpdateRelationship)
 			relationGroup.DELETE("/relation/del", relationHandler.DelRelationship)
 			relationGroup.POST("/relation/add/follow", relationHandler.AddRelationshipFollow) // 添加关注
+			relationGroup.DELETE("/follow/del", relationHandler.DelRelationshipFollow)        // 取消关注
+			relationGroup.GET("/follow/following", relationHandler.GetFollowingList)          // 关注列表
+			relationGroup.GET("/follow/followers", relationHandler.GetFollowerList)           // 粉丝列表
+			relationGroup.GET("/follow/count", relationHandler.GetFollowCount)                // 关注数和粉丝数
+			relationGroup.PUT("/relation/tags", relationHandler.SetRelationshipTags)          // 设置好友所属分组
+			relationGroup.GET("/contact/sync", relationHandler.SyncContacts)                  // 通讯录增量同步
+
+			//分组标签
+			relationGroup.POST("/tag/add", relationHandler.AddTag)
+			relationGroup.PUT("/tag/edit", relationHandler.UpdateTag)
+			relationGroup.DELETE("/tag/del", relationHandler.DelTag)
+			relationGroup.GET("/tag/list", relationHandler.GetTagList)
+
+			//黑名单
+			relationGroup.POST("/block/add", relationHandler.AddBlock)
+			relationGroup.DELETE("/block/del", relationHandler.DelBlock)
+			relationGroup.GET("/block/list", relationHandler.GetBlockList)
 		}
 
-		chatGroup := v1.Group("/chat").Use(middleware.StrictAuth(jwt, logger))
+		chatGroup := v1.Group("/chat").Use(middleware.StrictAuth(jwt, sessionSrv, logger))
 		{
 			chatGroup.POST("/send", chatHandler.SendChatMessage)
 			chatGroup.POST("/conversation/list", chatHandler.GetUserConversationList)
+			chatGroup.GET("/conversation/with/:userId", chatHandler.GetC2CConversation)
 			chatGroup.POST("/msg/history/list", chatHandler.GetUserMsgList)
 			chatGroup.POST("/report/msg/read", chatHandler.ReportReadMsgSeq)
+
+			//陌生人消息请求
+			chatGroup.POST("/request/list", chatHandler.GetMsgRequestList)
+			chatGroup.PUT("/request/accept", chatHandler.AcceptMsgRequest)
+			chatGroup.PUT("/request/ignore", chatHandler.IgnoreMsgRequest)
 		}
+
+		reportGroup := v1.Group("/report").Use(middleware.StrictAuth(jwt, sessionSrv, logger))
+		{
+			reportGroup.POST("", middleware.RateLimit(rdb, logger, "report",
+				conf.GetInt64("rate_limit.report.limit"), conf.GetDuration("rate_limit.report.window")),
+				reportHandler.CreateReport)
+			reportGroup.GET("/list", reportHandler.GetUserReportList)
+		}
+
+		v1.GET("/announcement/list", middleware.StrictAuth(jwt, sessionSrv, logger), adminHandler.GetAnnouncementList)
+	}
+
+	// 管理后台，只允许管理员从admin.allowed_ips访问，每个接口按角色校验权限
+	admin := s.Group("/admin").Use(middleware.StrictAuth(jwt, sessionSrv, logger), middleware.AdminAuth(adminSrv, conf, logger))
+	{
+		admin.GET("/me", adminHandler.GetAdminInfo)
+
+		admin.GET("/user", middleware.RequirePermission(roles, rbac.PermUserView), adminHandler.GetUser)
+		admin.POST("/user/ban", middleware.RequirePermission(roles, rbac.PermUserBan), adminHandler.BanUser)
+		admin.POST("/user/unban", middleware.RequirePermission(roles, rbac.PermUserBan), adminHandler.UnbanUser)
+		admin.POST("/user/logout", middleware.RequirePermission(roles, rbac.PermUserLogout), adminHandler.ForceLogout)
+
+		admin.GET("/group/list", middleware.RequirePermission(roles, rbac.PermGroupView), adminHandler.GetGroupList)
+		admin.GET("/group/:conversationId", middleware.RequirePermission(roles, rbac.PermGroupView), adminHandler.GetGroup)
+		admin.DELETE("/group/:conversationId", middleware.RequirePermission(roles, rbac.PermGroupDissolve), adminHandler.DissolveGroup)
+
+		admin.POST("/announcement", middleware.RequirePermission(roles, rbac.PermAnnounce), adminHandler.Announce)
+		admin.GET("/conversation/:conversationId/msg", middleware.RequirePermission(roles, rbac.PermMsgView), adminHandler.GetConversationMsgList)
+		admin.GET("/stats", middleware.RequirePermission(roles, rbac.PermStatsView), adminHandler.GetStats)
+
+		admin.GET("/report/list", middleware.RequirePermission(roles, rbac.PermReportView), reportHandler.GetReportList)
+		admin.POST("/report/resolve", middleware.RequirePermission(roles, rbac.PermReportHandle), reportHandler.ResolveReport)
+
+		admin.GET("/admins", middleware.RequirePermission(roles, rbac.PermAdminManage), adminHandler.GetAdminList)
+		admin.PUT("/admins", middleware.RequirePermission(roles, rbac.PermAdminManage), adminHandler.SaveAdmin)
+		admin.DELETE("/admins/:userId", middleware.RequirePermission(roles, rbac.PermAdminManage), adminHandler.DelAdmin)
 	}
 
 	return s
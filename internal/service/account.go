@@ -0,0 +1,266 @@
+package service
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	v1 "github.com/ljinf/im_server_standalone/api/v1"
+	"github.com/ljinf/im_server_standalone/internal/repository"
+	"github.com/ljinf/im_server_standalone/pkg/contants"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+)
+
+// 导出时每次从数据库读取的数量
+const exportBatchSize = 500
+
+// 账号注销和数据导出
+type AccountService interface {
+	DeleteAccount(ctx context.Context, userId int64, req *v1.DeleteAccountRequest) error
+	// 导出资料、通讯录和聊天记录，写入w的zip，每个文件为json
+	ExportData(ctx context.Context, userId int64, w io.Writer) error
+}
+
+type accountService struct {
+	*Service
+	userRepo     repository.UserRepository
+	relationRepo repository.RelationshipRepository
+	chatRepo     repository.ChatRepository
+	sessionSrv   SessionService
+	verifySrv    VerifyCodeService
+}
+
+func NewAccountService(s *Service, userRepo repository.UserRepository, relationRepo repository.RelationshipRepository,
+	chatRepo repository.ChatRepository, sessionSrv SessionService, verifySrv VerifyCodeService) AccountService {
+	return &accountService{
+		Service:      s,
+		userRepo:     userRepo,
+		relationRepo: relationRepo,
+		chatRepo:     chatRepo,
+		sessionSrv:   sessionSrv,
+		verifySrv:    verifySrv,
+	}
+}
+
+// 需要校验当前密码，未设置过密码的校验已绑定手机号的验证码，注销后所有设备下线，发出的消息保留，发送者显示为已注销用户
+func (s *accountService) DeleteAccount(ctx context.Context, userId int64, req *v1.DeleteAccountRequest) error {
+	register, err := s.userRepo.GetRegisterByID(ctx, userId)
+	if err != nil {
+		if errors.Is(err, v1.ErrNotFound) {
+			return v1.ErrUnauthorized
+		}
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return v1.ErrInternalServerError
+	}
+	if register.Password != "" {
+		if err = bcrypt.CompareHashAndPassword([]byte(register.Password), []byte(req.Password)); err != nil {
+			return v1.ErrPasswordFailed
+		}
+	} else if err = checkAccountCode(ctx, s.verifySrv, register, contants.VerifySceneDeleteAccount, req.Code); err != nil {
+		return err
+	}
+
+	if err = s.tm.Transaction(ctx, func(ctx context.Context) error {
+		if err := s.relationRepo.DelUserRelationships(ctx, userId); err != nil {
+			return err
+		}
+		if err := s.chatRepo.DelUserConversations(ctx, userId); err != nil {
+			return err
+		}
+		return s.userRepo.DeleteAccount(ctx, userId)
+	}); err != nil {
+		s.logger.Error(err.Error(), zap.Any("DeleteAccount", userId))
+		return v1.ErrInternalServerError
+	}
+	s.logger.WithContext(ctx).Info("account deleted", zap.Int64("userId", userId))
+
+	return s.sessionSrv.RevokeAllSessions(ctx, userId, "")
+}
+
+func (s *accountService) ExportData(ctx context.Context, userId int64, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	if err := s.exportProfile(ctx, zw, userId); err != nil {
+		return err
+	}
+	if err := s.exportContacts(ctx, zw, userId); err != nil {
+		return err
+	}
+	if err := s.exportMessages(ctx, zw, userId); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func (s *accountService) exportProfile(ctx context.Context, zw *zip.Writer, userId int64) error {
+	account, err := s.userRepo.GetAccountInfoByID(ctx, userId)
+	if err != nil {
+		return err
+	}
+	user, err := s.userRepo.GetByID(ctx, userId)
+	if err != nil {
+		return err
+	}
+	fields, err := s.userRepo.SelectProfileFields(ctx, userId)
+	if err != nil {
+		return err
+	}
+	customFields := make(map[string]v1.ProfileField, len(fields))
+	for _, v := range fields {
+		customFields[v.FieldKey] = v1.ProfileField{Value: v.Value, Visibility: v.Visibility}
+	}
+
+	return writeZipJson(zw, "profile.json", v1.ExportProfile{
+		Profile: v1.GetProfileResponseData{
+			UserId:        account.UserId,
+			Phone:         account.Phone,
+			Email:         account.Email,
+			NickName:      account.NickName,
+			Avatar:        account.Avatar,
+			Gender:        account.Gender,
+			EmailVerified: account.EmailVerified,
+			PhoneVerified: account.PhoneVerified,
+			Signature:     v1.ProfileField{Value: account.Signature, Visibility: profileVisibility(account.SignatureVisibility)},
+			Birthday:      v1.ProfileField{Value: account.Birthday, Visibility: profileVisibility(account.BirthdayVisibility)},
+			Region:        v1.ProfileField{Value: account.Region, Visibility: profileVisibility(account.RegionVisibility)},
+			CustomFields:  customFields,
+		},
+		Privacy: v1.GetPrivacyResponseData{
+			MsgAllowType: user.MsgAllowType,
+			Discoverable: user.Discoverable,
+		},
+	})
+}
+
+func (s *accountService) exportContacts(ctx context.Context, zw *zip.Writer, userId int64) error {
+	tags, err := s.relationRepo.SelectTagList(ctx, userId)
+	if err != nil {
+		return err
+	}
+	data := v1.ExportContacts{
+		Tags:     make([]v1.TagResp, 0, len(tags)),
+		Contacts: make([]v1.ContactItem, 0),
+	}
+	for _, v := range tags {
+		data.Tags = append(data.Tags, v1.TagResp{TagId: v.Id, Name: v.Name})
+	}
+
+	// 按版本号分批读取全部未删除的关系
+	var version int64
+	for {
+		list, err := s.relationRepo.SelectContactChanges(ctx, userId, version, exportBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, v := range list {
+			if !v.DeletedAt.Valid {
+				data.Contacts = append(data.Contacts, v1.ContactItem{
+					TargetId:         v.TargetId,
+					Remark:           v.Remark,
+					RelationshipType: v.RelationshipType,
+					Status:           v.Status,
+					Extra:            v.Extra,
+					Version:          v.Version,
+				})
+			}
+			version = v.Version
+		}
+		if len(list) < exportBatchSize {
+			break
+		}
+	}
+
+	targetIds := make([]int64, 0, len(data.Contacts))
+	for _, v := range data.Contacts {
+		targetIds = append(targetIds, v.TargetId)
+	}
+	if len(targetIds) > 0 {
+		tagMap, err := s.relationRepo.SelectRelationshipTags(ctx, userId, targetIds...)
+		if err != nil {
+			return err
+		}
+		for i := range data.Contacts {
+			data.Contacts[i].TagIds = tagMap[data.Contacts[i].TargetId]
+		}
+	}
+	return writeZipJson(zw, "contacts.json", data)
+}
+
+// 每个会话一个文件，边读边写，不在内存中保存全部消息。
+// 与历史消息接口一致，屏蔽的消息只导出自己发送的内容，群聊只导出加入之后的消息
+func (s *accountService) exportMessages(ctx context.Context, zw *zip.Writer, userId int64) error {
+	convIds, err := s.chatRepo.SelectUserConversationIds(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	for _, convId := range convIds {
+		userConv, err := s.chatRepo.SelectUserConversation(ctx, userId, convId)
+		if err != nil {
+			return err
+		}
+
+		f, err := zw.Create(fmt.Sprintf("messages/%v.json", convId))
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(f, "["); err != nil {
+			return err
+		}
+
+		var seq int64
+		first := true
+		for {
+			list, err := s.chatRepo.SelectConversationMsgAfter(ctx, convId, seq, exportBatchSize)
+			if err != nil {
+				return err
+			}
+			for _, v := range list {
+				seq = v.Seq
+				if v.CreatedAt < userConv.CreatedAt {
+					continue
+				}
+				data, err := json.Marshal(v1.SendMsgResp{
+					UserId:         v.UserId,
+					MsgId:          v.MsgId,
+					ConversationId: v.ConversationId,
+					Content:        visibleContent(&v, userId),
+					ContentType:    v.ContentType,
+					Status:         v.Status,
+					Seq:            v.Seq,
+					SendTime:       v.SendTime,
+				})
+				if err != nil {
+					return err
+				}
+				if !first {
+					data = append([]byte(","), data...)
+				}
+				if _, err = f.Write(data); err != nil {
+					return err
+				}
+				first = false
+			}
+			if len(list) < exportBatchSize {
+				break
+			}
+		}
+
+		if _, err = io.WriteString(f, "]"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeZipJson(zw *zip.Writer, name string, v interface{}) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
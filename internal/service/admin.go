@@ -0,0 +1,535 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	v1 "github.com/ljinf/im_server_standalone/api/v1"
+	"github.com/ljinf/im_server_standalone/internal/model"
+	"github.com/ljinf/im_server_standalone/internal/repository"
+	"github.com/ljinf/im_server_standalone/internal/ws"
+	"github.com/ljinf/im_server_standalone/pkg/contants"
+	"github.com/ljinf/im_server_standalone/pkg/rbac"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"time"
+)
+
+// 统计最多查询的分钟数，与消息计数的缓存时间一致
+const maxStatsMinutes = 120
+
+// AdminOperator 执行操作的管理员，由AdminAuth中间件确定角色
+type AdminOperator struct {
+	UserId int64
+	Role   string
+	Ip     string
+}
+
+// 管理后台：用户封禁和下线、群组管理、系统公告、合规审查和实时统计。
+// 会改变数据或查看消息的操作都记录操作日志
+type AdminService interface {
+	// 用户的管理员角色，不是管理员返回空。admin.user_ids中的用户为超级管理员
+	GetAdminRole(ctx context.Context, userId int64) (string, error)
+	GetAdminInfo(ctx context.Context, op AdminOperator) *v1.AdminInfoResp
+
+	// 用户
+	GetUser(ctx context.Context, query *v1.AdminUserQuery) (*v1.AdminUserResp, error)
+	// 封禁后所有会话下线，不能再登录
+	BanUser(ctx context.Context, op AdminOperator, req *v1.AdminUserReq) error
+	UnbanUser(ctx context.Context, op AdminOperator, req *v1.AdminUserReq) error
+	ForceLogout(ctx context.Context, op AdminOperator, req *v1.AdminUserReq) error
+	// 其他模块对用户执行管理操作前校验，如处理举报时禁言和封禁，规则与封禁接口一致
+	CheckUserOp(ctx context.Context, op AdminOperator, perm string, userId int64) error
+	// 记录操作日志
+	OpLog(ctx context.Context, op AdminOperator, action string, targetId int64, detail map[string]interface{})
+
+	// 群组
+	GetGroupList(ctx context.Context, pageNum, pageSize int) (*v1.AdminGroupListResp, error)
+	GetGroup(ctx context.Context, conversationId int64) (*v1.AdminGroupResp, error)
+	// 移除所有成员并通知，消息保留
+	DissolveGroup(ctx context.Context, op AdminOperator, conversationId int64) error
+
+	// 系统公告，保存后推送给所有在线用户
+	Announce(ctx context.Context, op AdminOperator, req *v1.AnnouncementReq) (*v1.AnnouncementResp, error)
+	GetAnnouncementList(ctx context.Context, pageNum, pageSize int) ([]v1.AnnouncementResp, error)
+
+	// 合规审查，包含已屏蔽和撤回的消息
+	GetConversationMsgList(ctx context.Context, op AdminOperator, conversationId int64, req *v1.AdminMsgListReq) ([]v1.SendMsgResp, error)
+	GetStats(ctx context.Context, minutes int) (*v1.AdminStatsResp, error)
+
+	// 管理员和角色
+	GetAdminList(ctx context.Context) ([]v1.AdminResp, error)
+	SaveAdmin(ctx context.Context, op AdminOperator, req *v1.SaveAdminReq) error
+	DelAdmin(ctx context.Context, op AdminOperator, userId int64) error
+}
+
+type adminService struct {
+	*Service
+	repo        repository.AdminRepository
+	userRepo    repository.UserRepository
+	chatRepo    repository.ChatRepository
+	sessionSrv  SessionService
+	wss         ws.SocketWsServer
+	rbac        *rbac.RBAC
+	superAdmins map[int64]struct{}
+}
+
+func NewAdminService(s *Service, conf *viper.Viper, repo repository.AdminRepository, userRepo repository.UserRepository,
+	chatRepo repository.ChatRepository, sessionSrv SessionService, wss ws.SocketWsServer, r *rbac.RBAC) AdminService {
+	superAdmins := make(map[int64]struct{})
+	for _, v := range conf.GetIntSlice("admin.user_ids") {
+		superAdmins[int64(v)] = struct{}{}
+	}
+	return &adminService{
+		Service:     s,
+		repo:        repo,
+		userRepo:    userRepo,
+		chatRepo:    chatRepo,
+		sessionSrv:  sessionSrv,
+		wss:         wss,
+		rbac:        r,
+		superAdmins: superAdmins,
+	}
+}
+
+// 角色已从配置中删除时按非管理员处理
+func (s *adminService) GetAdminRole(ctx context.Context, userId int64) (string, error) {
+	if _, ok := s.superAdmins[userId]; ok {
+		return rbac.RoleSuper, nil
+	}
+	admin, err := s.repo.GetAdminUser(ctx, userId)
+	if err != nil {
+		return "", err
+	}
+	if admin == nil || !s.rbac.HasRole(admin.Role) {
+		return "", nil
+	}
+	return admin.Role, nil
+}
+
+func (s *adminService) GetAdminInfo(ctx context.Context, op AdminOperator) *v1.AdminInfoResp {
+	return &v1.AdminInfoResp{
+		UserId:      op.UserId,
+		Role:        op.Role,
+		Permissions: s.rbac.Permissions(op.Role),
+	}
+}
+
+func (s *adminService) GetUser(ctx context.Context, query *v1.AdminUserQuery) (*v1.AdminUserResp, error) {
+	userId := query.UserId
+	if userId == 0 {
+		var (
+			register *model.Register
+			err      error
+		)
+		switch {
+		case query.Email != "":
+			register, err = s.userRepo.GetByEmail(ctx, query.Email)
+		case query.Phone != "":
+			register, err = s.userRepo.GetByPhone(ctx, query.Phone)
+		default:
+			return nil, v1.ErrBadRequest
+		}
+		if err != nil {
+			s.logger.Error(err.Error(), zap.Any("query", query))
+			return nil, v1.ErrInternalServerError
+		}
+		if register == nil {
+			return nil, v1.ErrNotFound
+		}
+		userId = register.UserId
+	}
+
+	info, err := s.userRepo.GetAccountInfoByID(ctx, userId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return nil, v1.ErrInternalServerError
+	}
+	if info.UserId == 0 {
+		return nil, v1.ErrNotFound
+	}
+	sessions, err := s.sessionSrv.GetSessionList(ctx, userId, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.AdminUserResp{
+		UserId:        info.UserId,
+		Phone:         info.Phone,
+		Email:         info.Email,
+		NickName:      info.NickName,
+		Avatar:        info.Avatar,
+		Status:        info.Status,
+		SilentFlag:    info.SilentFlag,
+		SilentUntil:   info.SilentUntil,
+		EmailVerified: info.EmailVerified,
+		PhoneVerified: info.PhoneVerified,
+		RegisteredAt:  info.RegisteredAt.Unix(),
+		Online:        s.wss.IsOnline(userId),
+		Sessions:      sessions,
+	}, nil
+}
+
+func (s *adminService) BanUser(ctx context.Context, op AdminOperator, req *v1.AdminUserReq) error {
+	if err := s.checkTargetUser(ctx, op, req.UserId); err != nil {
+		return err
+	}
+	if err := s.userRepo.UpdateUserInfoColumns(ctx, req.UserId, map[string]interface{}{
+		"status": contants.UserStatusAbnormal,
+	}); err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", req.UserId))
+		return v1.ErrInternalServerError
+	}
+	s.OpLog(ctx, op, rbac.PermUserBan, req.UserId, map[string]interface{}{"ban": true, "reason": req.Reason})
+	return s.sessionSrv.RevokeAllSessions(ctx, req.UserId, "")
+}
+
+func (s *adminService) UnbanUser(ctx context.Context, op AdminOperator, req *v1.AdminUserReq) error {
+	if err := s.checkTargetUser(ctx, op, req.UserId); err != nil {
+		return err
+	}
+	if err := s.userRepo.UpdateUserInfoColumns(ctx, req.UserId, map[string]interface{}{
+		"status": contants.UserStatusNormal,
+	}); err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", req.UserId))
+		return v1.ErrInternalServerError
+	}
+	s.OpLog(ctx, op, rbac.PermUserBan, req.UserId, map[string]interface{}{"ban": false, "reason": req.Reason})
+	return nil
+}
+
+func (s *adminService) ForceLogout(ctx context.Context, op AdminOperator, req *v1.AdminUserReq) error {
+	if err := s.checkTargetUser(ctx, op, req.UserId); err != nil {
+		return err
+	}
+	s.OpLog(ctx, op, rbac.PermUserLogout, req.UserId, map[string]interface{}{"reason": req.Reason})
+	return s.sessionSrv.RevokeAllSessions(ctx, req.UserId, "")
+}
+
+func (s *adminService) CheckUserOp(ctx context.Context, op AdminOperator, perm string, userId int64) error {
+	if !s.rbac.Can(op.Role, perm) {
+		return v1.ErrForbidden
+	}
+	return s.checkTargetUser(ctx, op, userId)
+}
+
+// 用户需存在且未注销，对管理员操作需要管理员管理权限，避免低权限角色封禁高权限角色
+func (s *adminService) checkTargetUser(ctx context.Context, op AdminOperator, userId int64) error {
+	if _, err := s.userRepo.GetByID(ctx, userId); err != nil {
+		if errors.Is(err, v1.ErrNotFound) {
+			return v1.ErrNotFound
+		}
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return v1.ErrInternalServerError
+	}
+	role, err := s.GetAdminRole(ctx, userId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return v1.ErrInternalServerError
+	}
+	if role != "" && !s.rbac.Can(op.Role, rbac.PermAdminManage) {
+		return v1.ErrForbidden
+	}
+	return nil
+}
+
+func (s *adminService) GetGroupList(ctx context.Context, pageNum, pageSize int) (*v1.AdminGroupListResp, error) {
+	list, total, err := s.chatRepo.SelectGroupList(ctx, pageNum, pageSize)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("pageNum", pageNum))
+		return nil, v1.ErrInternalServerError
+	}
+	resp := &v1.AdminGroupListResp{Total: total, Rows: make([]v1.AdminGroupResp, 0, len(list))}
+	for _, v := range list {
+		resp.Rows = append(resp.Rows, convertAdminGroup(&v))
+	}
+	return resp, nil
+}
+
+func (s *adminService) GetGroup(ctx context.Context, conversationId int64) (*v1.AdminGroupResp, error) {
+	group, err := s.getGroup(ctx, conversationId)
+	if err != nil {
+		return nil, err
+	}
+	resp := convertAdminGroup(group)
+	if group.Status == contants.ConversationStatusDissolved {
+		return &resp, nil
+	}
+
+	users, err := s.chatRepo.SelectConversationUsers(ctx, conversationId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("convId", conversationId))
+		return nil, v1.ErrInternalServerError
+	}
+	resp.Members = make([]v1.AdminGroupMember, 0, len(users))
+	for _, v := range users {
+		resp.Members = append(resp.Members, v1.AdminGroupMember{
+			UserId:   v.UserId,
+			NickName: v.NickName,
+			Avatar:   v.Avatar,
+			Status:   v.Status,
+		})
+	}
+	return &resp, nil
+}
+
+func (s *adminService) getGroup(ctx context.Context, conversationId int64) (*model.ConversationList, error) {
+	list, err := s.chatRepo.SelectConversation(ctx, conversationId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("convId", conversationId))
+		return nil, v1.ErrInternalServerError
+	}
+	if len(list) < 1 || list[0].Type != contants.ConversationTypeGroup {
+		return nil, v1.ErrNotFound
+	}
+	return &list[0], nil
+}
+
+func (s *adminService) DissolveGroup(ctx context.Context, op AdminOperator, conversationId int64) error {
+	group, err := s.getGroup(ctx, conversationId)
+	if err != nil {
+		return err
+	}
+	if group.Status == contants.ConversationStatusDissolved {
+		return nil
+	}
+
+	userIds, err := s.chatRepo.DissolveConversation(ctx, conversationId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("convId", conversationId))
+		return v1.ErrInternalServerError
+	}
+	s.OpLog(ctx, op, rbac.PermGroupDissolve, conversationId, map[string]interface{}{"members": len(userIds)})
+
+	data, _ := json.Marshal(map[string]int64{"conversation_id": conversationId})
+	if err = s.wss.Push(ws.NotifyFrame(contants.NotifyEventGroupDissolve, string(data)), userIds...); err != nil {
+		s.logger.Error(err.Error(), zap.Any("convId", conversationId))
+	}
+	return nil
+}
+
+func (s *adminService) Announce(ctx context.Context, op AdminOperator, req *v1.AnnouncementReq) (*v1.AnnouncementResp, error) {
+	announcement := &model.Announcement{
+		AdminId:   op.UserId,
+		Title:     req.Title,
+		Content:   req.Content,
+		CreatedAt: time.Now().Unix(),
+	}
+	if err := s.repo.CreateAnnouncement(ctx, announcement); err != nil {
+		s.logger.Error(err.Error(), zap.Any("announcement", announcement))
+		return nil, v1.ErrInternalServerError
+	}
+	s.OpLog(ctx, op, rbac.PermAnnounce, announcement.Id, map[string]interface{}{"title": req.Title})
+
+	resp := convertAnnouncement(announcement)
+	data, err := json.Marshal(resp)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("announcementId", announcement.Id))
+		return &resp, nil
+	}
+	// 不在线的用户通过公告列表查看
+	if err = s.wss.Broadcast(ws.NotifyFrame(contants.NotifyEventAnnouncement, string(data))); err != nil {
+		s.logger.Error(err.Error(), zap.Any("announcementId", announcement.Id))
+	}
+	return &resp, nil
+}
+
+func (s *adminService) GetAnnouncementList(ctx context.Context, pageNum, pageSize int) ([]v1.AnnouncementResp, error) {
+	list, err := s.repo.SelectAnnouncementList(ctx, pageNum, pageSize)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("pageNum", pageNum))
+		return nil, v1.ErrInternalServerError
+	}
+	resp := make([]v1.AnnouncementResp, 0, len(list))
+	for _, v := range list {
+		resp = append(resp, convertAnnouncement(&v))
+	}
+	return resp, nil
+}
+
+func (s *adminService) GetConversationMsgList(ctx context.Context, op AdminOperator, conversationId int64, req *v1.AdminMsgListReq) ([]v1.SendMsgResp, error) {
+	list, err := s.chatRepo.SelectConversation(ctx, conversationId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("convId", conversationId))
+		return nil, v1.ErrInternalServerError
+	}
+	if len(list) < 1 {
+		return nil, v1.ErrNotFound
+	}
+
+	msgs, err := s.chatRepo.SelectConversationMsgAfter(ctx, conversationId, req.Seq, req.Limit)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("convId", conversationId))
+		return nil, v1.ErrInternalServerError
+	}
+	s.OpLog(ctx, op, rbac.PermMsgView, conversationId, map[string]interface{}{"seq": req.Seq, "limit": req.Limit})
+
+	resp := make([]v1.SendMsgResp, 0, len(msgs))
+	for _, v := range msgs {
+		resp = append(resp, v1.SendMsgResp{
+			UserId:         v.UserId,
+			MsgId:          v.MsgId,
+			ConversationId: v.ConversationId,
+			Content:        v.Content,
+			ContentType:    v.ContentType,
+			Status:         v.Status,
+			Seq:            v.Seq,
+			SendTime:       v.SendTime,
+			CreatedAt:      v.CreatedAt,
+		})
+	}
+	return resp, nil
+}
+
+func (s *adminService) GetStats(ctx context.Context, minutes int) (*v1.AdminStatsResp, error) {
+	if minutes <= 0 {
+		minutes = 30
+	}
+	if minutes > maxStatsMinutes {
+		minutes = maxStatsMinutes
+	}
+
+	buckets := s.wss.GetConnManager().BucketCounts()
+	total := 0
+	for _, v := range buckets {
+		total += v
+	}
+	stats := s.wss.Stats()
+	resp := &v1.AdminStatsResp{
+		NodeId:          s.wss.NodeId(),
+		Connections:     total,
+		Buckets:         buckets,
+		DroppedFrames:   stats.DroppedFrames,
+		SlowDisconnects: stats.SlowDisconnects,
+		MsgPerMinute:    make([]v1.MsgMinuteCount, 0, minutes),
+	}
+
+	// 包含当前分钟
+	from := time.Now().Truncate(time.Minute).Add(-time.Duration(minutes-1) * time.Minute)
+	counts, err := s.chatRepo.SelectMsgMinuteCounts(ctx, from, minutes)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Int("minutes", minutes))
+		return resp, nil
+	}
+	for i, v := range counts {
+		resp.MsgPerMinute = append(resp.MsgPerMinute, v1.MsgMinuteCount{
+			Minute: from.Add(time.Duration(i) * time.Minute).Unix(),
+			Count:  v,
+		})
+	}
+	return resp, nil
+}
+
+// 包含配置中的超级管理员
+func (s *adminService) GetAdminList(ctx context.Context) ([]v1.AdminResp, error) {
+	list, err := s.repo.SelectAdminUsers(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, v1.ErrInternalServerError
+	}
+	resp := make([]v1.AdminResp, 0, len(list)+len(s.superAdmins))
+	for userId := range s.superAdmins {
+		resp = append(resp, v1.AdminResp{UserId: userId, Role: rbac.RoleSuper})
+	}
+	for _, v := range list {
+		if _, ok := s.superAdmins[v.UserId]; ok {
+			continue
+		}
+		resp = append(resp, v1.AdminResp{
+			UserId:    v.UserId,
+			Role:      v.Role,
+			CreatedBy: v.CreatedBy,
+			CreatedAt: v.CreatedAt,
+			UpdatedAt: v.UpdatedAt,
+		})
+	}
+	return resp, nil
+}
+
+// 配置中的超级管理员和自己的角色不能修改
+func (s *adminService) SaveAdmin(ctx context.Context, op AdminOperator, req *v1.SaveAdminReq) error {
+	if !s.rbac.HasRole(req.Role) {
+		return v1.ErrAdminRoleInvalid
+	}
+	if req.UserId == op.UserId {
+		return v1.ErrAdminSelf
+	}
+	if _, ok := s.superAdmins[req.UserId]; ok {
+		return v1.ErrForbidden
+	}
+	if _, err := s.userRepo.GetByID(ctx, req.UserId); err != nil {
+		if errors.Is(err, v1.ErrNotFound) {
+			return v1.ErrNotFound
+		}
+		s.logger.Error(err.Error(), zap.Any("userId", req.UserId))
+		return v1.ErrInternalServerError
+	}
+
+	now := time.Now().Unix()
+	if err := s.repo.SaveAdminUser(ctx, &model.AdminUser{
+		UserId:    req.UserId,
+		Role:      req.Role,
+		CreatedBy: op.UserId,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}); err != nil {
+		s.logger.Error(err.Error(), zap.Any("req", req))
+		return v1.ErrInternalServerError
+	}
+	s.OpLog(ctx, op, rbac.PermAdminManage, req.UserId, map[string]interface{}{"role": req.Role})
+	return nil
+}
+
+func (s *adminService) DelAdmin(ctx context.Context, op AdminOperator, userId int64) error {
+	if userId == op.UserId {
+		return v1.ErrAdminSelf
+	}
+	if _, ok := s.superAdmins[userId]; ok {
+		return v1.ErrForbidden
+	}
+	if err := s.repo.DelAdminUser(ctx, userId); err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return v1.ErrInternalServerError
+	}
+	s.OpLog(ctx, op, rbac.PermAdminManage, userId, map[string]interface{}{"role": ""})
+	return nil
+}
+
+// 操作日志写入失败不影响操作
+func (s *adminService) OpLog(ctx context.Context, op AdminOperator, action string, targetId int64, detail map[string]interface{}) {
+	data, _ := json.Marshal(detail)
+	log := &model.AdminOpLog{
+		AdminId:   op.UserId,
+		Action:    action,
+		TargetId:  targetId,
+		Detail:    string(data),
+		Ip:        op.Ip,
+		CreatedAt: time.Now().Unix(),
+	}
+	if err := s.repo.CreateOpLog(ctx, log); err != nil {
+		s.logger.Error(err.Error(), zap.Any("opLog", log))
+	}
+	s.logger.WithContext(ctx).Info("admin op", zap.Int64("adminId", op.UserId), zap.String("action", action),
+		zap.Int64("targetId", targetId), zap.String("detail", log.Detail))
+}
+
+func convertAdminGroup(c *model.ConversationList) v1.AdminGroupResp {
+	return v1.AdminGroupResp{
+		ConversationId: c.ConversationId,
+		Member:         c.Member,
+		Avatar:         c.Avatar,
+		Announcement:   c.Announcement,
+		RecentMsgTime:  c.RecentMsgTime,
+		Status:         c.Status,
+		CreatedAt:      c.CreatedAt,
+	}
+}
+
+func convertAnnouncement(a *model.Announcement) v1.AnnouncementResp {
+	return v1.AnnouncementResp{
+		Id:        a.Id,
+		Title:     a.Title,
+		Content:   a.Content,
+		CreatedAt: a.CreatedAt,
+	}
+}
@@ -2,16 +2,21 @@ package service
 
 import (
 	"context"
+	"errors"
 	v1 "github.com/ljinf/im_server_standalone/api/v1"
 	"github.com/ljinf/im_server_standalone/internal/model"
 	"github.com/ljinf/im_server_standalone/internal/repository"
 	"github.com/ljinf/im_server_standalone/pkg/contants"
+	"github.com/ljinf/im_server_standalone/pkg/moderation"
+	"github.com/spf13/viper"
 	"go.uber.org/zap"
 	"time"
 )
 
 type ChatService interface {
 	CreateMsg(ctx context.Context, req *v1.SendMsgReq) (*v1.SendMsgResp, error)
+	// 服务端发送的系统消息，如成为好友后的打招呼，不受禁言、发送频率和内容审核限制，调用方负责校验会话
+	CreateSystemMsg(ctx context.Context, req *v1.SendMsgReq) (*v1.SendMsgResp, error)
 	//历史消息
 	GetMsgList(ctx context.Context, userId, conversationId, seq int64, pageNum, pageSize int) ([]v1.SendMsgResp, error)
 
@@ -20,29 +25,91 @@ type ChatService interface {
 	GetConversationUsers(ctx context.Context, conversationId int64) ([]v1.GetProfileResponseData, error) //会话下的用户
 	//创建会话
 	CreateConversationList(ctx context.Context, list ...*model.ConversationList) error
+	//创建单聊会话，已存在则返回已有会话ID
+	CreateC2CConversation(ctx context.Context, userId, targetId int64) (int64, error)
+	//与某个用户的单聊会话，不存在则创建
+	GetC2CConversation(ctx context.Context, userId, targetId int64) (*v1.ConversationResp, error)
 
 	//该会话最新一条消息
 	GetLastConversationMsg(ctx context.Context, conversationId int64) v1.SendMsgResp
 
 	//已读上报
 	ReportReadMsgSeq(ctx context.Context, req *v1.ReportReadReq) error
+
+	// 陌生人消息请求
+	GetMsgRequestList(ctx context.Context, userId, pageNum, pageSize int64) ([]v1.ConversationResp, error)
+	AcceptMsgRequest(ctx context.Context, userId, conversationId int64) error
+	IgnoreMsgRequest(ctx context.Context, userId, conversationId int64) error
 }
 
 type chatService struct {
 	*Service
-	repo repository.ChatRepository
+	repo          repository.ChatRepository
+	userRepo      repository.UserRepository
+	relationRepo  repository.RelationshipRepository
+	sendLimit     SendLimitService
+	moderationSrv ModerationService
+	msgAllowType  int //用户未设置时谁可以给他发消息
 }
 
-func NewChatService(s *Service, repo repository.ChatRepository) ChatService {
+func NewChatService(s *Service, conf *viper.Viper, repo repository.ChatRepository, userRepo repository.UserRepository,
+	relationRepo repository.RelationshipRepository, sendLimit SendLimitService, moderationSrv ModerationService) ChatService {
+	msgAllowType := conf.GetInt("chat.msg_allow_type")
+	if msgAllowType == contants.MsgAllowTypeDefault {
+		msgAllowType = contants.MsgAllowTypeAnyone
+	}
 	return &chatService{
-		Service: s,
-		repo:    repo,
+		Service:       s,
+		repo:          repo,
+		userRepo:      userRepo,
+		relationRepo:  relationRepo,
+		sendLimit:     sendLimit,
+		moderationSrv: moderationSrv,
+		msgAllowType:  msgAllowType,
 	}
 }
 
 // 返回消息ID
 func (s *chatService) CreateMsg(ctx context.Context, req *v1.SendMsgReq) (*v1.SendMsgResp, error) {
+	if err := s.checkMuted(ctx, req.UserId); err != nil {
+		return nil, err
+	}
+	c2c, err := s.checkSend(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.sendLimit.Check(ctx, req); err != nil {
+		return nil, err
+	}
+
+	// 内容审核：拒绝的不保存，屏蔽的保存但只有发送者可见
+	result := s.moderationSrv.Moderate(ctx, req)
+	if result.Action == moderation.ActionReject {
+		s.moderationSrv.Audit(ctx, 0, req, result)
+		return nil, v1.ErrMsgRejected
+	}
+
+	status := contants.MsgStatusNormal
+	if result.Action == moderation.ActionBlock {
+		status = contants.MsgStatusBlocked
+	}
+	resp, err := s.saveMsg(ctx, req, result.Content, status, c2c)
+	if err != nil {
+		return nil, err
+	}
+
+	auditReq := *req
+	auditReq.ConversationId = resp.ConversationId
+	s.moderationSrv.Audit(ctx, resp.MsgId, &auditReq, result)
+	return resp, nil
+}
+
+func (s *chatService) CreateSystemMsg(ctx context.Context, req *v1.SendMsgReq) (*v1.SendMsgResp, error) {
+	return s.saveMsg(ctx, req, req.Content, contants.MsgStatusNormal, false)
+}
 
+// 保存消息，会话不存在时创建两人的单聊会话。checkRequest为true时重新判断接收者的会话是否为消息请求
+func (s *chatService) saveMsg(ctx context.Context, req *v1.SendMsgReq, content string, status int, checkRequest bool) (*v1.SendMsgResp, error) {
 	msgId, err := s.sid.GenUint64()
 	if err != nil {
 		return nil, err
@@ -53,45 +120,34 @@ func (s *chatService) CreateMsg(ctx context.Context, req *v1.SendMsgReq) (*v1.Se
 		UserId:         req.UserId,
 		MsgId:          int64(msgId),
 		ConversationId: req.ConversationId,
-		Content:        req.Content,
+		Content:        content,
 		ContentType:    req.ContentType,
-		Status:         0,
+		Status:         status,
 		SendTime:       now,
 		CreatedAt:      now,
 	}
 
 	// 消息序列号
-	var mSeq int64
+	var (
+		mSeq          int64
+		targetRequest bool
+	)
 
 	if err = s.tm.Transaction(ctx, func(ctx context.Context) error {
 
-		// 用户会话链
-		userConversationList := make([]*model.UserConversationList, 0, 2)
-		//会话不存在
+		//会话不存在，使用两人的单聊会话(群聊要先创建群，所以会话id不为0)
 		if msg.ConversationId == 0 {
-			cId, err := s.sid.GenUint64()
+			cId, err := s.CreateC2CConversation(ctx, req.UserId, req.TargetId)
 			if err != nil {
 				return err
 			}
-			// 单聊，如果是群聊，要先创建群，所以会话id不为0
-			conversationInfo := &model.ConversationList{
-				ConversationId: int64(cId),
-				Type:           0,
-				Member:         2,
-				RecentMsgTime:  now,
-				CreatedAt:      now,
-			}
-			if err = s.repo.CreateConversation(ctx, conversationInfo); err != nil {
+			msg.ConversationId = cId
+		}
+
+		if checkRequest {
+			if targetRequest, err = s.syncMsgRequest(ctx, msg.ConversationId, req.UserId, req.TargetId); err != nil {
 				return err
 			}
-			msg.ConversationId = conversationInfo.ConversationId
-			// 接收者的会话
-			userConversationList = append(userConversationList, &model.UserConversationList{
-				UserId:         req.TargetId,
-				ConversationId: msg.ConversationId,
-				CreatedAt:      now,
-				UpdatedAt:      now,
-			})
 		}
 
 		//会话消息
@@ -105,14 +161,13 @@ func (s *chatService) CreateMsg(ctx context.Context, req *v1.SendMsgReq) (*v1.Se
 		}
 
 		// 发送者的会话链
-		userConversationList = append(userConversationList, &model.UserConversationList{
+		if err = s.repo.CreateUserConversationList(ctx, &model.UserConversationList{
 			UserId:         req.UserId,
 			ConversationId: msg.ConversationId,
 			LastReadSeq:    cMsg.Seq,
 			CreatedAt:      now,
 			UpdatedAt:      now,
-		})
-		if err = s.repo.CreateUserConversationList(ctx, userConversationList...); err != nil {
+		}); err != nil {
 			return err
 		}
 		mSeq = cMsg.Seq
@@ -124,7 +179,15 @@ func (s *chatService) CreateMsg(ctx context.Context, req *v1.SendMsgReq) (*v1.Se
 			s.repo.DecrMsgSeq(ctx, msg.ConversationId)
 		}
 		s.logger.Error(err.Error(), zap.Any("req", req))
-		return nil, err
+		if errors.Is(err, v1.ErrBadRequest) {
+			return nil, err
+		}
+		return nil, v1.ErrInternalServerError
+	}
+
+	// 管理后台的每分钟消息数
+	if err = s.repo.IncrMsgMinuteCount(ctx, time.Unix(now, 0)); err != nil {
+		s.logger.Error(err.Error(), zap.Any("IncrMsgMinuteCount", now))
 	}
 
 	resp := &v1.SendMsgResp{
@@ -137,10 +200,64 @@ func (s *chatService) CreateMsg(ctx context.Context, req *v1.SendMsgReq) (*v1.Se
 		Seq:            mSeq,
 		SendTime:       msg.SendTime,
 		CreatedAt:      now,
+		TargetRequest:  targetRequest,
 	}
 	return resp, nil
 }
 
+// 发送前校验：单聊会话必须是发送者与接收者的会话，且双方没有拉黑。返回是否为单聊
+func (s *chatService) checkSend(ctx context.Context, req *v1.SendMsgReq) (bool, error) {
+	if req.ConversationId > 0 {
+		conversationLists, err := s.repo.SelectConversation(ctx, req.ConversationId)
+		if err != nil {
+			s.logger.Error(err.Error(), zap.Any("convId", req.ConversationId))
+			return false, v1.ErrInternalServerError
+		}
+		if len(conversationLists) < 1 || conversationLists[0].Status == contants.ConversationStatusDissolved {
+			return false, v1.ErrNotFound
+		}
+		if conversationLists[0].Type != contants.ConversationTypeC2C {
+			return false, nil
+		}
+
+		// 会话必须是发送者与target_id的单聊，拉黑校验的才是会话中真实的另一方
+		cId, err := s.repo.SelectC2CConversationId(ctx, req.UserId, req.TargetId)
+		if err != nil {
+			s.logger.Error(err.Error(), zap.Any("req", req))
+			return false, v1.ErrInternalServerError
+		}
+		if cId != req.ConversationId {
+			return false, v1.ErrBadRequest
+		}
+	}
+
+	if req.TargetId == 0 || req.TargetId == req.UserId {
+		return false, v1.ErrBadRequest
+	}
+	// 已注销的用户不能再接收消息
+	if _, err := s.userRepo.GetByID(ctx, req.TargetId); err != nil {
+		if errors.Is(err, v1.ErrNotFound) {
+			return false, v1.ErrNotFound
+		}
+		s.logger.Error(err.Error(), zap.Any("targetId", req.TargetId))
+		return false, v1.ErrInternalServerError
+	}
+	return true, checkBlocked(ctx, s.Service, s.relationRepo, req.UserId, req.TargetId)
+}
+
+// 被禁言的用户不能发送消息，silent_until为0时永久禁言
+func (s *chatService) checkMuted(ctx context.Context, userId int64) error {
+	info, err := s.userRepo.GetAccountInfoByID(ctx, userId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return v1.ErrInternalServerError
+	}
+	if info.SilentFlag == 1 && (info.SilentUntil == 0 || info.SilentUntil > time.Now().Unix()) {
+		return v1.ErrUserMuted
+	}
+	return nil
+}
+
 func (s *chatService) GetMsgList(ctx context.Context, userId, conversationId, seq int64, pageNum, pageSize int) ([]v1.SendMsgResp, error) {
 	msgLists, err := s.repo.SelectConversationMsg(ctx, conversationId, seq, pageNum, pageSize)
 	if err != nil {
@@ -154,7 +271,7 @@ func (s *chatService) GetMsgList(ctx context.Context, userId, conversationId, se
 			UserId:         v.UserId,
 			MsgId:          v.MsgId,
 			ConversationId: v.ConversationId,
-			Content:        v.Content,
+			Content:        visibleContent(&v, userId),
 			ContentType:    v.ContentType,
 			Status:         v.Status,
 			Seq:            v.Seq,
@@ -164,6 +281,14 @@ func (s *chatService) GetMsgList(ctx context.Context, userId, conversationId, se
 	return resp, nil
 }
 
+// 屏蔽的消息只有发送者可见内容
+func visibleContent(msg *model.MsgResp, userId int64) string {
+	if msg.Status == contants.MsgStatusBlocked && msg.UserId != userId {
+		return ""
+	}
+	return msg.Content
+}
+
 func (s *chatService) GetUserConversationList(ctx context.Context, userId, pageNum, pageSize int64) ([]v1.ConversationResp, error) {
 	userConversationList, err := s.repo.SelectUserConversationList(ctx, userId, pageNum, pageSize)
 	if err != nil {
@@ -182,31 +307,266 @@ func (s *chatService) GetUserConversationList(ctx context.Context, userId, pageN
 
 	resp := make([]v1.ConversationResp, 0, len(userConversationList))
 	for index, v := range userConversationList {
-		conv := v1.ConversationResp{
-			ConversationId: v.ConversationId,
-			Type:           conversationLists[index].Type,
-			Avatar:         conversationLists[index].Avatar,
-			LastReadSeq:    v.LastReadSeq,
-			NotifyType:     v.NotifyType,
-			IsTop:          v.IsTop,
-			RecentMsg:      s.GetLastConversationMsg(ctx, v.ConversationId),
-		}
-		//单聊会话获取用户列表
-		if conv.Type == contants.ConversationTypeC2C {
-			conversationUsers, _ := s.GetConversationUsers(ctx, v.ConversationId)
-			conv.UserList = conversationUsers //会话用户列表
+		resp = append(resp, s.buildConversationResp(ctx, v, conversationLists[index]))
+	}
+	return resp, nil
+}
+
+func (s *chatService) GetMsgRequestList(ctx context.Context, userId, pageNum, pageSize int64) ([]v1.ConversationResp, error) {
+	userConversationList, err := s.repo.SelectUserConversationRequestList(ctx, userId, pageNum, pageSize)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return nil, v1.ErrInternalServerError
+	}
+
+	resp := make([]v1.ConversationResp, 0, len(userConversationList))
+	for _, v := range userConversationList {
+		conversationLists, err := s.repo.SelectConversation(ctx, v.ConversationId)
+		if err != nil || len(conversationLists) < 1 {
+			s.logger.Error("SelectConversation failed", zap.Any("convId", v.ConversationId), zap.Error(err))
+			continue
 		}
-		resp = append(resp, conv)
+		resp = append(resp, s.buildConversationResp(ctx, v, conversationLists[0]))
 	}
 	return resp, nil
 }
 
+// 接受消息请求，会话移入会话列表
+func (s *chatService) AcceptMsgRequest(ctx context.Context, userId, conversationId int64) error {
+	return s.updateMsgRequest(ctx, userId, conversationId, contants.MsgRequestStatusNone)
+}
+
+// 忽略消息请求
+func (s *chatService) IgnoreMsgRequest(ctx context.Context, userId, conversationId int64) error {
+	return s.updateMsgRequest(ctx, userId, conversationId, contants.MsgRequestStatusIgnored)
+}
+
+func (s *chatService) updateMsgRequest(ctx context.Context, userId, conversationId int64, status int) error {
+	userConv, err := s.repo.SelectUserConversation(ctx, userId, conversationId)
+	if err != nil {
+		if errors.Is(err, v1.ErrNotFound) {
+			return v1.ErrNotFound
+		}
+		s.logger.Error(err.Error(), zap.Any("userId", userId), zap.Any("convId", conversationId))
+		return v1.ErrInternalServerError
+	}
+	if userConv.RequestStatus == status {
+		return nil
+	}
+	// 只能忽略待处理的消息请求
+	if status == contants.MsgRequestStatusIgnored && userConv.RequestStatus != contants.MsgRequestStatusPending {
+		return v1.ErrBadRequest
+	}
+
+	if err = s.repo.UpdateUserConversationRequestStatus(ctx, userId, conversationId, status); err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId), zap.Any("convId", conversationId))
+		return v1.ErrInternalServerError
+	}
+	return nil
+}
+
+func (s *chatService) buildConversationResp(ctx context.Context, uc model.UserConversationList, conv model.ConversationList) v1.ConversationResp {
+	resp := v1.ConversationResp{
+		ConversationId: uc.ConversationId,
+		Type:           conv.Type,
+		Avatar:         conv.Avatar,
+		LastReadSeq:    uc.LastReadSeq,
+		NotifyType:     uc.NotifyType,
+		IsTop:          uc.IsTop,
+		RecentMsg:      s.GetLastConversationMsg(ctx, uc.ConversationId),
+	}
+	//单聊会话获取用户列表
+	if resp.Type == contants.ConversationTypeC2C {
+		conversationUsers, _ := s.GetConversationUsers(ctx, uc.ConversationId)
+		resp.UserList = conversationUsers //会话用户列表
+	}
+	return resp
+}
+
 func (s *chatService) CreateConversationList(ctx context.Context, list ...*model.ConversationList) error {
 
 	//TODO implement me
 	panic("implement me")
 }
 
+func (s *chatService) CreateC2CConversation(ctx context.Context, userId, targetId int64) (int64, error) {
+	if userId == targetId {
+		return 0, v1.ErrBadRequest
+	}
+
+	var convId int64
+	if err := s.tm.Transaction(ctx, func(ctx context.Context) error {
+		cId, err := s.repo.SelectC2CConversationId(ctx, userId, targetId)
+		if err != nil {
+			return err
+		}
+		if cId > 0 {
+			convId = cId
+			return nil
+		}
+
+		// 接收者不允许直接发消息时，会话进入对方的消息请求
+		requestStatus, err := s.msgRequestStatus(ctx, userId, targetId)
+		if err != nil {
+			return err
+		}
+
+		newId, err := s.sid.GenUint64()
+		if err != nil {
+			return err
+		}
+		now := time.Now().Unix()
+
+		// 先占用单聊索引，并发时以先写入的会话为准
+		pair := &model.C2CConversation{
+			MinUserId:      userId,
+			MaxUserId:      targetId,
+			ConversationId: int64(newId),
+			CreatedAt:      now,
+		}
+		if pair.MinUserId > pair.MaxUserId {
+			pair.MinUserId, pair.MaxUserId = pair.MaxUserId, pair.MinUserId
+		}
+		created, err := s.repo.CreateC2CConversation(ctx, pair)
+		if err != nil {
+			return err
+		}
+		convId = pair.ConversationId
+		if !created {
+			return nil
+		}
+
+		conversationInfo := &model.ConversationList{
+			ConversationId: convId,
+			Type:           contants.ConversationTypeC2C,
+			Member:         2,
+			RecentMsgTime:  now,
+			CreatedAt:      now,
+		}
+		if err = s.repo.CreateConversation(ctx, conversationInfo); err != nil {
+			return err
+		}
+
+		// 双方的会话链
+		return s.repo.CreateUserConversationList(ctx, &model.UserConversationList{
+			UserId:         userId,
+			ConversationId: convId,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		}, &model.UserConversationList{
+			UserId:         targetId,
+			ConversationId: convId,
+			RequestStatus:  requestStatus,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		})
+	}); err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId), zap.Any("targetId", targetId))
+		return 0, err
+	}
+	return convId, nil
+}
+
+// 单聊每次发送都按接收者当前的设置和双方关系重新判断：不再满足时新消息进入对方的消息请求，
+// 满足后待处理的请求移入会话列表，已忽略的保持忽略。返回接收者的会话是否为消息请求
+func (s *chatService) syncMsgRequest(ctx context.Context, convId, userId, targetId int64) (bool, error) {
+	userConv, err := s.repo.SelectUserConversation(ctx, targetId, convId)
+	if err != nil {
+		return false, err
+	}
+	if userConv.RequestStatus == contants.MsgRequestStatusIgnored {
+		return true, nil
+	}
+
+	status, err := s.msgRequestStatus(ctx, userId, targetId)
+	if err != nil {
+		return false, err
+	}
+	if status != userConv.RequestStatus {
+		if err = s.repo.UpdateUserConversationRequestStatus(ctx, targetId, convId, status); err != nil {
+			return false, err
+		}
+	}
+	return status != contants.MsgRequestStatusNone, nil
+}
+
+// 根据接收者的设置判断发送者的会话是否作为消息请求
+func (s *chatService) msgRequestStatus(ctx context.Context, userId, targetId int64) (int, error) {
+	target, err := s.userRepo.GetAccountInfoByID(ctx, targetId)
+	if err != nil {
+		return 0, err
+	}
+	allowType := target.MsgAllowType
+	if allowType == contants.MsgAllowTypeDefault {
+		allowType = s.msgAllowType
+	}
+	if allowType == contants.MsgAllowTypeAnyone {
+		return contants.MsgRequestStatusNone, nil
+	}
+
+	allowed, err := s.hasRelationship(ctx, targetId, userId, contants.RelationshipTypeFriend)
+	if err != nil {
+		return 0, err
+	}
+	// 关注了接收者
+	if !allowed && allowType == contants.MsgAllowTypeFollower {
+		if allowed, err = s.hasRelationship(ctx, userId, targetId, contants.RelationshipTypeFollow); err != nil {
+			return 0, err
+		}
+	}
+
+	if allowed {
+		return contants.MsgRequestStatusNone, nil
+	}
+	return contants.MsgRequestStatusPending, nil
+}
+
+func (s *chatService) hasRelationship(ctx context.Context, userId, targetId int64, relationshipType int) (bool, error) {
+	info, err := s.relationRepo.SelectRelationshipOne(ctx, userId, targetId, relationshipType)
+	if err != nil {
+		if errors.Is(err, v1.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return info.Status == contants.RelationshipStatusNormal, nil
+}
+
+func (s *chatService) GetC2CConversation(ctx context.Context, userId, targetId int64) (*v1.ConversationResp, error) {
+	if _, err := s.userRepo.GetByID(ctx, targetId); err != nil {
+		if errors.Is(err, v1.ErrNotFound) {
+			return nil, v1.ErrNotFound
+		}
+		s.logger.Error(err.Error(), zap.Any("targetId", targetId))
+		return nil, v1.ErrInternalServerError
+	}
+	// 与发送消息一致，拉黑后不能创建或获取会话
+	if err := checkBlocked(ctx, s.Service, s.relationRepo, userId, targetId); err != nil {
+		return nil, err
+	}
+
+	convId, err := s.CreateC2CConversation(ctx, userId, targetId)
+	if err != nil {
+		if errors.Is(err, v1.ErrBadRequest) {
+			return nil, err
+		}
+		return nil, v1.ErrInternalServerError
+	}
+
+	userConv, err := s.repo.SelectUserConversation(ctx, userId, convId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId), zap.Any("convId", convId))
+		return nil, v1.ErrInternalServerError
+	}
+	conversationLists, err := s.repo.SelectConversation(ctx, convId)
+	if err != nil || len(conversationLists) < 1 {
+		return nil, v1.ErrInternalServerError
+	}
+
+	resp := s.buildConversationResp(ctx, *userConv, conversationLists[0])
+	return &resp, nil
+}
+
 func (s *chatService) ReportReadMsgSeq(ctx context.Context, req *v1.ReportReadReq) error {
 	now := time.Now().Unix()
 	uc := model.UserConversationList{
@@ -244,6 +604,10 @@ func (s *chatService) GetLastConversationMsg(ctx context.Context, conversationId
 	if err != nil {
 		s.logger.Error(err.Error(), zap.Any("convId", conversationId))
 	}
+	// 会话列表展示给所有成员，屏蔽的消息不展示内容
+	if lastMsg.Status == contants.MsgStatusBlocked {
+		lastMsg.Content = ""
+	}
 	return v1.SendMsgResp{
 		ConversationId: lastMsg.ConversationId,
 		MsgId:          lastMsg.MsgId,
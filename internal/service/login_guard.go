@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	v1 "github.com/ljinf/im_server_standalone/api/v1"
+	"github.com/ljinf/im_server_standalone/internal/repository"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+// 密码登录防暴力破解：按账号和ip统计失败次数，
+// 账号连续失败达到delayAfter次后每次失败需等待的时间翻倍，达到maxFailures次锁定一段时间；
+// 同一ip失败过多时拒绝该ip的所有密码登录。redis异常时放行，不影响正常登录
+type LoginGuardService interface {
+	// 校验密码前调用，被锁定或需要等待时返回错误
+	Check(ctx context.Context, account, ip string) error
+	Fail(ctx context.Context, account, ip string)
+	Success(ctx context.Context, account, ip string, userId int64)
+}
+
+type loginGuardService struct {
+	*Service
+	repo          repository.LoginGuardRepository
+	window        time.Duration //失败次数统计窗口
+	maxFailures   int64         //账号失败达到后锁定
+	ipMaxFailures int64         //ip失败达到后拒绝登录，直到窗口结束
+	delayAfter    int64         //账号失败达到后开始渐进延迟
+	delayBase     time.Duration
+	delayMax      time.Duration
+	lockout       time.Duration //锁定时长
+}
+
+func NewLoginGuardService(s *Service, conf *viper.Viper, repo repository.LoginGuardRepository) LoginGuardService {
+	srv := &loginGuardService{
+		Service:       s,
+		repo:          repo,
+		window:        conf.GetDuration("security.login.failure_window"),
+		maxFailures:   conf.GetInt64("security.login.max_failures"),
+		ipMaxFailures: conf.GetInt64("security.login.ip_max_failures"),
+		delayAfter:    conf.GetInt64("security.login.delay_after"),
+		delayBase:     conf.GetDuration("security.login.delay_base"),
+		delayMax:      conf.GetDuration("security.login.delay_max"),
+		lockout:       conf.GetDuration("security.login.lockout"),
+	}
+	if srv.window <= 0 {
+		srv.window = 15 * time.Minute
+	}
+	if srv.maxFailures <= 0 {
+		srv.maxFailures = 10
+	}
+	if srv.ipMaxFailures <= 0 {
+		srv.ipMaxFailures = 50
+	}
+	if srv.delayAfter <= 0 {
+		srv.delayAfter = 3
+	}
+	if srv.delayBase <= 0 {
+		srv.delayBase = time.Second
+	}
+	if srv.delayMax <= 0 {
+		srv.delayMax = time.Minute
+	}
+	if srv.lockout <= 0 {
+		srv.lockout = 15 * time.Minute
+	}
+	return srv
+}
+
+func (s *loginGuardService) Check(ctx context.Context, account, ip string) error {
+	account = strings.ToLower(account)
+	lock, wait, ipFailures, err := s.repo.GetLoginState(ctx, account, ip)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.String("account", account), zap.String("ip", ip))
+		return nil
+	}
+
+	switch {
+	case lock > 0:
+		s.audit(ctx, "login_locked", account, ip, 0)
+		return v1.ErrAccountLocked
+	case ipFailures >= s.ipMaxFailures:
+		s.audit(ctx, "login_ip_blocked", account, ip, 0)
+		return v1.ErrLoginTooFrequent
+	case wait > 0:
+		s.audit(ctx, "login_throttled", account, ip, 0)
+		return v1.ErrLoginTooFrequent
+	}
+	return nil
+}
+
+func (s *loginGuardService) Fail(ctx context.Context, account, ip string) {
+	account = strings.ToLower(account)
+	s.audit(ctx, "login_failed", account, ip, 0)
+
+	failures, _, err := s.repo.IncrLoginFailure(ctx, account, ip, s.window)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.String("account", account), zap.String("ip", ip))
+		return
+	}
+
+	if failures >= s.maxFailures {
+		if err = s.repo.LockAccount(ctx, account, s.lockout); err != nil {
+			s.logger.Error(err.Error(), zap.String("account", account))
+			return
+		}
+		s.audit(ctx, "account_locked", account, ip, 0)
+		return
+	}
+
+	if failures >= s.delayAfter {
+		wait := s.delayBase << (failures - s.delayAfter)
+		if wait > s.delayMax || wait <= 0 {
+			wait = s.delayMax
+		}
+		if err = s.repo.SetLoginWait(ctx, account, wait); err != nil {
+			s.logger.Error(err.Error(), zap.String("account", account))
+		}
+	}
+}
+
+func (s *loginGuardService) Success(ctx context.Context, account, ip string, userId int64) {
+	account = strings.ToLower(account)
+	s.audit(ctx, "login_success", account, ip, userId)
+
+	if err := s.repo.ClearLoginFailure(ctx, account); err != nil {
+		s.logger.Error(err.Error(), zap.String("account", account))
+	}
+}
+
+// 审计日志统一以login audit为消息，按event区分
+func (s *loginGuardService) audit(ctx context.Context, event, account, ip string, userId int64) {
+	s.logger.WithContext(ctx).Info("login audit", zap.String("event", event), zap.String("account", account),
+		zap.String("ip", ip), zap.Int64("userId", userId))
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	v1 "github.com/ljinf/im_server_standalone/api/v1"
+	"github.com/ljinf/im_server_standalone/internal/model"
+	"github.com/ljinf/im_server_standalone/internal/repository"
+	"github.com/ljinf/im_server_standalone/pkg/moderation"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"time"
+)
+
+// 发送前审核消息内容，审核器由moderation配置决定，都未开启时全部放行
+type ModerationService interface {
+	Moderate(ctx context.Context, req *v1.SendMsgReq) *moderation.Result
+	// 记录审核结果，放行的只在moderation.audit_allow开启时记录
+	Audit(ctx context.Context, msgId int64, req *v1.SendMsgReq, result *moderation.Result)
+}
+
+type moderationService struct {
+	*Service
+	repo       repository.ModerationRepository
+	pipeline   *moderation.Pipeline
+	auditAllow bool
+}
+
+func NewModerationService(s *Service, conf *viper.Viper, repo repository.ModerationRepository, pipeline *moderation.Pipeline) ModerationService {
+	return &moderationService{
+		Service:    s,
+		repo:       repo,
+		pipeline:   pipeline,
+		auditAllow: conf.GetBool("moderation.audit_allow"),
+	}
+}
+
+func (s *moderationService) Moderate(ctx context.Context, req *v1.SendMsgReq) *moderation.Result {
+	return s.pipeline.Moderate(ctx, &moderation.Input{
+		UserId:         req.UserId,
+		TargetId:       req.TargetId,
+		ConversationId: req.ConversationId,
+		ContentType:    req.ContentType,
+		Content:        req.Content,
+	})
+}
+
+// 审核记录写入失败不影响发送
+func (s *moderationService) Audit(ctx context.Context, msgId int64, req *v1.SendMsgReq, result *moderation.Result) {
+	if result.Action == moderation.ActionAllow && !s.auditAllow {
+		return
+	}
+	audit := &model.ModerationAudit{
+		MsgId:          msgId,
+		UserId:         req.UserId,
+		TargetId:       req.TargetId,
+		ConversationId: req.ConversationId,
+		Moderator:      result.Moderator,
+		Rule:           result.Rule,
+		Action:         result.Action.String(),
+		Reason:         result.Reason,
+		Content:        req.Content,
+		CreatedAt:      time.Now().Unix(),
+	}
+	if err := s.repo.CreateAudit(ctx, audit); err != nil {
+		s.logger.Error(err.Error(), zap.Any("audit", audit))
+	}
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"errors"
+	v1 "github.com/ljinf/im_server_standalone/api/v1"
+	"github.com/ljinf/im_server_standalone/internal/model"
+	"github.com/ljinf/im_server_standalone/pkg/contants"
+	"time"
+	"unicode/utf8"
+)
+
+// 自定义资料字段默认最大长度
+const defaultProfileFieldLength = 256
+
+// 自定义资料字段，由profile.custom_fields配置
+type profileFieldConfig struct {
+	Key       string `mapstructure:"key"`
+	MaxLength int    `mapstructure:"max_length"`
+}
+
+// 内置资料字段，存在user_info中
+type builtinProfileField struct {
+	column           string
+	visibilityColumn string
+	maxLength        int
+	isDate           bool
+}
+
+var (
+	profileSignature = builtinProfileField{column: "self_signature", visibilityColumn: "signature_visibility", maxLength: 128}
+	profileBirthday  = builtinProfileField{column: "birth_day", visibilityColumn: "birthday_visibility", isDate: true}
+	profileRegion    = builtinProfileField{column: "region", visibilityColumn: "region_visibility", maxLength: 64}
+)
+
+// 校验后写入要更新的列，value为空表示清除
+func (f builtinProfileField) apply(columns map[string]interface{}, field *v1.ProfileField) error {
+	if field == nil {
+		return nil
+	}
+	if f.isDate && field.Value != "" {
+		birthday, err := time.Parse(time.DateOnly, field.Value)
+		if err != nil || birthday.After(time.Now()) {
+			return v1.ErrProfileFieldInvalid
+		}
+	}
+	if f.maxLength > 0 && utf8.RuneCountInString(field.Value) > f.maxLength {
+		return v1.ErrProfileFieldInvalid
+	}
+	columns[f.column] = field.Value
+	columns[f.visibilityColumn] = profileVisibility(field.Visibility)
+	return nil
+}
+
+func profileVisibility(visibility int) int {
+	if visibility == 0 {
+		return contants.ProfileVisibilityPublic
+	}
+	return visibility
+}
+
+// 按自定义字段配置校验，返回要保存的字段和要删除的字段名
+func (s *userService) buildCustomFields(userId int64, fields map[string]v1.ProfileField) ([]model.UserProfileField, []string, error) {
+	save := make([]model.UserProfileField, 0, len(fields))
+	delKeys := make([]string, 0)
+	now := time.Now()
+	for key, v := range fields {
+		maxLength, ok := s.customFields[key]
+		if !ok {
+			return nil, nil, v1.ErrProfileFieldInvalid
+		}
+		if v.Value == "" {
+			delKeys = append(delKeys, key)
+			continue
+		}
+		if utf8.RuneCountInString(v.Value) > maxLength {
+			return nil, nil, v1.ErrProfileFieldInvalid
+		}
+		save = append(save, model.UserProfileField{
+			UserId:     userId,
+			FieldKey:   key,
+			Value:      v.Value,
+			Visibility: profileVisibility(v.Visibility),
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		})
+	}
+	return save, delKeys, nil
+}
+
+// 查看者能看到的最大可见范围：自己看全部，好友看仅好友及以下，其他人只看所有人可见的
+func (s *userService) profileViewLevel(ctx context.Context, viewerId, userId int64) (int, error) {
+	if viewerId == userId {
+		return contants.ProfileVisibilityPrivate, nil
+	}
+	friend, err := s.relationRepo.SelectRelationshipOne(ctx, userId, viewerId, contants.RelationshipTypeFriend)
+	if err != nil {
+		if errors.Is(err, v1.ErrNotFound) {
+			return contants.ProfileVisibilityPublic, nil
+		}
+		return 0, err
+	}
+	if friend != nil && friend.Status == contants.RelationshipStatusNormal {
+		return contants.ProfileVisibilityFriend, nil
+	}
+	return contants.ProfileVisibilityPublic, nil
+}
+
+// 不可见时返回空
+func visibleProfileValue(value string, visibility, level int) string {
+	if profileVisibility(visibility) > level {
+		return ""
+	}
+	return value
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/ljinf/im_server_standalone/internal/model"
 	"github.com/ljinf/im_server_standalone/internal/repository"
 	"github.com/ljinf/im_server_standalone/pkg/contants"
+	"github.com/spf13/viper"
 	"go.uber.org/zap"
 	"time"
 )
@@ -14,29 +15,57 @@ import (
 type RelationshipService interface {
 	AddApplyFriendship(ctx context.Context, req *v1.ApplyFriendshipRequest) error
 	GetApplyFriendshipList(ctx context.Context, userId int64, page int, pageSize int) (interface{}, error)
-	UpdateApplyFriendshipInfo(ctx context.Context, req *v1.ApplyFriendshipRequest) error
+	// 通过申请时返回打招呼消息
+	UpdateApplyFriendshipInfo(ctx context.Context, req *v1.ApplyFriendshipRequest) (*v1.SendMsgResp, error)
 	DelApplyFriendshipInfo(ctx context.Context, req *v1.ApplyFriendshipRequest) error
 
-	GetRelationshipList(ctx context.Context, userId int64, relationshipType, page int, pageSize int) (interface{}, error)
+	GetRelationshipList(ctx context.Context, userId int64, relationshipType int, tagId int64, page int, pageSize int) (interface{}, error)
 	GetRelationship(ctx context.Context, req *v1.RelationshipRequest) (*model.RelationshipList, error)
-	AddRelationshipFollow(ctx context.Context, req *v1.RelationshipRequest) error
+	// 互相关注自动成为好友时返回打招呼消息
+	AddRelationshipFollow(ctx context.Context, req *v1.RelationshipRequest) (*v1.SendMsgResp, error)
+	DelRelationshipFollow(ctx context.Context, req *v1.FollowRequest) error
+	GetFollowList(ctx context.Context, userId int64, followers bool, cursor int64, pageSize int) (*v1.FollowListResp, error)
+	GetFollowCount(ctx context.Context, userId int64) (*v1.FollowCountResp, error)
 	UpdateRelationship(ctx context.Context, req *v1.RelationshipRequest) error
 	DelRelationship(ctx context.Context, req *v1.RelationshipRequest) error
+
+	// 通讯录增量同步
+	SyncContacts(ctx context.Context, userId, version int64, pageSize int) (*v1.ContactSyncResp, error)
+
+	// 分组标签
+	AddTag(ctx context.Context, req *v1.TagRequest) (*v1.TagResp, error)
+	UpdateTag(ctx context.Context, req *v1.TagRequest) error
+	DelTag(ctx context.Context, req *v1.DelTagRequest) error
+	GetTagList(ctx context.Context, userId int64) ([]v1.TagResp, error)
+	SetRelationshipTags(ctx context.Context, req *v1.SetRelationshipTagsRequest) error
+
+	// 黑名单
+	AddBlock(ctx context.Context, req *v1.BlockRequest) error
+	DelBlock(ctx context.Context, req *v1.BlockRequest) error
+	GetBlockList(ctx context.Context, userId int64, page int, pageSize int) (interface{}, error)
 }
 
 type relationshipService struct {
 	*Service
-	repo repository.RelationshipRepository
+	repo             repository.RelationshipRepository
+	chatSrv          ChatService
+	followAutoFriend bool //互相关注后自动成为好友
 }
 
-func NewRelationshipService(s *Service, repo repository.RelationshipRepository) RelationshipService {
+func NewRelationshipService(s *Service, conf *viper.Viper, repo repository.RelationshipRepository, chatSrv ChatService) RelationshipService {
 	return &relationshipService{
-		Service: s,
-		repo:    repo,
+		Service:          s,
+		repo:             repo,
+		chatSrv:          chatSrv,
+		followAutoFriend: conf.GetBool("relationship.follow_auto_friend"),
 	}
 }
 
 func (r *relationshipService) AddApplyFriendship(ctx context.Context, req *v1.ApplyFriendshipRequest) error {
+	if err := checkBlocked(ctx, r.Service, r.repo, req.UserId, req.TargetId); err != nil {
+		return err
+	}
+
 	now := time.Now()
 	applyA := model.ApplyFriendshipList{
 		UserId:      req.UserId,
@@ -83,7 +112,8 @@ func (r *relationshipService) GetApplyFriendshipList(ctx context.Context, userId
 	return resp, nil
 }
 
-func (r *relationshipService) UpdateApplyFriendshipInfo(ctx context.Context, req *v1.ApplyFriendshipRequest) error {
+func (r *relationshipService) UpdateApplyFriendshipInfo(ctx context.Context, req *v1.ApplyFriendshipRequest) (*v1.SendMsgResp, error) {
+	var greeting *v1.SendMsgResp
 	err := r.tm.Transaction(ctx, func(ctx context.Context) error {
 
 		// 修改申请状态  被申请人
@@ -112,6 +142,9 @@ func (r *relationshipService) UpdateApplyFriendshipInfo(ctx context.Context, req
 
 		now := time.Now()
 		if req.Status == contants.ApplyFriendshipStatusApproved {
+			if err := checkBlocked(ctx, r.Service, r.repo, req.UserId, req.TargetId); err != nil {
+				return err
+			}
 
 			// 添加好友记录
 			applyInfo, err := r.repo.SelectApplyOne(ctx, req.TargetId, req.UserId)
@@ -120,6 +153,7 @@ func (r *relationshipService) UpdateApplyFriendshipInfo(ctx context.Context, req
 				if errors.Is(err, v1.ErrNotFound) {
 					return v1.ErrBadRequest
 				}
+				return v1.ErrInternalServerError
 			}
 			friendA := model.RelationshipList{
 				UserId:           req.UserId,
@@ -145,11 +179,43 @@ func (r *relationshipService) UpdateApplyFriendshipInfo(ctx context.Context, req
 				r.logger.Error(err.Error(), zap.Any("friendship", [2]model.RelationshipList{friendA, friendB}))
 				return v1.ErrCreateRelationshipFailed
 			}
+
+			// 单聊会话和打招呼消息与好友关系一起提交
+			greeting, err = r.sayHello(ctx, req.UserId, req.TargetId)
+			if err != nil {
+				return v1.ErrCreateRelationshipFailed
+			}
 			return nil
 		}
 		return nil
 	})
-	return err
+	if err != nil {
+		return nil, err
+	}
+	return greeting, nil
+}
+
+// 创建双方的单聊会话，并发送一条打招呼的系统消息
+func (r *relationshipService) sayHello(ctx context.Context, userId, targetId int64) (*v1.SendMsgResp, error) {
+	convId, err := r.chatSrv.CreateC2CConversation(ctx, userId, targetId)
+	if err != nil {
+		return nil, err
+	}
+	// 成为好友前的陌生人消息移入会话列表
+	for _, uid := range []int64{userId, targetId} {
+		if err = r.chatSrv.AcceptMsgRequest(ctx, uid, convId); err != nil {
+			return nil, err
+		}
+	}
+
+	return r.chatSrv.CreateSystemMsg(ctx, &v1.SendMsgReq{
+		ConversationId: convId,
+		UserId:         userId,
+		TargetId:       targetId,
+		Content:        contants.ChatSayHello,
+		ContentType:    contants.MsgContentTypeSystem,
+		SendTime:       time.Now().Unix(),
+	})
 }
 
 func (r *relationshipService) DelApplyFriendshipInfo(ctx context.Context, req *v1.ApplyFriendshipRequest) error {
@@ -161,8 +227,8 @@ func (r *relationshipService) DelApplyFriendshipInfo(ctx context.Context, req *v
 }
 
 // 查询列表
-func (r *relationshipService) GetRelationshipList(ctx context.Context, userId int64, relationshipType, page int, pageSize int) (interface{}, error) {
-	list, total, err := r.repo.SelectRelationshipList(ctx, userId, relationshipType, page, pageSize)
+func (r *relationshipService) GetRelationshipList(ctx context.Context, userId int64, relationshipType int, tagId int64, page int, pageSize int) (interface{}, error) {
+	list, total, err := r.repo.SelectRelationshipList(ctx, userId, relationshipType, tagId, page, pageSize)
 	if err != nil {
 		r.logger.Error(err.Error(), zap.Any("userID", userId))
 		return nil, err
@@ -184,7 +250,13 @@ func (r *relationshipService) GetRelationship(ctx context.Context, req *v1.Relat
 	return info, nil
 }
 
-func (r *relationshipService) AddRelationshipFollow(ctx context.Context, req *v1.RelationshipRequest) error {
+func (r *relationshipService) AddRelationshipFollow(ctx context.Context, req *v1.RelationshipRequest) (*v1.SendMsgResp, error) {
+	if req.UserId == req.TargetId {
+		return nil, v1.ErrBadRequest
+	}
+	if err := checkBlocked(ctx, r.Service, r.repo, req.UserId, req.TargetId); err != nil {
+		return nil, err
+	}
 
 	now := time.Now()
 	ra := model.RelationshipList{
@@ -198,19 +270,157 @@ func (r *relationshipService) AddRelationshipFollow(ctx context.Context, req *v1
 		UpdatedAt:        now,
 	}
 
-	if err := r.repo.CreateRelationship(ctx, ra); err != nil {
-		r.logger.Error(err.Error(), zap.Any("req", *req))
+	var greeting *v1.SendMsgResp
+	if err := r.tm.Transaction(ctx, func(ctx context.Context) error {
+		if err := r.repo.CreateRelationship(ctx, ra); err != nil {
+			r.logger.Error(err.Error(), zap.Any("req", *req))
+			return v1.ErrInternalServerError
+		}
+		if !r.followAutoFriend {
+			return nil
+		}
+
+		var err error
+		greeting, err = r.promoteMutualFollow(ctx, req.UserId, req.TargetId)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return greeting, nil
+}
+
+// 互相关注且还不是好友时自动添加好友
+func (r *relationshipService) promoteMutualFollow(ctx context.Context, userId, targetId int64) (*v1.SendMsgResp, error) {
+	followers, err := r.repo.SelectFollowersIn(ctx, userId, targetId)
+	if err != nil {
+		r.logger.Error(err.Error(), zap.Any("userId", userId), zap.Any("targetId", targetId))
+		return nil, v1.ErrInternalServerError
+	}
+	if len(followers) < 1 {
+		return nil, nil
+	}
+
+	friend, err := r.repo.SelectRelationshipOne(ctx, userId, targetId, contants.RelationshipTypeFriend)
+	if err != nil && !errors.Is(err, v1.ErrNotFound) {
+		r.logger.Error(err.Error(), zap.Any("userId", userId), zap.Any("targetId", targetId))
+		return nil, v1.ErrInternalServerError
+	}
+	if friend != nil && friend.Status == contants.RelationshipStatusNormal {
+		return nil, nil
+	}
+
+	now := time.Now()
+	friendA := model.RelationshipList{
+		UserId:           userId,
+		TargetId:         targetId,
+		RelationshipType: contants.RelationshipTypeFriend,
+		Status:           contants.RelationshipStatusNormal,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	friendB := model.RelationshipList{
+		UserId:           targetId,
+		TargetId:         userId,
+		RelationshipType: contants.RelationshipTypeFriend,
+		Status:           contants.RelationshipStatusNormal,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	if err = r.repo.CreateRelationship(ctx, friendA, friendB); err != nil {
+		r.logger.Error(err.Error(), zap.Any("friendship", [2]model.RelationshipList{friendA, friendB}))
+		return nil, v1.ErrCreateRelationshipFailed
+	}
+
+	greeting, err := r.sayHello(ctx, userId, targetId)
+	if err != nil {
+		return nil, v1.ErrCreateRelationshipFailed
+	}
+	return greeting, nil
+}
+
+// 取消关注
+func (r *relationshipService) DelRelationshipFollow(ctx context.Context, req *v1.FollowRequest) error {
+	if err := r.repo.DelRelationship(ctx, req.UserId, req.TargetId, contants.RelationshipTypeFollow); err != nil {
+		r.logger.Error(err.Error(), zap.Any("req", req))
 		return v1.ErrInternalServerError
 	}
 	return nil
 }
 
+// 关注列表(followers为false)或粉丝列表
+func (r *relationshipService) GetFollowList(ctx context.Context, userId int64, followers bool, cursor int64, pageSize int) (*v1.FollowListResp, error) {
+	list, err := r.repo.SelectFollowList(ctx, userId, followers, cursor, pageSize)
+	if err != nil {
+		r.logger.Error(err.Error(), zap.Any("userId", userId), zap.Any("followers", followers))
+		return nil, v1.ErrInternalServerError
+	}
+
+	ids := make([]int64, 0, len(list))
+	for _, v := range list {
+		if followers {
+			ids = append(ids, v.UserId)
+		} else {
+			ids = append(ids, v.TargetId)
+		}
+	}
+
+	// 互相关注
+	var mutualIds []int64
+	if followers {
+		mutualIds, err = r.repo.SelectFollowingIn(ctx, userId, ids...)
+	} else {
+		mutualIds, err = r.repo.SelectFollowersIn(ctx, userId, ids...)
+	}
+	if err != nil {
+		r.logger.Error(err.Error(), zap.Any("userId", userId))
+		return nil, v1.ErrInternalServerError
+	}
+	mutual := make(map[int64]struct{}, len(mutualIds))
+	for _, v := range mutualIds {
+		mutual[v] = struct{}{}
+	}
+
+	resp := &v1.FollowListResp{
+		Rows: make([]v1.FollowItem, 0, len(list)),
+	}
+	for index, v := range list {
+		item := v1.FollowItem{
+			UserId:    ids[index],
+			CreatedAt: v.CreatedAt.Unix(),
+		}
+		// 备注是关注人对被关注人的
+		if !followers {
+			item.Remark = v.Remark
+		}
+		_, item.Mutual = mutual[item.UserId]
+		resp.Rows = append(resp.Rows, item)
+	}
+	if len(list) >= pageSize {
+		resp.NextCursor = list[len(list)-1].Id
+	}
+	return resp, nil
+}
+
+func (r *relationshipService) GetFollowCount(ctx context.Context, userId int64) (*v1.FollowCountResp, error) {
+	following, followers, err := r.repo.SelectFollowCount(ctx, userId)
+	if err != nil {
+		r.logger.Error(err.Error(), zap.Any("userId", userId))
+		return nil, v1.ErrInternalServerError
+	}
+	return &v1.FollowCountResp{
+		UserId:    userId,
+		Following: following,
+		Followers: followers,
+	}, nil
+}
+
 func (r *relationshipService) UpdateRelationship(ctx context.Context, req *v1.RelationshipRequest) error {
 	info := model.RelationshipList{
-		UserId:   req.UserId,
-		TargetId: req.TargetId,
-		Remark:   req.Remark,
-		Status:   req.Status,
+		UserId:           req.UserId,
+		TargetId:         req.TargetId,
+		Remark:           req.Remark,
+		RelationshipType: req.RelationshipType,
+		Status:           req.Status,
 	}
 	if err := r.repo.UpdateRelationship(ctx, &info); err != nil {
 		r.logger.Error(err.Error(), zap.Any("req", info))
@@ -222,3 +432,228 @@ func (r *relationshipService) UpdateRelationship(ctx context.Context, req *v1.Re
 func (r *relationshipService) DelRelationship(ctx context.Context, req *v1.RelationshipRequest) error {
 	return r.repo.DelRelationship(ctx, req.UserId, req.TargetId, req.RelationshipType)
 }
+
+// 返回版本号之后变更的关系，客户端按版本号循环拉取直到has_more为false
+func (r *relationshipService) SyncContacts(ctx context.Context, userId, version int64, pageSize int) (*v1.ContactSyncResp, error) {
+	list, err := r.repo.SelectContactChanges(ctx, userId, version, pageSize)
+	if err != nil {
+		r.logger.Error(err.Error(), zap.Any("userId", userId), zap.Any("version", version))
+		return nil, v1.ErrInternalServerError
+	}
+
+	targetIds := make([]int64, 0, len(list))
+	for _, v := range list {
+		targetIds = append(targetIds, v.TargetId)
+	}
+	tagIds, err := r.repo.SelectRelationshipTags(ctx, userId, targetIds...)
+	if err != nil {
+		r.logger.Error(err.Error(), zap.Any("userId", userId))
+		return nil, v1.ErrInternalServerError
+	}
+	tags, err := r.GetTagList(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &v1.ContactSyncResp{
+		Rows:    make([]v1.ContactItem, 0, len(list)),
+		Tags:    tags,
+		Version: version,
+		HasMore: len(list) >= pageSize,
+	}
+	for _, v := range list {
+		item := v1.ContactItem{
+			TargetId:         v.TargetId,
+			Remark:           v.Remark,
+			RelationshipType: v.RelationshipType,
+			Status:           v.Status,
+			Extra:            v.Extra,
+			TagIds:           tagIds[v.TargetId],
+			Deleted:          v.DeletedAt.Valid,
+			Version:          v.Version,
+		}
+		if item.Deleted {
+			item.TagIds = nil
+		}
+		resp.Rows = append(resp.Rows, item)
+		resp.Version = v.Version
+	}
+	return resp, nil
+}
+
+// 分组标签
+func (r *relationshipService) AddTag(ctx context.Context, req *v1.TagRequest) (*v1.TagResp, error) {
+	tags, err := r.repo.SelectTagList(ctx, req.UserId)
+	if err != nil {
+		r.logger.Error(err.Error(), zap.Any("req", req))
+		return nil, v1.ErrInternalServerError
+	}
+	if len(tags) >= contants.RelationshipTagLimit {
+		return nil, v1.ErrTagLimit
+	}
+	for _, v := range tags {
+		if v.Name == req.Name {
+			return nil, v1.ErrTagExists
+		}
+	}
+
+	now := time.Now()
+	tag := model.RelationshipTag{
+		UserId:    req.UserId,
+		Name:      req.Name,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err = r.repo.CreateTag(ctx, &tag); err != nil {
+		r.logger.Error(err.Error(), zap.Any("req", req))
+		return nil, v1.ErrInternalServerError
+	}
+	return &v1.TagResp{TagId: tag.Id, Name: tag.Name}, nil
+}
+
+func (r *relationshipService) UpdateTag(ctx context.Context, req *v1.TagRequest) error {
+	tags, err := r.repo.SelectTagList(ctx, req.UserId)
+	if err != nil {
+		r.logger.Error(err.Error(), zap.Any("req", req))
+		return v1.ErrInternalServerError
+	}
+	for _, v := range tags {
+		if v.Name == req.Name && v.Id != req.TagId {
+			return v1.ErrTagExists
+		}
+	}
+
+	if err = r.repo.UpdateTag(ctx, req.UserId, req.TagId, req.Name); err != nil {
+		if errors.Is(err, v1.ErrNotFound) {
+			return err
+		}
+		r.logger.Error(err.Error(), zap.Any("req", req))
+		return v1.ErrInternalServerError
+	}
+	return nil
+}
+
+func (r *relationshipService) DelTag(ctx context.Context, req *v1.DelTagRequest) error {
+	if err := r.repo.DelTag(ctx, req.UserId, req.TagId); err != nil {
+		if errors.Is(err, v1.ErrNotFound) {
+			return err
+		}
+		r.logger.Error(err.Error(), zap.Any("req", req))
+		return v1.ErrInternalServerError
+	}
+	return nil
+}
+
+func (r *relationshipService) GetTagList(ctx context.Context, userId int64) ([]v1.TagResp, error) {
+	tags, err := r.repo.SelectTagList(ctx, userId)
+	if err != nil {
+		r.logger.Error(err.Error(), zap.Any("userId", userId))
+		return nil, v1.ErrInternalServerError
+	}
+
+	resp := make([]v1.TagResp, 0, len(tags))
+	for _, v := range tags {
+		resp = append(resp, v1.TagResp{TagId: v.Id, Name: v.Name})
+	}
+	return resp, nil
+}
+
+// 设置好友所属的分组，标签必须是自己创建的
+func (r *relationshipService) SetRelationshipTags(ctx context.Context, req *v1.SetRelationshipTagsRequest) error {
+	friend, err := r.repo.SelectRelationshipOne(ctx, req.UserId, req.TargetId, contants.RelationshipTypeFriend)
+	if err != nil {
+		if errors.Is(err, v1.ErrNotFound) {
+			return err
+		}
+		r.logger.Error(err.Error(), zap.Any("req", req))
+		return v1.ErrInternalServerError
+	}
+	if friend.Status == contants.RelationshipStatusDel {
+		return v1.ErrNotFound
+	}
+
+	tags, err := r.repo.SelectTagList(ctx, req.UserId)
+	if err != nil {
+		r.logger.Error(err.Error(), zap.Any("req", req))
+		return v1.ErrInternalServerError
+	}
+	owned := make(map[int64]struct{}, len(tags))
+	for _, v := range tags {
+		owned[v.Id] = struct{}{}
+	}
+
+	tagIds := make([]int64, 0, len(req.TagIds))
+	seen := make(map[int64]struct{}, len(req.TagIds))
+	for _, v := range req.TagIds {
+		if _, ok := owned[v]; !ok {
+			return v1.ErrBadRequest
+		}
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		tagIds = append(tagIds, v)
+	}
+
+	if err = r.repo.SetRelationshipTags(ctx, req.UserId, req.TargetId, tagIds); err != nil {
+		r.logger.Error(err.Error(), zap.Any("req", req))
+		return v1.ErrInternalServerError
+	}
+	return nil
+}
+
+// 拉黑
+func (r *relationshipService) AddBlock(ctx context.Context, req *v1.BlockRequest) error {
+	if req.UserId == req.TargetId {
+		return v1.ErrBadRequest
+	}
+	if err := r.tm.Transaction(ctx, func(ctx context.Context) error {
+		if err := r.repo.CreateBlock(ctx, req.UserId, req.TargetId); err != nil {
+			return err
+		}
+		// 拉黑后双方互相取消关注
+		if err := r.repo.DelRelationship(ctx, req.UserId, req.TargetId, contants.RelationshipTypeFollow); err != nil {
+			return err
+		}
+		return r.repo.DelRelationship(ctx, req.TargetId, req.UserId, contants.RelationshipTypeFollow)
+	}); err != nil {
+		r.logger.Error(err.Error(), zap.Any("req", req))
+		return v1.ErrInternalServerError
+	}
+	return nil
+}
+
+// 移出黑名单
+func (r *relationshipService) DelBlock(ctx context.Context, req *v1.BlockRequest) error {
+	if err := r.repo.DelBlock(ctx, req.UserId, req.TargetId); err != nil {
+		r.logger.Error(err.Error(), zap.Any("req", req))
+		return v1.ErrInternalServerError
+	}
+	return nil
+}
+
+func (r *relationshipService) GetBlockList(ctx context.Context, userId int64, page int, pageSize int) (interface{}, error) {
+	list, total, err := r.repo.SelectBlockList(ctx, userId, page, pageSize)
+	if err != nil {
+		r.logger.Error(err.Error(), zap.Any("userID", userId))
+		return nil, v1.ErrInternalServerError
+	}
+	resp := map[string]interface{}{
+		"rows":  list,
+		"total": total,
+	}
+	return resp, nil
+}
+
+// 双方存在拉黑关系时返回ErrBlocked
+func checkBlocked(ctx context.Context, s *Service, repo repository.RelationshipRepository, userId, targetId int64) error {
+	blocked, err := repo.SelectBlocked(ctx, userId, targetId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId), zap.Any("targetId", targetId))
+		return v1.ErrInternalServerError
+	}
+	if blocked {
+		return v1.ErrBlocked
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	v1 "github.com/ljinf/im_server_standalone/api/v1"
+	"github.com/ljinf/im_server_standalone/internal/model"
+	"github.com/ljinf/im_server_standalone/internal/repository"
+	"github.com/ljinf/im_server_standalone/internal/ws"
+	"github.com/ljinf/im_server_standalone/pkg/contants"
+	"github.com/ljinf/im_server_standalone/pkg/rbac"
+	"go.uber.org/zap"
+	"time"
+)
+
+// 用户举报消息、用户或群组，进入审核队列由管理员处理，处理结果通知举报人
+type ReportService interface {
+	CreateReport(ctx context.Context, req *v1.CreateReportReq) (*v1.ReportResp, error)
+	// 举报人查看自己的举报及处理状态
+	GetUserReportList(ctx context.Context, userId int64, pageNum, pageSize int) ([]v1.ReportResp, error)
+
+	// 审核队列
+	GetReportList(ctx context.Context, req *v1.ReportListReq) (*v1.ReportListResp, error)
+	// 屏蔽消息、禁言或封禁被举报的用户，封禁后该用户所有会话下线。
+	// 禁言和封禁需要user:ban权限，与封禁接口一样不能由低权限角色处理管理员，并记录操作日志
+	ResolveReport(ctx context.Context, op AdminOperator, req *v1.ResolveReportReq) error
+}
+
+type reportService struct {
+	*Service
+	repo       repository.ReportRepository
+	chatRepo   repository.ChatRepository
+	userRepo   repository.UserRepository
+	sessionSrv SessionService
+	adminSrv   AdminService
+	wss        ws.SocketWsServer
+}
+
+func NewReportService(s *Service, repo repository.ReportRepository, chatRepo repository.ChatRepository,
+	userRepo repository.UserRepository, sessionSrv SessionService, adminSrv AdminService, wss ws.SocketWsServer) ReportService {
+	return &reportService{
+		Service:    s,
+		repo:       repo,
+		chatRepo:   chatRepo,
+		userRepo:   userRepo,
+		sessionSrv: sessionSrv,
+		adminSrv:   adminSrv,
+		wss:        wss,
+	}
+}
+
+func (s *reportService) CreateReport(ctx context.Context, req *v1.CreateReportReq) (*v1.ReportResp, error) {
+	report := &model.Report{
+		ReporterId:  req.UserId,
+		TargetType:  req.TargetType,
+		TargetId:    req.TargetId,
+		Reason:      req.Reason,
+		Description: req.Description,
+		Status:      contants.ReportStatusPending,
+		CreatedAt:   time.Now().Unix(),
+	}
+	if err := s.fillTarget(ctx, report); err != nil {
+		return nil, err
+	}
+
+	exists, err := s.repo.ExistsPendingReport(ctx, req.UserId, req.TargetType, req.TargetId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("req", req))
+		return nil, v1.ErrInternalServerError
+	}
+	if exists {
+		return nil, v1.ErrReportDuplicate
+	}
+
+	if err = s.repo.CreateReport(ctx, report); err != nil {
+		s.logger.Error(err.Error(), zap.Any("report", report))
+		return nil, v1.ErrInternalServerError
+	}
+	resp := convertReport(report)
+	// 消息内容只给管理员看
+	resp.Content = ""
+	return &resp, nil
+}
+
+// 校验举报对象是否存在且举报人可见，消息举报记录发送者和内容快照，避免消息之后被撤回
+func (s *reportService) fillTarget(ctx context.Context, report *model.Report) error {
+	switch report.TargetType {
+	case contants.ReportTargetMsg:
+		msg, err := s.chatRepo.GetMsg(ctx, report.TargetId)
+		if err != nil {
+			if errors.Is(err, v1.ErrNotFound) {
+				return v1.ErrNotFound
+			}
+			s.logger.Error(err.Error(), zap.Any("msgId", report.TargetId))
+			return v1.ErrInternalServerError
+		}
+		if msg.UserId == report.ReporterId {
+			return v1.ErrBadRequest
+		}
+		if _, err = s.chatRepo.SelectUserConversation(ctx, report.ReporterId, msg.ConversationId); err != nil {
+			if errors.Is(err, v1.ErrNotFound) {
+				return v1.ErrNotFound
+			}
+			s.logger.Error(err.Error(), zap.Any("convId", msg.ConversationId))
+			return v1.ErrInternalServerError
+		}
+		report.ReportedUserId = msg.UserId
+		report.Content = msg.Content
+
+	case contants.ReportTargetUser:
+		if report.TargetId == report.ReporterId {
+			return v1.ErrBadRequest
+		}
+		if _, err := s.userRepo.GetByID(ctx, report.TargetId); err != nil {
+			if errors.Is(err, v1.ErrNotFound) {
+				return v1.ErrNotFound
+			}
+			s.logger.Error(err.Error(), zap.Any("userId", report.TargetId))
+			return v1.ErrInternalServerError
+		}
+		report.ReportedUserId = report.TargetId
+
+	case contants.ReportTargetGroup:
+		list, err := s.chatRepo.SelectConversation(ctx, report.TargetId)
+		if err != nil {
+			s.logger.Error(err.Error(), zap.Any("convId", report.TargetId))
+			return v1.ErrInternalServerError
+		}
+		if len(list) < 1 || list[0].Type != contants.ConversationTypeGroup {
+			return v1.ErrNotFound
+		}
+
+	default:
+		return v1.ErrBadRequest
+	}
+	return nil
+}
+
+func (s *reportService) GetUserReportList(ctx context.Context, userId int64, pageNum, pageSize int) ([]v1.ReportResp, error) {
+	list, err := s.repo.SelectUserReportList(ctx, userId, pageNum, pageSize)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return nil, v1.ErrInternalServerError
+	}
+	resp := make([]v1.ReportResp, 0, len(list))
+	for _, v := range list {
+		item := convertReport(&v)
+		item.Content = ""
+		item.Note = ""
+		resp = append(resp, item)
+	}
+	return resp, nil
+}
+
+func (s *reportService) GetReportList(ctx context.Context, req *v1.ReportListReq) (*v1.ReportListResp, error) {
+	list, total, err := s.repo.SelectReportList(ctx, req.Status, req.TargetType, req.TargetId, req.PageNum, req.PageSize)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("req", req))
+		return nil, v1.ErrInternalServerError
+	}
+	resp := &v1.ReportListResp{Total: total, Rows: make([]v1.ReportResp, 0, len(list))}
+	for _, v := range list {
+		resp.Rows = append(resp.Rows, convertReport(&v))
+	}
+	return resp, nil
+}
+
+func (s *reportService) ResolveReport(ctx context.Context, op AdminOperator, req *v1.ResolveReportReq) error {
+	report, err := s.repo.GetReport(ctx, req.ReportId)
+	if err != nil {
+		if errors.Is(err, v1.ErrNotFound) {
+			return v1.ErrNotFound
+		}
+		s.logger.Error(err.Error(), zap.Any("reportId", req.ReportId))
+		return v1.ErrInternalServerError
+	}
+	if report.Status != contants.ReportStatusPending {
+		return v1.ErrReportHandled
+	}
+
+	// 屏蔽只适用于消息，禁言和封禁需要有被举报的用户
+	switch req.Action {
+	case contants.ReportActionHide:
+		if report.TargetType != contants.ReportTargetMsg {
+			return v1.ErrReportAction
+		}
+	case contants.ReportActionMute, contants.ReportActionBan:
+		if report.ReportedUserId == 0 {
+			return v1.ErrReportAction
+		}
+		if err = s.adminSrv.CheckUserOp(ctx, op, rbac.PermUserBan, report.ReportedUserId); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().Unix()
+	report.Status = contants.ReportStatusResolved
+	if req.Action == contants.ReportActionNone {
+		report.Status = contants.ReportStatusDismissed
+	}
+	report.Action = req.Action
+	report.HandlerId = req.HandlerId
+	report.Note = req.Note
+	report.HandledAt = now
+
+	if err = s.tm.Transaction(ctx, func(ctx context.Context) error {
+		ok, err := s.repo.HandleReport(ctx, report)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return v1.ErrReportHandled
+		}
+		return s.applyAction(ctx, report, req.MuteSeconds, now)
+	}); err != nil {
+		if errors.Is(err, v1.ErrReportHandled) {
+			return err
+		}
+		s.logger.Error(err.Error(), zap.Any("req", req))
+		return v1.ErrInternalServerError
+	}
+	s.logger.WithContext(ctx).Info("report resolved", zap.Int64("reportId", report.Id),
+		zap.Int64("handlerId", req.HandlerId), zap.Int("action", req.Action))
+	if req.Action == contants.ReportActionMute || req.Action == contants.ReportActionBan {
+		s.adminSrv.OpLog(ctx, op, rbac.PermUserBan, report.ReportedUserId, map[string]interface{}{
+			"reportId": report.Id, "action": req.Action, "muteSeconds": req.MuteSeconds, "note": req.Note,
+		})
+	}
+
+	if req.Action == contants.ReportActionBan {
+		if err = s.sessionSrv.RevokeAllSessions(ctx, report.ReportedUserId, ""); err != nil {
+			s.logger.Error(err.Error(), zap.Any("banned userId", report.ReportedUserId))
+		}
+	}
+	s.notifyReporter(report)
+	return nil
+}
+
+func (s *reportService) applyAction(ctx context.Context, report *model.Report, muteSeconds, now int64) error {
+	switch report.Action {
+	case contants.ReportActionHide:
+		return s.chatRepo.UpdateMsgStatus(ctx, report.TargetId, contants.MsgStatusBlocked)
+	case contants.ReportActionMute:
+		var until int64
+		if muteSeconds > 0 {
+			until = now + muteSeconds
+		}
+		return s.userRepo.UpdateUserInfoColumns(ctx, report.ReportedUserId, map[string]interface{}{
+			"silent_flag":  1,
+			"silent_until": until,
+		})
+	case contants.ReportActionBan:
+		return s.userRepo.UpdateUserInfoColumns(ctx, report.ReportedUserId, map[string]interface{}{
+			"status": contants.UserStatusAbnormal,
+		})
+	}
+	return nil
+}
+
+// 举报人不在线时只能通过举报列表查看结果
+func (s *reportService) notifyReporter(report *model.Report) {
+	data, err := json.Marshal(v1.ReportResultNotify{
+		ReportId:   report.Id,
+		TargetType: report.TargetType,
+		TargetId:   report.TargetId,
+		Status:     report.Status,
+		Action:     report.Action,
+	})
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("reportId", report.Id))
+		return
+	}
+	if err = s.wss.Push(ws.NotifyFrame(contants.NotifyEventReportResult, string(data)), report.ReporterId); err != nil {
+		s.logger.Error(err.Error(), zap.Any("reporterId", report.ReporterId))
+	}
+}
+
+func convertReport(r *model.Report) v1.ReportResp {
+	return v1.ReportResp{
+		Id:             r.Id,
+		ReporterId:     r.ReporterId,
+		TargetType:     r.TargetType,
+		TargetId:       r.TargetId,
+		ReportedUserId: r.ReportedUserId,
+		Reason:         r.Reason,
+		Description:    r.Description,
+		Content:        r.Content,
+		Status:         r.Status,
+		Action:         r.Action,
+		Note:           r.Note,
+		CreatedAt:      r.CreatedAt,
+		HandledAt:      r.HandledAt,
+	}
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	v1 "github.com/ljinf/im_server_standalone/api/v1"
+	"github.com/ljinf/im_server_standalone/internal/repository"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"time"
+)
+
+// 发送防刷：按用户和会话的令牌桶限流，注册不久的账号使用更严格的用户限制，
+// 窗口内在同一会话重复发送相同内容超过次数时拒绝。redis异常时放行，不影响正常发送
+type SendLimitService interface {
+	// 发送前调用，被限流时返回ErrSendTooFrequent或ErrDuplicateMsg
+	Check(ctx context.Context, req *v1.SendMsgReq) error
+}
+
+type tokenBucket struct {
+	rate  float64 //每秒补充的令牌数
+	burst int64   //桶容量，允许的突发条数
+}
+
+type sendLimitService struct {
+	*Service
+	repo          repository.SendLimitRepository
+	userRepo      repository.UserRepository
+	user          tokenBucket
+	conversation  tokenBucket
+	newAccount    tokenBucket
+	newAccountAge time.Duration //注册不足该时长的账号使用newAccount限制
+	dupWindow     time.Duration
+	dupMax        int64 //窗口内相同内容最多发送次数
+}
+
+func NewSendLimitService(s *Service, conf *viper.Viper, repo repository.SendLimitRepository, userRepo repository.UserRepository) SendLimitService {
+	srv := &sendLimitService{
+		Service:       s,
+		repo:          repo,
+		userRepo:      userRepo,
+		user:          readTokenBucket(conf, "chat.send_limit.user", tokenBucket{rate: 5, burst: 20}),
+		conversation:  readTokenBucket(conf, "chat.send_limit.conversation", tokenBucket{rate: 20, burst: 60}),
+		newAccount:    readTokenBucket(conf, "chat.send_limit.new_account", tokenBucket{rate: 0.5, burst: 5}),
+		newAccountAge: conf.GetDuration("chat.send_limit.new_account.age"),
+		dupWindow:     conf.GetDuration("chat.send_limit.duplicate.window"),
+		dupMax:        conf.GetInt64("chat.send_limit.duplicate.max"),
+	}
+	if srv.dupWindow <= 0 {
+		srv.dupWindow = time.Minute
+	}
+	if srv.dupMax <= 0 {
+		srv.dupMax = 3
+	}
+	return srv
+}
+
+func readTokenBucket(conf *viper.Viper, key string, def tokenBucket) tokenBucket {
+	b := tokenBucket{
+		rate:  conf.GetFloat64(key + ".rate"),
+		burst: conf.GetInt64(key + ".burst"),
+	}
+	if b.rate <= 0 {
+		b.rate = def.rate
+	}
+	if b.burst <= 0 {
+		b.burst = def.burst
+	}
+	return b
+}
+
+func (s *sendLimitService) Check(ctx context.Context, req *v1.SendMsgReq) error {
+	userBucket := s.user
+	if s.isNewAccount(ctx, req.UserId) {
+		userBucket = s.newAccount
+	}
+	if err := s.take(ctx, "send_user", fmt.Sprintf("%v", req.UserId), userBucket, req.UserId); err != nil {
+		return err
+	}
+
+	// 还没有会话的单聊按双方限制
+	conversationKey := fmt.Sprintf("%v", req.ConversationId)
+	if req.ConversationId == 0 {
+		a, b := req.UserId, req.TargetId
+		if a > b {
+			a, b = b, a
+		}
+		conversationKey = fmt.Sprintf("c2c:%v:%v", a, b)
+	}
+	if err := s.take(ctx, "send_conversation", conversationKey, s.conversation, req.UserId); err != nil {
+		return err
+	}
+
+	// 同一用户短时间内在同一会话重复发送相同内容，不同会话里的"好的"之类不算重复
+	sum := sha1.Sum([]byte(fmt.Sprintf("%v|%v|%v", conversationKey, req.ContentType, req.Content)))
+	count, err := s.repo.IncrDuplicateMsg(ctx, req.UserId, hex.EncodeToString(sum[:]), s.dupWindow)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", req.UserId))
+		return nil
+	}
+	if count > s.dupMax {
+		s.logger.Warn("duplicate msg rejected", zap.Any("userId", req.UserId), zap.Int64("count", count))
+		return v1.ErrDuplicateMsg
+	}
+	return nil
+}
+
+func (s *sendLimitService) take(ctx context.Context, name, key string, b tokenBucket, userId int64) error {
+	ok, wait, err := s.repo.TakeToken(ctx, name, key, b.rate, b.burst)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.String("name", name), zap.String("key", key))
+		return nil
+	}
+	if !ok {
+		s.logger.Warn("send rate limited", zap.String("name", name), zap.String("key", key),
+			zap.Any("userId", userId), zap.Duration("wait", wait))
+		return v1.ErrSendTooFrequent
+	}
+	return nil
+}
+
+func (s *sendLimitService) isNewAccount(ctx context.Context, userId int64) bool {
+	if s.newAccountAge <= 0 {
+		return false
+	}
+	info, err := s.userRepo.GetAccountInfoByID(ctx, userId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return false
+	}
+	return !info.RegisteredAt.IsZero() && time.Since(info.RegisteredAt) < s.newAccountAge
+}
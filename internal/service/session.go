@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	v1 "github.com/ljinf/im_server_standalone/api/v1"
+	"github.com/ljinf/im_server_standalone/internal/model"
+	"github.com/ljinf/im_server_standalone/internal/repository"
+	"github.com/ljinf/im_server_standalone/internal/ws"
+	"github.com/ljinf/im_server_standalone/pkg/contants"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+// 登录会话：短期access token + 每次刷新都轮换的refresh token
+type SessionService interface {
+	// session需填好UserId和设备信息
+	CreateSession(ctx context.Context, session *model.Session) (*v1.LoginResponseData, error)
+	RefreshSession(ctx context.Context, refreshToken string) (*v1.LoginResponseData, error)
+	// 吊销会话并关闭该会话的websocket连接
+	RevokeSession(ctx context.Context, userId int64, sessionId string) error
+	// 吊销用户除exceptSessionId外的所有会话，exceptSessionId为空则全部吊销
+	RevokeAllSessions(ctx context.Context, userId int64, exceptSessionId string) error
+	SessionActive(ctx context.Context, userId int64, sessionId string) (bool, error)
+	// 被封禁的用户不能登录，已登录的请求由认证中间件拒绝
+	UserBanned(ctx context.Context, userId int64) (bool, error)
+	// 设备管理
+	GetSessionList(ctx context.Context, userId int64, currentSessionId string) ([]v1.SessionResp, error)
+	RevokeUserSession(ctx context.Context, userId int64, sessionId string) error
+}
+
+type sessionService struct {
+	*Service
+	repo       repository.SessionRepository
+	userRepo   repository.UserRepository
+	wss        ws.SocketWsServer
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+func NewSessionService(s *Service, conf *viper.Viper, repo repository.SessionRepository, userRepo repository.UserRepository,
+	wss ws.SocketWsServer) SessionService {
+	srv := &sessionService{
+		Service:    s,
+		repo:       repo,
+		userRepo:   userRepo,
+		wss:        wss,
+		accessTTL:  conf.GetDuration("security.jwt.access_ttl"),
+		refreshTTL: conf.GetDuration("security.jwt.refresh_ttl"),
+	}
+	if srv.accessTTL <= 0 {
+		srv.accessTTL = 15 * time.Minute
+	}
+	if srv.refreshTTL <= 0 {
+		srv.refreshTTL = 30 * 24 * time.Hour
+	}
+	return srv
+}
+
+func (s *sessionService) CreateSession(ctx context.Context, session *model.Session) (*v1.LoginResponseData, error) {
+	banned, err := s.UserBanned(ctx, session.UserId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", session.UserId))
+		return nil, v1.ErrInternalServerError
+	}
+	if banned {
+		return nil, v1.ErrUserBanned
+	}
+
+	id, err := s.sid.GenUint64()
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", session.UserId))
+		return nil, v1.ErrInternalServerError
+	}
+	session.SessionId = fmt.Sprintf("%v", id)
+
+	refreshToken, err := genRefreshToken(session.SessionId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", session.UserId))
+		return nil, v1.ErrInternalServerError
+	}
+
+	now := time.Now().Unix()
+	session.RefreshHash = hashToken(refreshToken)
+	session.CreatedAt = now
+	session.RefreshedAt = now
+	session.LastSeen = now
+	if err = s.repo.CreateSession(ctx, session, s.refreshTTL); err != nil {
+		s.logger.Error(err.Error(), zap.Any("session", session))
+		return nil, v1.ErrInternalServerError
+	}
+	return s.issueTokens(session, refreshToken)
+}
+
+// refresh token只能使用一次，使用已轮换掉的token说明可能泄露，直接吊销整个会话
+func (s *sessionService) RefreshSession(ctx context.Context, refreshToken string) (*v1.LoginResponseData, error) {
+	sessionId, _, ok := strings.Cut(refreshToken, ".")
+	if !ok || sessionId == "" {
+		return nil, v1.ErrUnauthorized
+	}
+
+	newToken, err := genRefreshToken(sessionId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.String("sessionId", sessionId))
+		return nil, v1.ErrInternalServerError
+	}
+
+	session, err := s.repo.RotateSession(ctx, sessionId, hashToken(refreshToken), hashToken(newToken), s.refreshTTL)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrRefreshTokenReused):
+			s.logger.Warn("refresh token reused, revoke session", zap.String("sessionId", sessionId))
+			if session, err := s.repo.GetSession(ctx, sessionId); err == nil {
+				_ = s.RevokeSession(ctx, session.UserId, sessionId)
+			}
+			return nil, v1.ErrUnauthorized
+		case errors.Is(err, repository.ErrSessionNotFound), errors.Is(err, repository.ErrRefreshTokenInvalid):
+			return nil, v1.ErrUnauthorized
+		}
+		s.logger.Error(err.Error(), zap.String("sessionId", sessionId))
+		return nil, v1.ErrInternalServerError
+	}
+	return s.issueTokens(session, newToken)
+}
+
+func (s *sessionService) RevokeSession(ctx context.Context, userId int64, sessionId string) error {
+	if err := s.repo.DelSession(ctx, userId, sessionId); err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId), zap.String("sessionId", sessionId))
+		return v1.ErrInternalServerError
+	}
+
+	s.wss.CloseConn(userId, sessionId, websocket.CloseNormalClosure, "session revoked")
+	return nil
+}
+
+func (s *sessionService) RevokeAllSessions(ctx context.Context, userId int64, exceptSessionId string) error {
+	sessions, err := s.repo.SelectUserSessions(ctx, userId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return v1.ErrInternalServerError
+	}
+	for _, v := range sessions {
+		if v.SessionId == exceptSessionId {
+			continue
+		}
+		if err = s.RevokeSession(ctx, userId, v.SessionId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sessionService) SessionActive(ctx context.Context, userId int64, sessionId string) (bool, error) {
+	if sessionId == "" {
+		return false, nil
+	}
+	return s.repo.TouchSession(ctx, userId, sessionId)
+}
+
+// 用户资料有缓存，每次请求都检查开销不大
+func (s *sessionService) UserBanned(ctx context.Context, userId int64) (bool, error) {
+	info, err := s.userRepo.GetAccountInfoByID(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+	return info.UserId != 0 && info.Status == contants.UserStatusAbnormal, nil
+}
+
+func (s *sessionService) GetSessionList(ctx context.Context, userId int64, currentSessionId string) ([]v1.SessionResp, error) {
+	sessions, err := s.repo.SelectUserSessions(ctx, userId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return nil, v1.ErrInternalServerError
+	}
+
+	onlineSessionId := s.wss.OnlineSessionId(userId)
+	list := make([]v1.SessionResp, 0, len(sessions))
+	for _, v := range sessions {
+		list = append(list, v1.SessionResp{
+			SessionId: v.SessionId,
+			Device:    v.Device,
+			Platform:  v.Platform,
+			Ip:        v.Ip,
+			CreatedAt: v.CreatedAt,
+			LastSeen:  v.LastSeen,
+			Online:    onlineSessionId != "" && onlineSessionId == v.SessionId,
+			Current:   v.SessionId == currentSessionId,
+		})
+	}
+	return list, nil
+}
+
+// 只能吊销自己的会话
+func (s *sessionService) RevokeUserSession(ctx context.Context, userId int64, sessionId string) error {
+	session, err := s.repo.GetSession(ctx, sessionId)
+	if err != nil {
+		if errors.Is(err, repository.ErrSessionNotFound) {
+			return v1.ErrNotFound
+		}
+		s.logger.Error(err.Error(), zap.Any("userId", userId), zap.String("sessionId", sessionId))
+		return v1.ErrInternalServerError
+	}
+	if session.UserId != userId {
+		return v1.ErrNotFound
+	}
+	return s.RevokeSession(ctx, userId, sessionId)
+}
+
+func (s *sessionService) issueTokens(session *model.Session, refreshToken string) (*v1.LoginResponseData, error) {
+	accessToken, err := s.jwt.GenToken(session.UserId, session.SessionId, time.Now().Add(s.accessTTL))
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", session.UserId))
+		return nil, v1.ErrInternalServerError
+	}
+	return &v1.LoginResponseData{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(s.accessTTL.Seconds()),
+	}, nil
+}
+
+// 格式 sessionId.随机串，服务端只保存哈希
+func genRefreshToken(sessionId string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return sessionId + "." + hex.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -2,35 +2,121 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	v1 "github.com/ljinf/im_server_standalone/api/v1"
 	"github.com/ljinf/im_server_standalone/internal/model"
 	"github.com/ljinf/im_server_standalone/internal/repository"
+	"github.com/ljinf/im_server_standalone/internal/ws"
+	"github.com/ljinf/im_server_standalone/pkg/contants"
+	"github.com/ljinf/im_server_standalone/pkg/sender"
+	"github.com/ljinf/im_server_standalone/pkg/token"
+	"github.com/spf13/viper"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
+	"strings"
 	"time"
+	"unicode/utf8"
+)
+
+// 昵称搜索每次最多返回的数量
+const searchUserLimit = 20
+
+// 签名token的用途
+const (
+	tokenPurposeVerifyEmail   = "verify_email"
+	tokenPurposeResetPassword = "reset_password"
 )
 
 type UserService interface {
 	Register(ctx context.Context, req *v1.RegisterRequest) error
-	Login(ctx context.Context, req *v1.LoginRequest) (string, error)
+	Login(ctx context.Context, req *v1.LoginRequest) (*v1.LoginResponseData, error)
+	// 手机号验证码注册和登录
+	SendCode(ctx context.Context, req *v1.SendCodeRequest) error
+	// 发送验证码到当前账号已绑定的手机号
+	SendAccountCode(ctx context.Context, userId int64, req *v1.SendAccountCodeRequest) error
+	RegisterByPhone(ctx context.Context, req *v1.PhoneRegisterRequest) error
+	LoginByPhone(ctx context.Context, req *v1.PhoneLoginRequest) (*v1.LoginResponseData, error)
+	RefreshToken(ctx context.Context, req *v1.RefreshTokenRequest) (*v1.LoginResponseData, error)
+	Logout(ctx context.Context, userId int64, sessionId string) error
+	// 登录设备管理
+	GetSessionList(ctx context.Context, userId int64, currentSessionId string) ([]v1.SessionResp, error)
+	RevokeSession(ctx context.Context, userId int64, sessionId string) error
 	// 用户信息
 	GetProfile(ctx context.Context, userId int64) (*v1.GetProfileResponseData, error)
+	// 查看他人资料
+	GetUserProfile(ctx context.Context, viewerId, userId int64) (*v1.GetUserProfileResponseData, error)
 	UpdateProfile(ctx context.Context, userId int64, req *v1.UpdateProfileRequest) error
-	// 更新注册表
-	UpdateRegisterInfo(ctx context.Context, userId int64, req *v1.UpdateRegisterInfoRequest) error
+	// 搜索用户，邮箱手机号精确匹配，昵称前缀匹配
+	SearchUser(ctx context.Context, viewerId int64, keyword string) ([]v1.SearchUserItem, error)
+	// 隐私设置
+	GetPrivacy(ctx context.Context, userId int64) (*v1.GetPrivacyResponseData, error)
+	UpdatePrivacy(ctx context.Context, userId int64, req *v1.UpdatePrivacyRequest) error
+	// 更新注册表，修改密码会吊销sessionId以外的会话
+	UpdateRegisterInfo(ctx context.Context, userId int64, sessionId string, req *v1.UpdateRegisterInfoRequest) error
+	// 邮箱验证
+	SendVerifyEmail(ctx context.Context, userId int64) error
+	VerifyEmail(ctx context.Context, req *v1.VerifyEmailRequest) error
+	IsVerified(ctx context.Context, userId int64) (bool, error)
+	// 找回密码
+	ForgotPassword(ctx context.Context, req *v1.ForgotPasswordRequest) error
+	ResetPassword(ctx context.Context, req *v1.ResetPasswordRequest) error
 }
 
 type userService struct {
-	userRepo repository.UserRepository
+	userRepo     repository.UserRepository
+	relationRepo repository.RelationshipRepository
+	wss          ws.SocketWsServer
+	sessionSrv   SessionService
+	verifySrv    VerifyCodeService
+	loginGuard   LoginGuardService
+	signer       *token.Signer
+	sender       sender.Sender
+
+	verifyEmailURL   string //验证邮箱的页面地址，后面拼接token
+	verifyEmailTTL   time.Duration
+	resetPasswordURL string //重置密码的页面地址，后面拼接token
+	resetPasswordTTL time.Duration
+	customFields     map[string]int //自定义资料字段名 -> 最大长度
 	*Service
 }
 
-func NewUserService(service *Service, userRepo repository.UserRepository) UserService {
-	return &userService{
-		userRepo: userRepo,
-		Service:  service,
+func NewUserService(service *Service, conf *viper.Viper, userRepo repository.UserRepository, relationRepo repository.RelationshipRepository,
+	wss ws.SocketWsServer, sessionSrv SessionService, verifySrv VerifyCodeService, loginGuard LoginGuardService, signer *token.Signer, sender sender.Sender) UserService {
+	srv := &userService{
+		userRepo:         userRepo,
+		relationRepo:     relationRepo,
+		wss:              wss,
+		sessionSrv:       sessionSrv,
+		verifySrv:        verifySrv,
+		loginGuard:       loginGuard,
+		signer:           signer,
+		sender:           sender,
+		verifyEmailURL:   conf.GetString("account.verify_email_url"),
+		verifyEmailTTL:   conf.GetDuration("account.verify_email_ttl"),
+		resetPasswordURL: conf.GetString("account.reset_password_url"),
+		resetPasswordTTL: conf.GetDuration("account.reset_password_ttl"),
+		Service:          service,
+	}
+	if srv.verifyEmailTTL <= 0 {
+		srv.verifyEmailTTL = 24 * time.Hour
+	}
+	if srv.resetPasswordTTL <= 0 {
+		srv.resetPasswordTTL = 30 * time.Minute
+	}
+
+	var customFields []profileFieldConfig
+	if err := conf.UnmarshalKey("profile.custom_fields", &customFields); err != nil {
+		service.logger.Error("profile.custom_fields config error", zap.Error(err))
 	}
+	srv.customFields = make(map[string]int, len(customFields))
+	for _, v := range customFields {
+		if v.MaxLength <= 0 {
+			v.MaxLength = defaultProfileFieldLength
+		}
+		srv.customFields[v.Key] = v.MaxLength
+	}
+	return srv
 }
 
 func (s *userService) Register(ctx context.Context, req *v1.RegisterRequest) error {
@@ -67,95 +153,628 @@ func (s *userService) Register(ctx context.Context, req *v1.RegisterRequest) err
 		s.logger.Error(err.Error(), zap.Any("accountInfo", account))
 		return v1.ErrInternalServerError
 	}
+
+	// 发送失败不影响注册，可以登录后重新发送
+	if err = s.sendVerifyEmail(ctx, account.UserId, account.Email); err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", account.UserId))
+	}
 	return nil
 }
 
-func (s *userService) Login(ctx context.Context, req *v1.LoginRequest) (string, error) {
+func (s *userService) Login(ctx context.Context, req *v1.LoginRequest) (*v1.LoginResponseData, error) {
+	if err := s.loginGuard.Check(ctx, req.Email, req.Ip); err != nil {
+		return nil, err
+	}
+
+	// 账号不存在也计入失败次数，避免通过锁定行为探测账号
 	info, err := s.userRepo.GetByEmail(ctx, req.Email)
 	if err != nil || info == nil {
-		return "", v1.ErrUnauthorized
+		s.loginGuard.Fail(ctx, req.Email, req.Ip)
+		return nil, v1.ErrUnauthorized
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(info.Password), []byte(req.Password))
 	if err != nil {
-		return "", v1.ErrPasswordFailed
+		s.loginGuard.Fail(ctx, req.Email, req.Ip)
+		return nil, v1.ErrPasswordFailed
 	}
+	s.loginGuard.Success(ctx, req.Email, req.Ip, info.UserId)
+
+	return s.sessionSrv.CreateSession(ctx, &model.Session{
+		UserId:    info.UserId,
+		Device:    req.Device,
+		Platform:  req.Platform,
+		Ip:        req.Ip,
+		UserAgent: req.UserAgent,
+	})
+}
 
-	token, err := s.jwt.GenToken(info.UserId, time.Now().Add(time.Hour*24*90))
+// 注册场景手机号已注册时报错；登录场景手机号未注册时不发送，但同样返回成功，避免被用来探测手机号
+func (s *userService) SendCode(ctx context.Context, req *v1.SendCodeRequest) error {
+	user, err := s.userRepo.GetByPhone(ctx, req.Phone)
 	if err != nil {
-		return "", err
+		s.logger.Error(err.Error(), zap.Any("GetByPhone", req))
+		return v1.ErrInternalServerError
 	}
 
-	return token, nil
+	switch req.Scene {
+	case contants.VerifySceneRegister:
+		if user != nil {
+			return v1.ErrPhoneAlreadyUse
+		}
+	case contants.VerifySceneLogin:
+		if user == nil {
+			return nil
+		}
+	}
+	return s.verifySrv.SendCode(ctx, contants.VerifyChannelSMS, req.Scene, req.Phone)
 }
 
-func (s *userService) UpdateRegisterInfo(ctx context.Context, userId int64, req *v1.UpdateRegisterInfoRequest) error {
-	info, err := s.userRepo.GetByEmail(ctx, req.Email)
+func (s *userService) SendAccountCode(ctx context.Context, userId int64, req *v1.SendAccountCodeRequest) error {
+	register, err := s.userRepo.GetRegisterByID(ctx, userId)
 	if err != nil {
-		return v1.ErrUnauthorized
+		if errors.Is(err, v1.ErrNotFound) {
+			return v1.ErrUnauthorized
+		}
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return v1.ErrInternalServerError
 	}
+	if register.Phone == "" {
+		return v1.ErrBadRequest
+	}
+	return s.verifySrv.SendCode(ctx, contants.VerifyChannelSMS, req.Scene, register.Phone)
+}
 
-	if info != nil {
-		return v1.ErrEmailAlreadyUse
+func (s *userService) RegisterByPhone(ctx context.Context, req *v1.PhoneRegisterRequest) error {
+	if err := s.verifySrv.CheckCode(ctx, contants.VerifySceneRegister, req.Phone, req.Code); err != nil {
+		return err
 	}
 
+	user, err := s.userRepo.GetByPhone(ctx, req.Phone)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("GetByPhone", req.Phone))
+		return v1.ErrInternalServerError
+	}
+	if user != nil {
+		return v1.ErrPhoneAlreadyUse
+	}
+
+	var password string
 	if req.Password != "" {
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 		if err != nil {
 			s.logger.Error(fmt.Sprintf("GenerateFromPassword %v", err))
 			return v1.ErrGenerateFromPassword
 		}
-		req.Password = string(hashedPassword)
+		password = string(hashedPassword)
 	}
 
-	registerInfo := &model.Register{
-		UserId:   userId,
-		Email:    req.Email,
-		Phone:    req.Phone,
-		Password: req.Password,
+	userId, err := s.sid.GenUint64()
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("GenerateUserID %v", err))
+		return v1.ErrGenerateUserID
 	}
 
-	if err = s.userRepo.UpdateRegister(ctx, registerInfo); err != nil {
-		s.logger.Error(err.Error(), zap.Any("registerInfo", registerInfo))
+	account := &model.AccountInfo{
+		UserId:        int64(userId),
+		Phone:         req.Phone,
+		Password:      password,
+		PhoneVerified: true,
+	}
+	if err = s.userRepo.CreateRegister(ctx, account); err != nil {
+		s.logger.Error(err.Error(), zap.Any("accountInfo", account.UserId))
 		return v1.ErrInternalServerError
 	}
 	return nil
 }
 
+func (s *userService) LoginByPhone(ctx context.Context, req *v1.PhoneLoginRequest) (*v1.LoginResponseData, error) {
+	if err := s.verifySrv.CheckCode(ctx, contants.VerifySceneLogin, req.Phone, req.Code); err != nil {
+		return nil, err
+	}
+
+	info, err := s.userRepo.GetByPhone(ctx, req.Phone)
+	if err != nil || info == nil {
+		return nil, v1.ErrUnauthorized
+	}
+
+	// 能收到验证码也就验证了手机号
+	if !info.PhoneVerified {
+		if err = s.userRepo.UpdateRegisterColumns(ctx, info.UserId, map[string]interface{}{"phone_verified": true}); err != nil {
+			s.logger.Error(err.Error(), zap.Any("userId", info.UserId))
+		}
+	}
+
+	return s.sessionSrv.CreateSession(ctx, &model.Session{
+		UserId:    info.UserId,
+		Device:    req.Device,
+		Platform:  req.Platform,
+		Ip:        req.Ip,
+		UserAgent: req.UserAgent,
+	})
+}
+
+func (s *userService) RefreshToken(ctx context.Context, req *v1.RefreshTokenRequest) (*v1.LoginResponseData, error) {
+	return s.sessionSrv.RefreshSession(ctx, req.RefreshToken)
+}
+
+func (s *userService) Logout(ctx context.Context, userId int64, sessionId string) error {
+	return s.sessionSrv.RevokeSession(ctx, userId, sessionId)
+}
+
+func (s *userService) GetSessionList(ctx context.Context, userId int64, currentSessionId string) ([]v1.SessionResp, error) {
+	return s.sessionSrv.GetSessionList(ctx, userId, currentSessionId)
+}
+
+func (s *userService) RevokeSession(ctx context.Context, userId int64, sessionId string) error {
+	return s.sessionSrv.RevokeUserSession(ctx, userId, sessionId)
+}
+
+// 修改邮箱、手机号、密码需要校验当前密码，未设置过密码的校验已绑定手机号的验证码，修改密码后其他设备需要重新登录
+func (s *userService) UpdateRegisterInfo(ctx context.Context, userId int64, sessionId string, req *v1.UpdateRegisterInfoRequest) error {
+	register, err := s.userRepo.GetRegisterByID(ctx, userId)
+	if err != nil {
+		if errors.Is(err, v1.ErrNotFound) {
+			return v1.ErrUnauthorized
+		}
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return v1.ErrInternalServerError
+	}
+
+	// 手机号注册且未设置过密码的账号没有可校验的密码，只凭access token不能修改
+	if register.Password != "" {
+		if err = bcrypt.CompareHashAndPassword([]byte(register.Password), []byte(req.OldPassword)); err != nil {
+			return v1.ErrPasswordFailed
+		}
+	} else if err = checkAccountCode(ctx, s.verifySrv, register, contants.VerifySceneUpdateAccount, req.Code); err != nil {
+		return err
+	}
+
+	columns := make(map[string]interface{})
+	if req.Email != "" && req.Email != register.Email {
+		info, err := s.userRepo.GetByEmail(ctx, req.Email)
+		if err != nil {
+			s.logger.Error(err.Error(), zap.Any("GetByEmail", req.Email))
+			return v1.ErrInternalServerError
+		}
+		if info != nil {
+			return v1.ErrEmailAlreadyUse
+		}
+		columns["email"] = req.Email
+		columns["email_verified"] = false
+	}
+
+	if req.Phone != "" && req.Phone != register.Phone {
+		info, err := s.userRepo.GetByPhone(ctx, req.Phone)
+		if err != nil {
+			s.logger.Error(err.Error(), zap.Any("GetByPhone", req.Phone))
+			return v1.ErrInternalServerError
+		}
+		if info != nil {
+			return v1.ErrPhoneAlreadyUse
+		}
+		columns["phone"] = req.Phone
+		columns["phone_verified"] = false
+	}
+
+	if req.Password != "" {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			s.logger.Error(fmt.Sprintf("GenerateFromPassword %v", err))
+			return v1.ErrGenerateFromPassword
+		}
+		columns["password"] = string(hashedPassword)
+	}
+
+	if len(columns) == 0 {
+		return nil
+	}
+	if err = s.userRepo.UpdateRegisterColumns(ctx, userId, columns); err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return v1.ErrInternalServerError
+	}
+
+	if _, ok := columns["email"]; ok {
+		if err = s.sendVerifyEmail(ctx, userId, req.Email); err != nil {
+			s.logger.Error(err.Error(), zap.Any("userId", userId))
+		}
+	}
+	if _, ok := columns["password"]; ok {
+		return s.sessionSrv.RevokeAllSessions(ctx, userId, sessionId)
+	}
+	return nil
+}
+
+// 未设置过密码的账号校验发送到已绑定手机号的验证码
+func checkAccountCode(ctx context.Context, verifySrv VerifyCodeService, register *model.Register, scene, code string) error {
+	if register.Phone == "" || code == "" {
+		return v1.ErrVerifyCodeInvalid
+	}
+	return verifySrv.CheckCode(ctx, scene, register.Phone, code)
+}
+
+func (s *userService) SendVerifyEmail(ctx context.Context, userId int64) error {
+	register, err := s.userRepo.GetRegisterByID(ctx, userId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return v1.ErrInternalServerError
+	}
+	if register.Email == "" || register.EmailVerified {
+		return v1.ErrBadRequest
+	}
+	if err = s.sendVerifyEmail(ctx, userId, register.Email); err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return v1.ErrInternalServerError
+	}
+	return nil
+}
+
+// 邮箱变更后旧链接失效
+func (s *userService) VerifyEmail(ctx context.Context, req *v1.VerifyEmailRequest) error {
+	userId, err := s.signer.Verify(req.Token, tokenPurposeVerifyEmail, func(userId int64) (string, error) {
+		register, err := s.userRepo.GetRegisterByID(ctx, userId)
+		if err != nil {
+			return "", err
+		}
+		return register.Email, nil
+	})
+	if err != nil {
+		if errors.Is(err, token.ErrInvalid) || errors.Is(err, v1.ErrNotFound) {
+			return v1.ErrTokenInvalid
+		}
+		s.logger.Error(err.Error())
+		return v1.ErrInternalServerError
+	}
+
+	if err = s.userRepo.UpdateRegisterColumns(ctx, userId, map[string]interface{}{"email_verified": true}); err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return v1.ErrInternalServerError
+	}
+	return nil
+}
+
+// 邮箱未注册时同样返回成功，避免被用来探测邮箱
+func (s *userService) ForgotPassword(ctx context.Context, req *v1.ForgotPasswordRequest) error {
+	register, err := s.userRepo.GetByEmail(ctx, req.Email)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("GetByEmail", req.Email))
+		return v1.ErrInternalServerError
+	}
+	if register == nil {
+		return nil
+	}
+
+	// 以当前密码哈希签名，密码重置后链接即失效
+	link := s.resetPasswordURL + s.signer.Sign(tokenPurposeResetPassword, register.UserId, register.Password, s.resetPasswordTTL)
+	content := fmt.Sprintf("您正在找回密码，请在%v分钟内点击以下链接设置新密码，如非本人操作请忽略。\n%v",
+		int(s.resetPasswordTTL.Minutes()), link)
+	if err = s.sender.SendEmail(ctx, register.Email, "找回密码", content); err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", register.UserId))
+		return v1.ErrInternalServerError
+	}
+	return nil
+}
+
+// 重置后所有设备需要重新登录；能收到邮件也就验证了邮箱
+func (s *userService) ResetPassword(ctx context.Context, req *v1.ResetPasswordRequest) error {
+	userId, err := s.signer.Verify(req.Token, tokenPurposeResetPassword, func(userId int64) (string, error) {
+		register, err := s.userRepo.GetRegisterByID(ctx, userId)
+		if err != nil {
+			return "", err
+		}
+		return register.Password, nil
+	})
+	if err != nil {
+		if errors.Is(err, token.ErrInvalid) || errors.Is(err, v1.ErrNotFound) {
+			return v1.ErrTokenInvalid
+		}
+		s.logger.Error(err.Error())
+		return v1.ErrInternalServerError
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("GenerateFromPassword %v", err))
+		return v1.ErrGenerateFromPassword
+	}
+	if err = s.userRepo.UpdateRegisterColumns(ctx, userId, map[string]interface{}{
+		"password":       string(hashedPassword),
+		"email_verified": true,
+	}); err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return v1.ErrInternalServerError
+	}
+	return s.sessionSrv.RevokeAllSessions(ctx, userId, "")
+}
+
+// 邮箱或手机号任一已验证
+func (s *userService) IsVerified(ctx context.Context, userId int64) (bool, error) {
+	user, err := s.userRepo.GetAccountInfoByID(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+	return user.EmailVerified || user.PhoneVerified, nil
+}
+
+func (s *userService) sendVerifyEmail(ctx context.Context, userId int64, email string) error {
+	link := s.verifyEmailURL + s.signer.Sign(tokenPurposeVerifyEmail, userId, email, s.verifyEmailTTL)
+	content := fmt.Sprintf("请在%v小时内点击以下链接验证您的邮箱，如非本人操作请忽略。\n%v", int(s.verifyEmailTTL.Hours()), link)
+	return s.sender.SendEmail(ctx, email, "验证邮箱", content)
+}
+
 func (s *userService) GetProfile(ctx context.Context, userId int64) (*v1.GetProfileResponseData, error) {
 	user, err := s.userRepo.GetAccountInfoByID(ctx, userId)
 	if err != nil {
 		return nil, err
 	}
+	fields, err := s.userRepo.SelectProfileFields(ctx, userId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return nil, v1.ErrInternalServerError
+	}
+
+	customFields := make(map[string]v1.ProfileField, len(fields))
+	for _, v := range fields {
+		customFields[v.FieldKey] = v1.ProfileField{Value: v.Value, Visibility: v.Visibility}
+	}
 
 	return &v1.GetProfileResponseData{
+		UserId:        user.UserId,
+		NickName:      user.NickName,
+		Phone:         user.Phone,
+		Email:         user.Email,
+		Avatar:        user.Avatar,
+		Gender:        user.Gender,
+		EmailVerified: user.EmailVerified,
+		PhoneVerified: user.PhoneVerified,
+		Signature:     v1.ProfileField{Value: user.Signature, Visibility: profileVisibility(user.SignatureVisibility)},
+		Birthday:      v1.ProfileField{Value: user.Birthday, Visibility: profileVisibility(user.BirthdayVisibility)},
+		Region:        v1.ProfileField{Value: user.Region, Visibility: profileVisibility(user.RegionVisibility)},
+		CustomFields:  customFields,
+	}, nil
+}
+
+func (s *userService) GetUserProfile(ctx context.Context, viewerId, userId int64) (*v1.GetUserProfileResponseData, error) {
+	// 存在拉黑关系时当作用户不存在
+	if err := checkBlocked(ctx, s.Service, s.relationRepo, viewerId, userId); err != nil {
+		if errors.Is(err, v1.ErrBlocked) {
+			return nil, v1.ErrNotFound
+		}
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetAccountInfoByID(ctx, userId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return nil, v1.ErrInternalServerError
+	}
+	if user.UserId == 0 {
+		return nil, v1.ErrNotFound
+	}
+
+	resp := &v1.GetUserProfileResponseData{
 		UserId:   user.UserId,
 		NickName: user.NickName,
-		Phone:    user.Phone,
-		Email:    user.Email,
 		Avatar:   user.Avatar,
 		Gender:   user.Gender,
-	}, nil
+		Online:   s.wss.IsOnline(userId),
+		Deleted:  user.Status == contants.UserStatusDeleted,
+	}
+	if resp.Deleted {
+		return resp, nil
+	}
+
+	level, err := s.profileViewLevel(ctx, viewerId, userId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("viewerId", viewerId), zap.Any("userId", userId))
+		return nil, v1.ErrInternalServerError
+	}
+	resp.Signature = visibleProfileValue(user.Signature, user.SignatureVisibility, level)
+	resp.Birthday = visibleProfileValue(user.Birthday, user.BirthdayVisibility, level)
+	resp.Region = visibleProfileValue(user.Region, user.RegionVisibility, level)
+
+	fields, err := s.userRepo.SelectProfileFields(ctx, userId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return nil, v1.ErrInternalServerError
+	}
+	for _, v := range fields {
+		// 已从配置中移除的字段不再展示
+		if _, ok := s.customFields[v.FieldKey]; !ok {
+			continue
+		}
+		if value := visibleProfileValue(v.Value, v.Visibility, level); value != "" {
+			if resp.CustomFields == nil {
+				resp.CustomFields = make(map[string]string)
+			}
+			resp.CustomFields[v.FieldKey] = value
+		}
+	}
+	return resp, nil
 }
 
 func (s *userService) UpdateProfile(ctx context.Context, userId int64, req *v1.UpdateProfileRequest) error {
-	user, err := s.userRepo.GetByID(ctx, userId)
-	if err != nil {
+	columns := make(map[string]interface{})
+	if req.NickName != "" {
+		columns["nick_name"] = req.NickName
+	}
+	if req.Avatar != "" {
+		columns["avatar"] = req.Avatar
+	}
+	if req.Gender != 0 {
+		columns["gender"] = req.Gender
+	}
+	if err := profileSignature.apply(columns, req.Signature); err != nil {
+		return err
+	}
+	if err := profileBirthday.apply(columns, req.Birthday); err != nil {
+		return err
+	}
+	if err := profileRegion.apply(columns, req.Region); err != nil {
 		return err
 	}
 
-	user.Avatar = req.Avatar
-	user.NickName = req.NickName
+	fields, delKeys, err := s.buildCustomFields(userId, req.CustomFields)
+	if err != nil {
+		return err
+	}
 
-	if user.Gender == 3 {
-		if req.Gender == 1 || req.Gender == 2 {
-			user.Gender = req.Gender
+	if err = s.tm.Transaction(ctx, func(ctx context.Context) error {
+		if len(columns) > 0 {
+			if err := s.userRepo.UpdateUserInfoColumns(ctx, userId, columns); err != nil {
+				return err
+			}
 		}
+		return s.userRepo.SaveProfileFields(ctx, userId, fields, delKeys)
+	}); err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return v1.ErrInternalServerError
 	}
+	return nil
+}
 
-	if err = s.userRepo.UpdateUserInfo(ctx, user); err != nil {
-		return err
+func (s *userService) GetPrivacy(ctx context.Context, userId int64) (*v1.GetPrivacyResponseData, error) {
+	user, err := s.userRepo.GetByID(ctx, userId)
+	if err != nil {
+		return nil, err
 	}
+	return &v1.GetPrivacyResponseData{
+		MsgAllowType: user.MsgAllowType,
+		Discoverable: user.Discoverable,
+	}, nil
+}
 
+func (s *userService) UpdatePrivacy(ctx context.Context, userId int64, req *v1.UpdatePrivacyRequest) error {
+	columns := map[string]interface{}{
+		"updated_at": time.Now(),
+	}
+	if req.MsgAllowType != nil {
+		columns["msg_allow_type"] = *req.MsgAllowType
+	}
+	if req.Discoverable != nil {
+		columns["discoverable"] = *req.Discoverable
+	}
+	if err := s.userRepo.UpdateUserInfoColumns(ctx, userId, columns); err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId), zap.Any("req", req))
+		return v1.ErrInternalServerError
+	}
 	return nil
 }
+
+func (s *userService) SearchUser(ctx context.Context, viewerId int64, keyword string) ([]v1.SearchUserItem, error) {
+	keyword = strings.TrimSpace(keyword)
+
+	var (
+		users []model.UserInfo
+		err   error
+	)
+	switch {
+	case strings.Contains(keyword, "@"):
+		users, err = s.searchByRegister(ctx, keyword, contants.DiscoverByEmail, s.userRepo.GetByEmail)
+	case isPhone(keyword):
+		users, err = s.searchByRegister(ctx, keyword, contants.DiscoverByPhone, s.userRepo.GetByPhone)
+	default:
+		// 太短的前缀等同于枚举
+		if utf8.RuneCountInString(keyword) < 2 {
+			return nil, v1.ErrBadRequest
+		}
+		users, err = s.userRepo.SearchByNickName(ctx, keyword, searchUserLimit)
+	}
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("viewerId", viewerId), zap.String("keyword", keyword))
+		return nil, v1.ErrInternalServerError
+	}
+
+	list := make([]v1.SearchUserItem, 0, len(users))
+	for _, v := range users {
+		if v.UserId == viewerId {
+			continue
+		}
+		relation, visible, err := s.userRelation(ctx, viewerId, v.UserId)
+		if err != nil {
+			s.logger.Error(err.Error(), zap.Any("viewerId", viewerId), zap.Any("userId", v.UserId))
+			return nil, v1.ErrInternalServerError
+		}
+		if !visible {
+			continue
+		}
+		list = append(list, v1.SearchUserItem{
+			UserId:       v.UserId,
+			NickName:     v.NickName,
+			Avatar:       v.Avatar,
+			Gender:       v.Gender,
+			Relationship: relation,
+		})
+	}
+	return list, nil
+}
+
+// 按注册表的邮箱或手机号精确查找，对方关闭了该方式的搜索时当作不存在
+func (s *userService) searchByRegister(ctx context.Context, keyword string, discoverBy int,
+	getRegister func(ctx context.Context, keyword string) (*model.Register, error)) ([]model.UserInfo, error) {
+	register, err := getRegister(ctx, keyword)
+	if err != nil || register == nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetByID(ctx, register.UserId)
+	if err != nil {
+		if errors.Is(err, v1.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	// 未验证的邮箱可能不属于该用户，不能用来搜索
+	if discoverBy == contants.DiscoverByEmail && !register.EmailVerified {
+		return nil, nil
+	}
+	if user.Status != contants.UserStatusNormal || user.Discoverable&discoverBy == 0 {
+		return nil, nil
+	}
+	return []model.UserInfo{*user}, nil
+}
+
+// 与viewerId的关系，对方拉黑了viewerId时不可见
+func (s *userService) userRelation(ctx context.Context, viewerId, userId int64) (int, bool, error) {
+	blocked, err := s.relationRepo.IsBlocked(ctx, userId, viewerId)
+	if err != nil || blocked {
+		return contants.UserRelationNone, false, err
+	}
+	if blocked, err = s.relationRepo.IsBlocked(ctx, viewerId, userId); err != nil {
+		return contants.UserRelationNone, false, err
+	}
+	if blocked {
+		return contants.UserRelationBlocked, true, nil
+	}
+
+	friend, err := s.relationRepo.SelectRelationshipOne(ctx, viewerId, userId, contants.RelationshipTypeFriend)
+	if err != nil && !errors.Is(err, v1.ErrNotFound) {
+		return contants.UserRelationNone, false, err
+	}
+	if friend != nil && friend.Status == contants.RelationshipStatusNormal {
+		return contants.UserRelationFriend, true, nil
+	}
+
+	apply, err := s.relationRepo.SelectApplyOne(ctx, viewerId, userId)
+	if err != nil && !errors.Is(err, v1.ErrNotFound) {
+		return contants.UserRelationNone, false, err
+	}
+	if apply != nil && (apply.Status == contants.ApplyFriendshipStatusApplying ||
+		apply.Status == contants.ApplyFriendshipStatusPending) {
+		return contants.UserRelationPending, true, nil
+	}
+	return contants.UserRelationNone, true, nil
+}
+
+// 11位数字按手机号处理
+func isPhone(keyword string) bool {
+	if len(keyword) != 11 {
+		return false
+	}
+	for _, c := range keyword {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	v1 "github.com/ljinf/im_server_standalone/api/v1"
+	"github.com/ljinf/im_server_standalone/internal/repository"
+	"github.com/ljinf/im_server_standalone/pkg/contants"
+	"github.com/ljinf/im_server_standalone/pkg/sender"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"math/big"
+	"time"
+)
+
+// 一次性验证码，校验成功即失效
+type VerifyCodeService interface {
+	SendCode(ctx context.Context, channel, scene, target string) error
+	CheckCode(ctx context.Context, scene, target, code string) error
+}
+
+type verifyCodeService struct {
+	*Service
+	repo        repository.VerifyCodeRepository
+	sender      sender.Sender
+	length      int
+	ttl         time.Duration
+	cooldown    time.Duration //同一场景同一目标的发送间隔
+	maxAttempts int           //错误次数达到后验证码失效
+	dailyLimit  int64         //同一目标每天最多发送次数
+}
+
+func NewVerifyCodeService(s *Service, conf *viper.Viper, repo repository.VerifyCodeRepository, sender sender.Sender) VerifyCodeService {
+	srv := &verifyCodeService{
+		Service:     s,
+		repo:        repo,
+		sender:      sender,
+		length:      conf.GetInt("verify_code.length"),
+		ttl:         conf.GetDuration("verify_code.ttl"),
+		cooldown:    conf.GetDuration("verify_code.cooldown"),
+		maxAttempts: conf.GetInt("verify_code.max_attempts"),
+		dailyLimit:  conf.GetInt64("verify_code.daily_limit"),
+	}
+	if srv.length <= 0 {
+		srv.length = 6
+	}
+	if srv.ttl <= 0 {
+		srv.ttl = 5 * time.Minute
+	}
+	if srv.cooldown <= 0 {
+		srv.cooldown = time.Minute
+	}
+	if srv.maxAttempts <= 0 {
+		srv.maxAttempts = 5
+	}
+	if srv.dailyLimit <= 0 {
+		srv.dailyLimit = 10
+	}
+	return srv
+}
+
+func (s *verifyCodeService) SendCode(ctx context.Context, channel, scene, target string) error {
+	code, err := genVerifyCode(s.length)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return v1.ErrInternalServerError
+	}
+
+	ok, err := s.repo.CreateCode(ctx, scene, target, code, s.ttl, s.cooldown)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.String("scene", scene), zap.String("target", target))
+		return v1.ErrInternalServerError
+	}
+	if !ok {
+		return v1.ErrVerifyCodeFrequent
+	}
+
+	count, err := s.repo.IncrSendCount(ctx, target, 24*time.Hour)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.String("target", target))
+		return v1.ErrInternalServerError
+	}
+	if count > s.dailyLimit {
+		return v1.ErrVerifyCodeFrequent
+	}
+
+	content := fmt.Sprintf("您的验证码是%v，%v分钟内有效，请勿泄露给他人。", code, int(s.ttl.Minutes()))
+	switch channel {
+	case contants.VerifyChannelEmail:
+		err = s.sender.SendEmail(ctx, target, "验证码", content)
+	default:
+		err = s.sender.SendSMS(ctx, target, content)
+	}
+	if err != nil {
+		s.logger.Error(err.Error(), zap.String("channel", channel), zap.String("target", target))
+		return v1.ErrInternalServerError
+	}
+	return nil
+}
+
+func (s *verifyCodeService) CheckCode(ctx context.Context, scene, target, code string) error {
+	ok, err := s.repo.CheckCode(ctx, scene, target, code, s.maxAttempts)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.String("scene", scene), zap.String("target", target))
+		return v1.ErrInternalServerError
+	}
+	if !ok {
+		return v1.ErrVerifyCodeInvalid
+	}
+	return nil
+}
+
+func genVerifyCode(length int) (string, error) {
+	code := make([]byte, length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		code[i] = byte('0' + n.Int64())
+	}
+	return string(code), nil
+}
@@ -2,41 +2,52 @@ package service
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"github.com/gorilla/websocket"
 	v1 "github.com/ljinf/im_server_standalone/api/v1"
-	"github.com/ljinf/im_server_standalone/internal/model"
 	"github.com/ljinf/im_server_standalone/internal/ws"
 	"github.com/ljinf/im_server_standalone/pkg/contants"
-	"github.com/panjf2000/ants"
+	"github.com/spf13/viper"
 	"go.uber.org/zap"
+	"time"
 )
 
 type WebsocketService interface {
-	InitConn(userId int64, conn *websocket.Conn)
-	PushMsg(payload []byte, userIds ...int64)
-	SyncPushMsg(msgInfo interface{}, userIds ...int64)
-	ProcessMsg(sender int64, payload []byte)
+	Draining() bool
+	InitConn(userId int64, sessionId, ip string, conn *websocket.Conn)
+	// 握手时未携带token的连接，需在超时前发送认证帧
+	InitPendingConn(ip string, conn *websocket.Conn)
+	AcquireSlot(ip string) error
+	ReleaseSlot(ip string)
+	PushMsg(frame *ws.Frame, userIds ...int64)
+	SyncPushMsg(msg *v1.SendMsgResp, userIds ...int64)
+	ProcessMsg(sender int64, frame *ws.Frame)
 }
 
 type websocketService struct {
 	*Service
 	ws.SocketWsServer
-	chatSrv ChatService
-	task    *ants.Pool
+	chatSrv     ChatService
+	sessionSrv  SessionService
+	authTimeout time.Duration
 }
 
-func NewWebsocketService(s *Service, wss ws.SocketWsServer, chatSrv ChatService, pool *ants.Pool) WebsocketService {
+func NewWebsocketService(s *Service, conf *viper.Viper, wss ws.SocketWsServer, chatSrv ChatService, sessionSrv SessionService) WebsocketService {
+	authTimeout := conf.GetDuration("ws_server.auth.timeout")
+	if authTimeout <= 0 {
+		authTimeout = 10 * time.Second
+	}
 	return &websocketService{
 		Service:        s,
 		SocketWsServer: wss,
 		chatSrv:        chatSrv,
-		task:           pool,
+		sessionSrv:     sessionSrv,
+		authTimeout:    authTimeout,
 	}
 }
 
-func (w *websocketService) InitConn(userId int64, conn *websocket.Conn) {
-	wsConn := ws.NewWsConn(w.logger, w.GetConnManager(), userId, conn)
+func (w *websocketService) InitConn(userId int64, sessionId, ip string, conn *websocket.Conn) {
+	wsConn := w.NewConn(userId, sessionId, ip, conn)
 	if err := w.AddConn(wsConn); err != nil {
 		w.logger.Error(err.Error(), zap.Any("userId", userId))
 		wsConn.Close()
@@ -46,36 +57,83 @@ func (w *websocketService) InitConn(userId int64, conn *websocket.Conn) {
 	wsConn.Work(w.ProcessMsg)
 }
 
+func (w *websocketService) InitPendingConn(ip string, conn *websocket.Conn) {
+	userId, sessionId, err := w.authFirstFrame(conn)
+	if err != nil {
+		w.logger.Warn("ws auth failed", zap.Error(err), zap.String("remote", conn.RemoteAddr().String()))
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "unauthorized"),
+			time.Now().Add(time.Second))
+		_ = conn.Close()
+		w.ReleaseSlot(ip)
+		return
+	}
+
+	wsConn := w.NewConn(userId, sessionId, ip, conn)
+	if err = w.AddConn(wsConn); err != nil {
+		w.logger.Error(err.Error(), zap.Any("userId", userId))
+		wsConn.Close()
+		return
+	}
+	_ = wsConn.WriteFrame(ws.CommandFrame(&ws.Command{Cmd: "auth_ok"}))
+	wsConn.Work(w.ProcessMsg)
+}
+
+// 首帧必须是认证帧，超时未收到时读取返回错误
+func (w *websocketService) authFirstFrame(conn *websocket.Conn) (int64, string, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(w.authTimeout))
+	_, payload, err := conn.ReadMessage()
+	if err != nil {
+		return 0, "", err
+	}
+	frame, err := ws.CodecBySubprotocol(conn.Subprotocol()).Decode(payload)
+	if err != nil {
+		return 0, "", err
+	}
+	if frame.Auth == nil {
+		return 0, "", errors.New("first frame is not auth")
+	}
+
+	claims, err := w.jwt.ParseToken(frame.Auth.Token)
+	if err != nil {
+		return 0, "", err
+	}
+	active, err := w.sessionSrv.SessionActive(context.Background(), claims.UserId, claims.ID)
+	if err != nil {
+		return 0, "", err
+	}
+	if !active {
+		return 0, "", errors.New("session revoked")
+	}
+	banned, err := w.sessionSrv.UserBanned(context.Background(), claims.UserId)
+	if err != nil {
+		return 0, "", err
+	}
+	if banned {
+		return 0, "", v1.ErrUserBanned
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	return claims.UserId, claims.ID, nil
+}
+
 // 推送
-func (w *websocketService) PushMsg(payload []byte, userIds ...int64) {
-	if err := w.Push(payload, userIds...); err != nil {
+func (w *websocketService) PushMsg(frame *ws.Frame, userIds ...int64) {
+	if err := w.Push(frame, userIds...); err != nil {
 		w.logger.Error(err.Error())
 	}
 }
 
 // 移步推送
-func (w *websocketService) SyncPushMsg(msgInfo interface{}, userIds ...int64) {
-	if err := w.task.Submit(func() {
-		payload, err := json.Marshal(msgInfo)
-		if err != nil {
-			w.logger.Error(err.Error())
-			return
-		}
-		w.PushMsg(payload, userIds...)
+func (w *websocketService) SyncPushMsg(msg *v1.SendMsgResp, userIds ...int64) {
+	if err := w.Submit(func() {
+		w.PushMsg(ws.ChatFrame(msg), userIds...)
 	}); err != nil {
 		w.logger.Error(err.Error())
 	}
 }
 
-// 消息处理
-func (w *websocketService) ProcessMsg(sender int64, payload []byte) {
-	var info model.WsMessage
-	if err := json.Unmarshal(payload, &info); err != nil {
-		w.logger.Error(err.Error(), zap.Any("消息内容解析错误 payload", string(payload)))
-		return
-	}
-
-	switch info.MsgType {
+// 消息处理，帧已按连接协商的协议解码
+func (w *websocketService) ProcessMsg(sender int64, frame *ws.Frame) {
+	switch frame.MsgType {
 	case contants.MsgTypeCommand:
 
 		break
@@ -83,36 +141,31 @@ func (w *websocketService) ProcessMsg(sender int64, payload []byte) {
 
 		break
 	case contants.MsgTypeChat:
-		w.msgChat(info.Payload)
+		if frame.Send == nil {
+			w.logger.Error("chat frame without body", zap.Any("sender", sender))
+			return
+		}
+		w.msgChat(sender, frame.Send)
 		break
 	}
 }
 
-func (w *websocketService) msgChat(payload []byte) {
-	msgReq, err := parsePayload(payload)
-	if err != nil {
-		w.logger.Error(err.Error(), zap.Any("msgChat", "parsePayload err"))
-		return
-	}
+func (w *websocketService) msgChat(sender int64, msgReq *v1.SendMsgReq) {
+	// 发送者以连接的用户为准
+	msgReq.UserId = sender
 
 	msgResp, err := w.chatSrv.CreateMsg(context.Background(), msgReq)
 	if err != nil {
 		w.logger.Error(err.Error(), zap.Any("msgChat", "CreateMsg err"))
-	}
-
-	resp, err := json.Marshal(msgResp)
-	if err != nil {
-		w.logger.Error(err.Error(), zap.Any("msgChat", "json.Marshal err"))
+		// 告知发送者失败原因，如被限流
+		w.PushMsg(ws.ErrorFrame(v1.ErrorCode(err), err.Error()), sender)
 		return
 	}
 
-	w.PushMsg(resp, msgResp.UserId, msgReq.TargetId)
-}
-
-func parsePayload(payload []byte) (*v1.SendMsgReq, error) {
-	msg := &v1.SendMsgReq{}
-	if err := json.Unmarshal(payload, msg); err != nil {
-		return nil, err
+	// 屏蔽的消息和进入对方消息请求的只回给发送者
+	if msgResp.Status == contants.MsgStatusBlocked || msgResp.TargetRequest {
+		w.PushMsg(ws.ChatFrame(msgResp), msgResp.UserId)
+		return
 	}
-	return msg, nil
+	w.PushMsg(ws.ChatFrame(msgResp), msgResp.UserId, msgReq.TargetId)
 }
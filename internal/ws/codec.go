@@ -0,0 +1,191 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	v1 "github.com/ljinf/im_server_standalone/api/v1"
+	"github.com/ljinf/im_server_standalone/internal/model"
+	"github.com/ljinf/im_server_standalone/pkg/contants"
+	"sync"
+)
+
+// 握手时通过Sec-WebSocket-Protocol协商，未携带时使用JSON
+const (
+	SubprotocolJSON  = "im.v1.json"
+	SubprotocolProto = "im.v1.proto"
+)
+
+var ErrUnknownFrame = errors.New("unknown frame")
+
+// 指令
+type Command struct {
+	Cmd   string `json:"cmd"`
+	Delay int64  `json:"delay,omitempty"` //毫秒
+}
+
+// 握手时未携带token的连接，首帧发送token认证
+type Auth struct {
+	Token string `json:"token"`
+}
+
+// 错误，code与HTTP接口的业务错误码一致
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// 通知，data为JSON，格式按event区分
+type Notify struct {
+	Event string `json:"event"`
+	Data  string `json:"data"`
+}
+
+// 连接上收发的帧，按MsgType只有一个body字段有值
+type Frame struct {
+	MsgType int             `json:"msg_type"`
+	Send    *v1.SendMsgReq  `json:"send,omitempty"`    //客户端发送聊天消息
+	Chat    *v1.SendMsgResp `json:"chat,omitempty"`    //服务端推送聊天消息
+	Command *Command        `json:"command,omitempty"` //指令
+	Auth    *Auth           `json:"auth,omitempty"`    //首帧认证
+	Error   *Error          `json:"error,omitempty"`   //错误
+	Notify  *Notify         `json:"notify,omitempty"`  //服务端通知，如举报处理结果
+
+	mutx    sync.Mutex
+	encoded map[string][]byte //按协议缓存编码结果，推送给多个连接时只编码一次
+}
+
+func ChatFrame(msg *v1.SendMsgResp) *Frame {
+	return &Frame{MsgType: contants.MsgTypeChat, Chat: msg}
+}
+
+func CommandFrame(cmd *Command) *Frame {
+	return &Frame{MsgType: contants.MsgTypeCommand, Command: cmd}
+}
+
+func ErrorFrame(code int, message string) *Frame {
+	return &Frame{MsgType: contants.MsgTypeError, Error: &Error{Code: code, Message: message}}
+}
+
+func NotifyFrame(event, data string) *Frame {
+	return &Frame{MsgType: contants.MsgTypeNotify, Notify: &Notify{Event: event, Data: data}}
+}
+
+func (f *Frame) encode(codec Codec) ([]byte, error) {
+	f.mutx.Lock()
+	defer f.mutx.Unlock()
+	if data, ok := f.encoded[codec.Name()]; ok {
+		return data, nil
+	}
+	data, err := codec.Encode(f)
+	if err != nil {
+		return nil, err
+	}
+	if f.encoded == nil {
+		f.encoded = make(map[string][]byte, 2)
+	}
+	f.encoded[codec.Name()] = data
+	return data, nil
+}
+
+type Codec interface {
+	Name() string
+	// websocket帧类型
+	MessageType() int
+	Encode(f *Frame) ([]byte, error)
+	Decode(data []byte) (*Frame, error)
+}
+
+var codecs = map[string]Codec{
+	SubprotocolJSON:  jsonCodec{},
+	SubprotocolProto: protoCodec{},
+}
+
+// 服务端支持的子协议，按优先级排列
+func Subprotocols() []string {
+	return []string{SubprotocolProto, SubprotocolJSON}
+}
+
+// 按握手协商的子协议选择编解码，未协商时使用JSON
+func CodecBySubprotocol(name string) Codec {
+	if codec, ok := codecs[name]; ok {
+		return codec
+	}
+	return codecs[SubprotocolJSON]
+}
+
+// 兼容原有格式：聊天消息直接推送消息体，其他帧为WsMessage，payload为JSON
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return SubprotocolJSON
+}
+
+func (jsonCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (jsonCodec) Encode(f *Frame) ([]byte, error) {
+	switch {
+	case f.Chat != nil:
+		return json.Marshal(f.Chat)
+	case f.Command != nil:
+		payload, err := json.Marshal(f.Command)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(model.WsMessage{MsgType: f.MsgType, Payload: payload})
+	case f.Send != nil:
+		payload, err := json.Marshal(f.Send)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(model.WsMessage{MsgType: f.MsgType, Payload: payload})
+	case f.Auth != nil:
+		payload, err := json.Marshal(f.Auth)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(model.WsMessage{MsgType: f.MsgType, Payload: payload})
+	case f.Error != nil:
+		payload, err := json.Marshal(f.Error)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(model.WsMessage{MsgType: f.MsgType, Payload: payload})
+	case f.Notify != nil:
+		payload, err := json.Marshal(f.Notify)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(model.WsMessage{MsgType: f.MsgType, Payload: payload})
+	}
+	return nil, ErrUnknownFrame
+}
+
+func (jsonCodec) Decode(data []byte) (*Frame, error) {
+	var info model.WsMessage
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+
+	f := &Frame{MsgType: info.MsgType}
+	switch info.MsgType {
+	case contants.MsgTypeChat:
+		f.Send = &v1.SendMsgReq{}
+		if err := json.Unmarshal(info.Payload, f.Send); err != nil {
+			return nil, err
+		}
+	case contants.MsgTypeCommand:
+		f.Command = &Command{}
+		if err := json.Unmarshal(info.Payload, f.Command); err != nil {
+			return nil, err
+		}
+	case contants.MsgTypeAuth:
+		f.Auth = &Auth{}
+		if err := json.Unmarshal(info.Payload, f.Auth); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
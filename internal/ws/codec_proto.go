@@ -0,0 +1,96 @@
+package ws
+
+import (
+	"github.com/gorilla/websocket"
+	v1 "github.com/ljinf/im_server_standalone/api/v1"
+	"github.com/ljinf/im_server_standalone/api/v1/wspb"
+	"google.golang.org/protobuf/proto"
+)
+
+// 使用 api/v1/ws.proto 生成的类型编解码，只在Frame和生成类型之间转换字段
+type protoCodec struct{}
+
+func (protoCodec) Name() string {
+	return SubprotocolProto
+}
+
+func (protoCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (protoCodec) Encode(f *Frame) ([]byte, error) {
+	pb := &wspb.Frame{MsgType: int32(f.MsgType)}
+	switch {
+	case f.Send != nil:
+		pb.Body = &wspb.Frame_Send{Send: &wspb.SendMsgReq{
+			ConversationId: f.Send.ConversationId,
+			TargetId:       f.Send.TargetId,
+			Content:        f.Send.Content,
+			ContentType:    int32(f.Send.ContentType),
+			SendTime:       f.Send.SendTime,
+		}}
+	case f.Chat != nil:
+		pb.Body = &wspb.Frame_Chat{Chat: &wspb.ChatMsg{
+			UserId:         f.Chat.UserId,
+			MsgId:          f.Chat.MsgId,
+			ConversationId: f.Chat.ConversationId,
+			Content:        f.Chat.Content,
+			ContentType:    int32(f.Chat.ContentType),
+			Status:         int32(f.Chat.Status),
+			Seq:            f.Chat.Seq,
+			SendTime:       f.Chat.SendTime,
+			CreatedAt:      f.Chat.CreatedAt,
+		}}
+	case f.Command != nil:
+		pb.Body = &wspb.Frame_Command{Command: &wspb.Command{Cmd: f.Command.Cmd, Delay: f.Command.Delay}}
+	case f.Auth != nil:
+		pb.Body = &wspb.Frame_Auth{Auth: &wspb.Auth{Token: f.Auth.Token}}
+	case f.Error != nil:
+		pb.Body = &wspb.Frame_Error{Error: &wspb.Error{Code: int32(f.Error.Code), Message: f.Error.Message}}
+	case f.Notify != nil:
+		pb.Body = &wspb.Frame_Notify{Notify: &wspb.Notify{Event: f.Notify.Event, Data: f.Notify.Data}}
+	default:
+		return nil, ErrUnknownFrame
+	}
+	return proto.Marshal(pb)
+}
+
+func (protoCodec) Decode(data []byte) (*Frame, error) {
+	var pb wspb.Frame
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return nil, err
+	}
+
+	f := &Frame{MsgType: int(pb.MsgType)}
+	switch body := pb.Body.(type) {
+	case *wspb.Frame_Send:
+		f.Send = &v1.SendMsgReq{
+			ConversationId: body.Send.ConversationId,
+			TargetId:       body.Send.TargetId,
+			Content:        body.Send.Content,
+			ContentType:    int(body.Send.ContentType),
+			SendTime:       body.Send.SendTime,
+		}
+	case *wspb.Frame_Chat:
+		f.Chat = &v1.SendMsgResp{
+			UserId:         body.Chat.UserId,
+			MsgId:          body.Chat.MsgId,
+			ConversationId: body.Chat.ConversationId,
+			Content:        body.Chat.Content,
+			ContentType:    int(body.Chat.ContentType),
+			Status:         int(body.Chat.Status),
+			Seq:            body.Chat.Seq,
+			SendTime:       body.Chat.SendTime,
+			CreatedAt:      body.Chat.CreatedAt,
+		}
+	case *wspb.Frame_Command:
+		f.Command = &Command{Cmd: body.Command.Cmd, Delay: body.Command.Delay}
+	case *wspb.Frame_Auth:
+		f.Auth = &Auth{Token: body.Auth.Token}
+	case *wspb.Frame_Error:
+		f.Error = &Error{Code: int(body.Error.Code), Message: body.Error.Message}
+	case *wspb.Frame_Notify:
+		f.Notify = &Notify{Event: body.Notify.Event, Data: body.Notify.Data}
+	}
+	return f, nil
+}
@@ -0,0 +1,187 @@
+package ws
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	v1 "github.com/ljinf/im_server_standalone/api/v1"
+	"github.com/ljinf/im_server_standalone/api/v1/wspb"
+	"github.com/ljinf/im_server_standalone/internal/model"
+	"github.com/ljinf/im_server_standalone/pkg/contants"
+	"google.golang.org/protobuf/proto"
+)
+
+// 每种帧的所有字段都赋非零值，漏掉的字段编解码后会变成零值
+func testFrames() map[string]*Frame {
+	return map[string]*Frame{
+		"send": {MsgType: contants.MsgTypeChat, Send: &v1.SendMsgReq{
+			ConversationId: 1001, TargetId: 2002, Content: "你好", ContentType: 1, SendTime: 1700000000,
+		}},
+		"chat": ChatFrame(&v1.SendMsgResp{
+			UserId: 1, MsgId: 2, ConversationId: 3, Content: "hello", ContentType: 2, Status: 1,
+			Seq: 4, SendTime: 1700000000, CreatedAt: 1700000001,
+		}),
+		"command": CommandFrame(&Command{Cmd: "reconnect", Delay: 500}),
+		"auth":    {MsgType: contants.MsgTypeAuth, Auth: &Auth{Token: "token"}},
+		"error":   ErrorFrame(3001, "发送过于频繁"),
+		"notify":  NotifyFrame(contants.NotifyEventAnnouncement, `{"id":1}`),
+	}
+}
+
+func TestProtoCodecRoundTrip(t *testing.T) {
+	for name, f := range testFrames() {
+		t.Run(name, func(t *testing.T) {
+			assertRoundTrip(t, protoCodec{}, f)
+		})
+	}
+}
+
+// JSON只解码客户端发送的帧，服务端推送的帧保持原有格式
+func TestJSONCodecRoundTrip(t *testing.T) {
+	frames := testFrames()
+	for _, name := range []string{"send", "command", "auth"} {
+		t.Run(name, func(t *testing.T) {
+			assertRoundTrip(t, jsonCodec{}, frames[name])
+		})
+	}
+}
+
+func TestJSONCodecPushFormat(t *testing.T) {
+	frames := testFrames()
+
+	// 聊天消息直接推送消息体
+	data, err := jsonCodec{}.Encode(frames["chat"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chat v1.SendMsgResp
+	if err = json.Unmarshal(data, &chat); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&chat, frames["chat"].Chat) {
+		t.Fatalf("chat = %+v, want %+v", chat, frames["chat"].Chat)
+	}
+
+	// 其他帧为WsMessage，payload为body的JSON
+	for _, name := range []string{"error", "notify"} {
+		f := frames[name]
+		data, err = jsonCodec{}.Encode(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var info model.WsMessage
+		if err = json.Unmarshal(data, &info); err != nil {
+			t.Fatal(err)
+		}
+		if info.MsgType != f.MsgType {
+			t.Fatalf("%v msg_type = %v, want %v", name, info.MsgType, f.MsgType)
+		}
+		got := &Frame{}
+		if f.Error != nil {
+			got.Error = &Error{}
+			err = json.Unmarshal(info.Payload, got.Error)
+		} else {
+			got.Notify = &Notify{}
+			err = json.Unmarshal(info.Payload, got.Notify)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.MsgType = info.MsgType
+		assertFrameEqual(t, f, got)
+	}
+}
+
+func assertRoundTrip(t *testing.T, codec Codec, f *Frame) {
+	t.Helper()
+	data, err := codec.Encode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := codec.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFrameEqual(t, f, got)
+}
+
+// 与生成的类型互相解码，校验编码结果符合ws.proto，而不只是编解码自洽
+func TestProtoCodecMatchesSchema(t *testing.T) {
+	for name, f := range testFrames() {
+		t.Run(name, func(t *testing.T) {
+			data, err := protoCodec{}.Encode(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var pb wspb.Frame
+			if err = proto.Unmarshal(data, &pb); err != nil {
+				t.Fatal(err)
+			}
+			if int(pb.MsgType) != f.MsgType {
+				t.Fatalf("msg_type = %v, want %v", pb.MsgType, f.MsgType)
+			}
+
+			want := expectedProto(f)
+			if !proto.Equal(&pb, want) {
+				t.Fatalf("decoded %v, want %v", &pb, want)
+			}
+
+			// 客户端用生成的类型编码，服务端解码
+			data, err = proto.Marshal(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := protoCodec{}.Decode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertFrameEqual(t, f, got)
+		})
+	}
+}
+
+// 按ws.proto的字段逐个构造，不经过codec的转换
+func expectedProto(f *Frame) *wspb.Frame {
+	pb := &wspb.Frame{MsgType: int32(f.MsgType)}
+	switch {
+	case f.Send != nil:
+		pb.Body = &wspb.Frame_Send{Send: &wspb.SendMsgReq{ConversationId: 1001, TargetId: 2002, Content: "你好",
+			ContentType: 1, SendTime: 1700000000}}
+	case f.Chat != nil:
+		pb.Body = &wspb.Frame_Chat{Chat: &wspb.ChatMsg{UserId: 1, MsgId: 2, ConversationId: 3, Content: "hello",
+			ContentType: 2, Status: 1, Seq: 4, SendTime: 1700000000, CreatedAt: 1700000001}}
+	case f.Command != nil:
+		pb.Body = &wspb.Frame_Command{Command: &wspb.Command{Cmd: "reconnect", Delay: 500}}
+	case f.Auth != nil:
+		pb.Body = &wspb.Frame_Auth{Auth: &wspb.Auth{Token: "token"}}
+	case f.Error != nil:
+		pb.Body = &wspb.Frame_Error{Error: &wspb.Error{Code: 3001, Message: "发送过于频繁"}}
+	case f.Notify != nil:
+		pb.Body = &wspb.Frame_Notify{Notify: &wspb.Notify{Event: contants.NotifyEventAnnouncement, Data: `{"id":1}`}}
+	}
+	return pb
+}
+
+func assertFrameEqual(t *testing.T, want, got *Frame) {
+	t.Helper()
+	if got.MsgType != want.MsgType {
+		t.Fatalf("MsgType = %v, want %v", got.MsgType, want.MsgType)
+	}
+	pairs := []struct {
+		name      string
+		want, got interface{}
+	}{
+		{"Send", want.Send, got.Send},
+		{"Chat", want.Chat, got.Chat},
+		{"Command", want.Command, got.Command},
+		{"Auth", want.Auth, got.Auth},
+		{"Error", want.Error, got.Error},
+		{"Notify", want.Notify, got.Notify},
+	}
+	for _, p := range pairs {
+		if !reflect.DeepEqual(p.want, p.got) {
+			t.Fatalf("%v = %+v, want %+v", p.name, p.got, p.want)
+		}
+	}
+}
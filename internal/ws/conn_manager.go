@@ -3,12 +3,14 @@ package ws
 import (
 	"errors"
 	"fmt"
+	"github.com/gorilla/websocket"
 	"sync"
 )
 
 type ConnMgr struct {
 	buckets      []*bucket
 	perBucketCap int
+	onRemove     func(conn *WsConn) //连接移除后回调
 }
 
 func NewConnMgr(length, maxConns int) *ConnMgr {
@@ -32,8 +34,32 @@ func (m *ConnMgr) GetConn(id int64) *WsConn {
 	return m.GetBucket(id).Get(id)
 }
 
-func (m *ConnMgr) RemConn(id int64) error {
-	return m.GetBucket(id).Rem(id)
+func (m *ConnMgr) RemConn(conn *WsConn) error {
+	if err := m.GetBucket(conn.ConnId).Rem(conn); err != nil {
+		return err
+	}
+	if m.onRemove != nil {
+		m.onRemove(conn)
+	}
+	return nil
+}
+
+// 遍历所有连接，fn返回false时停止
+func (m *ConnMgr) Range(fn func(conn *WsConn) bool) {
+	for _, b := range m.buckets {
+		if !b.Range(fn) {
+			return
+		}
+	}
+}
+
+// 每个桶的连接数
+func (m *ConnMgr) BucketCounts() []int {
+	counts := make([]int, 0, len(m.buckets))
+	for _, b := range m.buckets {
+		counts = append(counts, b.Count())
+	}
+	return counts
 }
 
 func (m *ConnMgr) GetBucket(id int64) *bucket {
@@ -56,17 +82,44 @@ func NewBucket(index, len int) *bucket {
 	}
 }
 
+// 同一用户的旧连接会被替换并关闭
 func (b *bucket) Add(conn *WsConn) error {
 	b.mutx.Lock()
-	defer b.mutx.Unlock()
-	if len(b.conns) < b.len {
-		if old, ok := b.conns[conn.ConnId]; ok {
-			old.Close()
+	old, ok := b.conns[conn.ConnId]
+	if !ok && len(b.conns) >= b.len {
+		b.mutx.Unlock()
+		return errors.New(fmt.Sprintf("bucket %v 连接数已满", b.index))
+	}
+	b.conns[conn.ConnId] = conn
+	b.mutx.Unlock()
+
+	// 关闭时会回调Rem，不能持有锁
+	if ok {
+		old.CloseWithReason(websocket.ClosePolicyViolation, "replaced by new connection")
+	}
+	return nil
+}
+
+func (b *bucket) Range(fn func(conn *WsConn) bool) bool {
+	b.mutx.RLock()
+	conns := make([]*WsConn, 0, len(b.conns))
+	for _, v := range b.conns {
+		conns = append(conns, v)
+	}
+	b.mutx.RUnlock()
+
+	for _, v := range conns {
+		if !fn(v) {
+			return false
 		}
-		b.conns[conn.ConnId] = conn
-		return nil
 	}
-	return errors.New(fmt.Sprintf("bucket %v 连接数已满", b.index))
+	return true
+}
+
+func (b *bucket) Count() int {
+	b.mutx.RLock()
+	defer b.mutx.RUnlock()
+	return len(b.conns)
 }
 
 func (b *bucket) Get(id int64) *WsConn {
@@ -75,13 +128,13 @@ func (b *bucket) Get(id int64) *WsConn {
 	return b.conns[id]
 }
 
-func (b *bucket) Rem(id int64) error {
+// 只移除conn本身，已被新连接替换时不处理
+func (b *bucket) Rem(conn *WsConn) error {
 	b.mutx.Lock()
 	defer b.mutx.Unlock()
-	if old, ok := b.conns[id]; ok {
-		old.Close()
-		delete(b.conns, id)
+	if old, ok := b.conns[conn.ConnId]; ok && old == conn {
+		delete(b.conns, conn.ConnId)
 		return nil
 	}
-	return errors.New(fmt.Sprintf("conn %v is not found", id))
+	return errors.New(fmt.Sprintf("conn %v is not found", conn.ConnId))
 }
@@ -5,31 +5,83 @@ import (
 	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/ljinf/im_server_standalone/pkg/log"
+	"go.uber.org/zap"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 const (
 	WriteChanMaxLen = 16
+
+	// 发送缓冲已满时的处理策略
+	SlowPolicyDrop       = "drop"       //丢弃新消息
+	SlowPolicyDisconnect = "disconnect" //断开连接，客户端重连后拉取离线消息
+)
+
+var (
+	ErrConnClosed   = errors.New("closed")
+	ErrSlowConsumer = errors.New("slow consumer")
 )
 
+type ConnOptions struct {
+	BufferSize   int           //每个连接的发送缓冲
+	SlowPolicy   string        //缓冲已满时的处理策略
+	WriteTimeout time.Duration //单条消息写超时，客户端长时间不读时写协程不会一直阻塞
+
+	// 握手协商了permessage-deflate时生效
+	CompressLevel     int //压缩级别，0使用默认
+	CompressThreshold int //超过该字节数才压缩，小消息压缩收益不大
+}
+
+// 发送统计，所有连接共享
+type Stats struct {
+	DroppedFrames   uint64 //因缓冲已满丢弃的消息数
+	SlowDisconnects uint64 //因发送过慢被断开的连接数
+}
+
 type WsConn struct {
 	connManager *ConnMgr
 	logger      *log.Logger
-	ConnId      int64 //userId
+	ConnId      int64  //userId
+	SessionId   string //登录会话id，退出登录时关闭对应连接
+	ClientIp    string
 	Conn        *websocket.Conn
+	codec       Codec //握手协商的帧格式
+	opts        ConnOptions
+	stats       *Stats
 	outChan     chan []byte
-	isClose     int32 // 0否  1是
+	mutx        sync.RWMutex //保护isClose和outChan的关闭，避免向已关闭的通道写入
+	isClose     bool
 	once        sync.Once
+	writeDone   chan struct{} //writeLoop退出时关闭
+	dropped     uint64        //当前连接丢弃的消息数
+	slow        int32         //已因发送过慢断开 0否  1是
+	onClose     func()        //关闭后回调，释放连接数配额
 }
 
-func NewWsConn(logger *log.Logger, connManager *ConnMgr, connId int64, conn *websocket.Conn) *WsConn {
+func NewWsConn(logger *log.Logger, connManager *ConnMgr, connId int64, sessionId string, conn *websocket.Conn,
+	opts ConnOptions, stats *Stats) *WsConn {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = WriteChanMaxLen
+	}
+	if stats == nil {
+		stats = &Stats{}
+	}
+	if opts.CompressLevel != 0 {
+		_ = conn.SetCompressionLevel(opts.CompressLevel)
+	}
 	return &WsConn{
 		connManager: connManager,
 		logger:      logger,
 		ConnId:      connId,
+		SessionId:   sessionId,
 		Conn:        conn,
-		outChan:     make(chan []byte, WriteChanMaxLen),
+		codec:       CodecBySubprotocol(conn.Subprotocol()),
+		opts:        opts,
+		stats:       stats,
+		outChan:     make(chan []byte, opts.BufferSize),
+		writeDone:   make(chan struct{}),
 	}
 }
 
@@ -41,6 +93,7 @@ func (c *WsConn) Work(handler Dispatch) {
 
 func (c *WsConn) readLoop(handler Dispatch) {
 	defer func() {
+		c.Close()
 		c.logger.Debug(fmt.Sprintf("%v readLoop closed", c.ConnId))
 	}()
 	for {
@@ -52,36 +105,127 @@ func (c *WsConn) readLoop(handler Dispatch) {
 		if messageType == websocket.PingMessage || messageType == websocket.PongMessage {
 			continue
 		}
-		handler(c.ConnId, payload)
+		frame, err := c.codec.Decode(payload)
+		if err != nil {
+			c.logger.Error(err.Error(), zap.Any("userId", c.ConnId), zap.String("codec", c.codec.Name()))
+			continue
+		}
+		handler(c.ConnId, frame)
 	}
 }
 
+// 非阻塞写入发送缓冲，缓冲已满时按策略丢弃或断开
 func (c *WsConn) Write(payload []byte) error {
-	if atomic.LoadInt32(&c.isClose) == 0 {
-		c.outChan <- payload
+	c.mutx.RLock()
+	if c.isClose {
+		c.mutx.RUnlock()
+		return ErrConnClosed
+	}
+	select {
+	case c.outChan <- payload:
+		c.mutx.RUnlock()
 		return nil
+	default:
+	}
+	c.mutx.RUnlock()
+
+	atomic.AddUint64(&c.dropped, 1)
+	atomic.AddUint64(&c.stats.DroppedFrames, 1)
+	if c.opts.SlowPolicy == SlowPolicyDisconnect && atomic.CompareAndSwapInt32(&c.slow, 0, 1) {
+		atomic.AddUint64(&c.stats.SlowDisconnects, 1)
+		c.logger.Warn("slow consumer disconnected", zap.Any("userId", c.ConnId), zap.String("sessionId", c.SessionId))
+		go c.CloseWithReason(websocket.ClosePolicyViolation, "slow consumer")
 	}
-	return errors.New("closed")
+	return ErrSlowConsumer
+}
+
+// 按连接协商的协议编码后写入
+func (c *WsConn) WriteFrame(f *Frame) error {
+	payload, err := f.encode(c.codec)
+	if err != nil {
+		return err
+	}
+	return c.Write(payload)
+}
+
+// 当前连接丢弃的消息数
+func (c *WsConn) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
 }
 
 func (c *WsConn) writeLoop() {
 	defer func() {
+		close(c.writeDone)
 		c.logger.Debug(fmt.Sprintf("%v writeLoop closed", c.ConnId))
 	}()
 	for v := range c.outChan {
-		if err := c.Conn.WriteMessage(websocket.BinaryMessage, v); err != nil {
-			c.logger.Error(err.Error())
-			break
+		if c.opts.WriteTimeout > 0 {
+			_ = c.Conn.SetWriteDeadline(time.Now().Add(c.opts.WriteTimeout))
+		}
+		c.Conn.EnableWriteCompression(len(v) >= c.opts.CompressThreshold)
+		if err := c.Conn.WriteMessage(c.codec.MessageType(), v); err != nil {
+			c.logger.Error(err.Error(), zap.Any("userId", c.ConnId))
+			// 关闭底层连接让readLoop退出并清理
+			_ = c.Conn.Close()
+			return
 		}
 	}
 }
 
+// 标记关闭并关闭发送缓冲，之后的Write直接返回错误
+func (c *WsConn) closeOutChan() {
+	c.mutx.Lock()
+	defer c.mutx.Unlock()
+	if !c.isClose {
+		c.isClose = true
+		close(c.outChan)
+	}
+}
+
+func (c *WsConn) closed() bool {
+	c.mutx.RLock()
+	defer c.mutx.RUnlock()
+	return c.isClose
+}
+
 func (c *WsConn) Close() {
 	c.once.Do(func() {
+		c.closeOutChan()
 		_ = c.Conn.Close()
-		close(c.outChan)
-		atomic.CompareAndSwapInt32(&c.isClose, 0, 1)
+		if n := c.Dropped(); n > 0 {
+			c.logger.Info("conn closed with dropped frames", zap.Any("userId", c.ConnId), zap.Uint64("dropped", n))
+		}
 		// 移除当前连接
-		_ = c.connManager.RemConn(c.ConnId)
+		_ = c.connManager.RemConn(c)
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+}
+
+// 先发送关闭帧告知客户端原因再关闭
+func (c *WsConn) CloseWithReason(code int, reason string) {
+	if !c.closed() {
+		_ = c.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason),
+			time.Now().Add(time.Second))
+	}
+	c.Close()
+}
+
+// 不再接收新消息，等待已排队的消息写完或超时后发送关闭帧并关闭
+func (c *WsConn) Drain(code int, reason string, timeout time.Duration) {
+	c.once.Do(func() {
+		c.closeOutChan()
+		select {
+		case <-c.writeDone:
+		case <-time.After(timeout):
+		}
+		_ = c.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason),
+			time.Now().Add(time.Second))
+		_ = c.Conn.Close()
+		_ = c.connManager.RemConn(c)
+		if c.onClose != nil {
+			c.onClose()
+		}
 	})
 }
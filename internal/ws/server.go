@@ -1,39 +1,156 @@
 package ws
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/ljinf/im_server_standalone/internal/cache"
+	"github.com/ljinf/im_server_standalone/internal/model"
 	"github.com/ljinf/im_server_standalone/pkg/log"
+	"github.com/panjf2000/ants"
+	"github.com/redis/go-redis/v9"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
+	"math/rand"
+	"os"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-type Dispatch func(sender int64, payload []byte)
+type Dispatch func(sender int64, frame *Frame)
 
 var (
 	server SocketWsServer
 	mutex  sync.Mutex
+
+	ErrDraining       = errors.New("ws server is draining")
+	ErrTooManyConns   = errors.New("too many connections")
+	ErrTooManyIpConns = errors.New("too many connections from ip")
+)
+
+const (
+	nodeMsgPush      = "push"
+	nodeMsgClose     = "close"
+	nodeMsgBroadcast = "broadcast"
 )
 
+// 节点间投递的消息，接收节点只投递给本地连接，不再转发
+type nodeMessage struct {
+	Type      string  `json:"type"`
+	UserIds   []int64 `json:"user_ids"`
+	Frame     *Frame  `json:"frame,omitempty"`
+	SessionId string  `json:"session_id,omitempty"`
+	Code      int     `json:"code,omitempty"`
+	Reason    string  `json:"reason,omitempty"`
+}
+
 type SocketWsServer interface {
+	// 按配置的发送缓冲和慢消费策略创建连接
+	NewConn(userId int64, sessionId, ip string, conn *websocket.Conn) *WsConn
+	// 升级前占用连接数配额，超出本节点总数或单IP上限时返回错误。连接关闭时自动释放
+	AcquireSlot(ip string) error
+	// 升级失败或认证失败等未创建连接时手动释放
+	ReleaseSlot(ip string)
 	AddConn(c *WsConn) error
 	GetConnManager() *ConnMgr
-	Push(msg []byte, ids ...int64) error
+	// 推送给用户，不在本节点的通过节点总线投递
+	Push(frame *Frame, ids ...int64) error
+	// 推送给所有节点的所有在线连接，通过广播频道投递，本节点也从频道接收
+	Broadcast(frame *Frame) error
+	NodeId() string
+	IsOnline(userId int64) bool
+	// 用户在线连接的会话id，不在线返回空
+	OnlineSessionId(userId int64) string
+	// 关闭用户指定会话的连接，连接可以在任意节点
+	CloseConn(userId int64, sessionId string, code int, reason string)
+	// 提交异步任务，下线时会等待已提交的任务完成
+	Submit(task func()) error
+	// 下线中不再接受新连接
+	Draining() bool
+	// 发送统计
+	Stats() Stats
+	// 订阅节点总线并定期续期路由
+	Start(ctx context.Context) error
+	// 下线：通知客户端重连，等待异步任务和待发送消息后关闭连接
+	Stop(ctx context.Context) error
 }
 
 type wsServer struct {
-	logger  *log.Logger
-	connMgr *ConnMgr
+	logger   *log.Logger
+	connMgr  *ConnMgr
+	connOpts ConnOptions
+	stats    Stats
+	rdb      *redis.Client
+	nodeId   string
+	routeTTL time.Duration
+	done     chan struct{}
+	stopOnce sync.Once
+
+	slotMutx sync.Mutex
+	slots    int            //本节点连接数，包含等待首帧认证的
+	ipSlots  map[string]int //每个IP的连接数
+	maxConns int
+	maxPerIp int
+
+	pool            *ants.Pool
+	pending         int64 //未完成的异步任务数
+	draining        int32 // 0否  1是
+	drainTimeout    time.Duration
+	reconnectJitter time.Duration
 }
 
-func NewWsServer(conf *viper.Viper, logger *log.Logger) SocketWsServer {
+func NewWsServer(conf *viper.Viper, logger *log.Logger, rdb *redis.Client, pool *ants.Pool) SocketWsServer {
 	if server == nil {
 		mutex.Lock()
 		defer mutex.Unlock()
 		if server == nil {
-			server = &wsServer{
+			s := &wsServer{
 				logger:  logger,
 				connMgr: NewConnMgr(conf.GetInt("ws_server.max_buckets"), conf.GetInt("ws_server.per_bucket_cap")),
+				connOpts: ConnOptions{
+					BufferSize:   conf.GetInt("ws_server.write.buffer"),
+					SlowPolicy:   conf.GetString("ws_server.write.slow_policy"),
+					WriteTimeout: conf.GetDuration("ws_server.write.timeout"),
+
+					CompressLevel:     conf.GetInt("ws_server.compression.level"),
+					CompressThreshold: conf.GetInt("ws_server.compression.threshold"),
+				},
+				rdb:      rdb,
+				ipSlots:  make(map[string]int),
+				maxConns: conf.GetInt("ws_server.limits.max_conns"),
+				maxPerIp: conf.GetInt("ws_server.limits.per_ip"),
+				nodeId:   conf.GetString("ws_server.node_id"),
+				routeTTL: conf.GetDuration("ws_server.route_ttl"),
+				done:     make(chan struct{}),
+
+				pool:            pool,
+				drainTimeout:    conf.GetDuration("ws_server.drain.timeout"),
+				reconnectJitter: conf.GetDuration("ws_server.drain.reconnect_jitter"),
+			}
+			if s.nodeId == "" {
+				host, _ := os.Hostname()
+				s.nodeId = host + "-" + strconv.Itoa(os.Getpid())
+			}
+			if s.routeTTL <= 0 {
+				s.routeTTL = 90 * time.Second
+			}
+			if s.connOpts.SlowPolicy != SlowPolicyDisconnect {
+				s.connOpts.SlowPolicy = SlowPolicyDrop
+			}
+			if s.connOpts.WriteTimeout <= 0 {
+				s.connOpts.WriteTimeout = 10 * time.Second
+			}
+			if s.drainTimeout <= 0 {
+				s.drainTimeout = 10 * time.Second
 			}
+			if s.reconnectJitter <= 0 {
+				s.reconnectJitter = 5 * time.Second
+			}
+			s.connMgr.onRemove = s.removeRoute
+			server = s
 		}
 		return server
 	}
@@ -44,19 +161,313 @@ func (s *wsServer) GetConnManager() *ConnMgr {
 	return s.connMgr
 }
 
+func (s *wsServer) NewConn(userId int64, sessionId, ip string, conn *websocket.Conn) *WsConn {
+	c := NewWsConn(s.logger, s.connMgr, userId, sessionId, conn, s.connOpts, &s.stats)
+	c.ClientIp = ip
+	c.onClose = func() {
+		s.ReleaseSlot(ip)
+	}
+	return c
+}
+
+// 上限小于等于0时不限制
+func (s *wsServer) AcquireSlot(ip string) error {
+	s.slotMutx.Lock()
+	defer s.slotMutx.Unlock()
+	if s.maxConns > 0 && s.slots >= s.maxConns {
+		return ErrTooManyConns
+	}
+	if s.maxPerIp > 0 && s.ipSlots[ip] >= s.maxPerIp {
+		return ErrTooManyIpConns
+	}
+	s.slots++
+	s.ipSlots[ip]++
+	return nil
+}
+
+func (s *wsServer) ReleaseSlot(ip string) {
+	s.slotMutx.Lock()
+	defer s.slotMutx.Unlock()
+	if s.slots > 0 {
+		s.slots--
+	}
+	if n := s.ipSlots[ip]; n > 1 {
+		s.ipSlots[ip] = n - 1
+	} else {
+		delete(s.ipSlots, ip)
+	}
+}
+
+func (s *wsServer) Stats() Stats {
+	return Stats{
+		DroppedFrames:   atomic.LoadUint64(&s.stats.DroppedFrames),
+		SlowDisconnects: atomic.LoadUint64(&s.stats.SlowDisconnects),
+	}
+}
+
 func (s *wsServer) AddConn(c *WsConn) error {
-	return s.connMgr.AddConn(c)
+	if s.Draining() {
+		return ErrDraining
+	}
+	if err := s.connMgr.AddConn(c); err != nil {
+		return err
+	}
+
+	old, err := cache.SetWsRouteCache(s.rdb, c.ConnId, model.WsRoute{NodeId: s.nodeId, SessionId: c.SessionId}, s.routeTTL)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", c.ConnId), zap.String("nodeId", s.nodeId))
+		return nil
+	}
+	// 旧连接在其他节点，通知其关闭
+	if old != nil && old.NodeId != s.nodeId {
+		s.publish(old.NodeId, &nodeMessage{
+			Type:      nodeMsgClose,
+			UserIds:   []int64{c.ConnId},
+			SessionId: old.SessionId,
+			Code:      websocket.ClosePolicyViolation,
+			Reason:    "replaced by new connection",
+		})
+	}
+	return nil
 }
 
-func (s *wsServer) Push(msg []byte, ids ...int64) error {
-	if len(ids) > 0 {
-		for _, v := range ids {
-			if wsConn := s.connMgr.GetConn(v); wsConn != nil {
-				if err := wsConn.Write(msg); err != nil {
-					s.logger.Error(err.Error(), zap.Any("userId", v), zap.Any("msg", string(msg)))
-				}
+func (s *wsServer) removeRoute(c *WsConn) {
+	route := model.WsRoute{NodeId: s.nodeId, SessionId: c.SessionId}
+	if err := cache.DelWsRouteCache(s.rdb, c.ConnId, route); err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", c.ConnId), zap.String("nodeId", s.nodeId))
+	}
+}
+
+func (s *wsServer) Push(frame *Frame, ids ...int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	remote := make([]int64, 0)
+	for _, v := range ids {
+		if !s.pushLocal(frame, v) {
+			remote = append(remote, v)
+		}
+	}
+	if len(remote) == 0 {
+		return nil
+	}
+
+	routes, err := cache.GetWsRouteCache(s.rdb, remote...)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userIds", remote))
+		return err
+	}
+	nodes := make(map[string][]int64)
+	for userId, route := range routes {
+		// 路由指向本节点但本地没有连接，说明路由已过期
+		if route.NodeId == s.nodeId {
+			continue
+		}
+		nodes[route.NodeId] = append(nodes[route.NodeId], userId)
+	}
+	for nodeId, userIds := range nodes {
+		s.publish(nodeId, &nodeMessage{Type: nodeMsgPush, UserIds: userIds, Frame: frame})
+	}
+	return nil
+}
+
+func (s *wsServer) Broadcast(frame *Frame) error {
+	data, err := json.Marshal(&nodeMessage{Type: nodeMsgBroadcast, Frame: frame})
+	if err != nil {
+		return err
+	}
+	return cache.PublishWsBroadcast(s.rdb, data)
+}
+
+func (s *wsServer) NodeId() string {
+	return s.nodeId
+}
+
+func (s *wsServer) pushLocal(frame *Frame, userId int64) bool {
+	wsConn := s.connMgr.GetConn(userId)
+	if wsConn == nil {
+		return false
+	}
+	if err := wsConn.WriteFrame(frame); err != nil {
+		s.logger.Warn(err.Error(), zap.Any("userId", userId), zap.Int("msgType", frame.MsgType))
+	}
+	return true
+}
+
+func (s *wsServer) IsOnline(userId int64) bool {
+	return s.OnlineSessionId(userId) != ""
+}
+
+func (s *wsServer) OnlineSessionId(userId int64) string {
+	if conn := s.connMgr.GetConn(userId); conn != nil {
+		return conn.SessionId
+	}
+	routes, err := cache.GetWsRouteCache(s.rdb, userId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId))
+		return ""
+	}
+	if route, ok := routes[userId]; ok && route.NodeId != s.nodeId {
+		return route.SessionId
+	}
+	return ""
+}
+
+func (s *wsServer) CloseConn(userId int64, sessionId string, code int, reason string) {
+	if conn := s.connMgr.GetConn(userId); conn != nil {
+		if conn.SessionId == sessionId {
+			conn.CloseWithReason(code, reason)
+		}
+		return
+	}
+
+	routes, err := cache.GetWsRouteCache(s.rdb, userId)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.Any("userId", userId), zap.String("sessionId", sessionId))
+		return
+	}
+	if route, ok := routes[userId]; ok && route.NodeId != s.nodeId && route.SessionId == sessionId {
+		s.publish(route.NodeId, &nodeMessage{
+			Type:      nodeMsgClose,
+			UserIds:   []int64{userId},
+			SessionId: sessionId,
+			Code:      code,
+			Reason:    reason,
+		})
+	}
+}
+
+func (s *wsServer) publish(nodeId string, msg *nodeMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		s.logger.Error(err.Error(), zap.String("nodeId", nodeId))
+		return
+	}
+	if err = cache.PublishWsNodeMsg(s.rdb, nodeId, data); err != nil {
+		s.logger.Error(err.Error(), zap.String("nodeId", nodeId), zap.Any("userIds", msg.UserIds))
+	}
+}
+
+func (s *wsServer) Start(ctx context.Context) error {
+	pubsub := cache.SubscribeWsNode(s.rdb, s.nodeId)
+	defer pubsub.Close()
+	ch := pubsub.Channel()
+	s.logger.Info("ws node started", zap.String("nodeId", s.nodeId))
+
+	ticker := time.NewTicker(s.routeTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
 			}
+			s.handleNodeMessage(msg.Payload)
+		case <-ticker.C:
+			s.refreshRoutes()
+		case <-s.done:
+			return nil
+		case <-ctx.Done():
+			return nil
 		}
 	}
+}
+
+func (s *wsServer) Submit(task func()) error {
+	atomic.AddInt64(&s.pending, 1)
+	err := s.pool.Submit(func() {
+		defer atomic.AddInt64(&s.pending, -1)
+		task()
+	})
+	if err != nil {
+		atomic.AddInt64(&s.pending, -1)
+	}
+	return err
+}
+
+func (s *wsServer) Draining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+func (s *wsServer) Stop(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&s.draining, 0, 1) {
+		return nil
+	}
+	deadline := time.Now().Add(s.drainTimeout)
+	s.logger.Info("ws node draining", zap.String("nodeId", s.nodeId))
+
+	// 重连延迟随机分散，避免客户端同时涌向其他节点
+	s.connMgr.Range(func(conn *WsConn) bool {
+		delay := time.Duration(rand.Int63n(int64(s.reconnectJitter)))
+		if err := conn.WriteFrame(CommandFrame(&Command{Cmd: "reconnect", Delay: delay.Milliseconds()})); err != nil {
+			s.logger.Debug(err.Error(), zap.Any("userId", conn.ConnId))
+		}
+		return true
+	})
+
+	// 等待已提交的推送任务
+	for atomic.LoadInt64(&s.pending) > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if n := atomic.LoadInt64(&s.pending); n > 0 {
+		s.logger.Warn("ws drain timeout with pending tasks", zap.Int64("pending", n))
+	}
+
+	var wg sync.WaitGroup
+	s.connMgr.Range(func(conn *WsConn) bool {
+		wg.Add(1)
+		go func(conn *WsConn) {
+			defer wg.Done()
+			conn.Drain(websocket.CloseServiceRestart, "server shutting down", time.Until(deadline))
+		}(conn)
+		return true
+	})
+	wg.Wait()
+
+	s.stopOnce.Do(func() {
+		close(s.done)
+	})
+	s.logger.Info("ws node stopped", zap.String("nodeId", s.nodeId))
 	return nil
 }
+
+func (s *wsServer) handleNodeMessage(data string) {
+	var msg nodeMessage
+	if err := json.Unmarshal([]byte(data), &msg); err != nil {
+		s.logger.Error(err.Error(), zap.String("data", data))
+		return
+	}
+
+	switch msg.Type {
+	case nodeMsgPush:
+		for _, v := range msg.UserIds {
+			s.pushLocal(msg.Frame, v)
+		}
+	case nodeMsgClose:
+		for _, v := range msg.UserIds {
+			if conn := s.connMgr.GetConn(v); conn != nil && conn.SessionId == msg.SessionId {
+				conn.CloseWithReason(msg.Code, msg.Reason)
+			}
+		}
+	case nodeMsgBroadcast:
+		s.connMgr.Range(func(conn *WsConn) bool {
+			if err := conn.WriteFrame(msg.Frame); err != nil {
+				s.logger.Debug(err.Error(), zap.Any("userId", conn.ConnId))
+			}
+			return true
+		})
+	}
+}
+
+// 续期本节点连接的路由，节点宕机后路由会自然过期
+func (s *wsServer) refreshRoutes() {
+	routes := make(map[int64]model.WsRoute)
+	s.connMgr.Range(func(conn *WsConn) bool {
+		routes[conn.ConnId] = model.WsRoute{NodeId: s.nodeId, SessionId: conn.SessionId}
+		return true
+	})
+	if err := cache.ExpireWsRouteCache(s.rdb, routes, s.routeTTL); err != nil {
+		s.logger.Error(err.Error(), zap.String("nodeId", s.nodeId))
+	}
+}
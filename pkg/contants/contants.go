@@ -11,22 +11,112 @@ const (
 	//关系类型
 	RelationshipTypeFriend = 1 //好友关系
 	RelationshipTypeFollow = 2 //关注
+	RelationshipTypeBlock  = 3 //黑名单
 
 	//关系状态
 	RelationshipStatusNormal = 1 //正常
 	RelationshipStatusBlock  = 2 //拉黑
 	RelationshipStatusDel    = 3 //删除
 
+	RelationshipTagLimit = 50 //每个用户最多创建的分组标签数
+
+	//用户状态
+	UserStatusAbnormal = 0 //异常
+	UserStatusNormal   = 1 //正常
+	UserStatusDeleted  = 2 //已注销
+
+	DeletedUserNickName = "已注销用户" //注销后资料匿名化显示的昵称
+
+	//资料字段的可见范围
+	ProfileVisibilityPublic  = 1 //所有人
+	ProfileVisibilityFriend  = 2 //仅好友
+	ProfileVisibilityPrivate = 3 //仅自己
+
+	//谁可以给我发消息
+	MsgAllowTypeDefault  = 0 //使用服务端配置
+	MsgAllowTypeAnyone   = 1 //所有人
+	MsgAllowTypeFriend   = 2 //仅好友
+	MsgAllowTypeFollower = 3 //好友和关注我的人
+
+	//允许通过哪些方式搜索到我，按位组合
+	DiscoverByEmail    = 1 //邮箱精确搜索
+	DiscoverByPhone    = 2 //手机号精确搜索
+	DiscoverByNickName = 4 //昵称前缀搜索
+	DiscoverAll        = DiscoverByEmail | DiscoverByPhone | DiscoverByNickName
+
+	//验证码发送渠道
+	VerifyChannelSMS   = "sms"
+	VerifyChannelEmail = "email"
+
+	//验证码使用场景
+	VerifySceneRegister = "register" //注册
+	VerifySceneLogin    = "login"    //验证码登录
+	//以下场景发送到当前账号已绑定的手机号，用于未设置过密码的账号代替密码校验
+	VerifySceneUpdateAccount = "update_account" //修改邮箱、手机号、密码
+	VerifySceneDeleteAccount = "delete_account" //注销账号
+
+	//搜索结果中与我的关系
+	UserRelationNone    = 0 //无关系
+	UserRelationFriend  = 1 //好友
+	UserRelationPending = 2 //好友申请中
+	UserRelationBlocked = 3 //我已拉黑对方
+
+	//会话的消息请求状态
+	//消息状态
+	MsgStatusNormal   = 0 //可见
+	MsgStatusBlocked  = 1 //屏蔽，只有发送者可见
+	MsgStatusRecalled = 2 //撤回
+
+	MsgRequestStatusNone    = 0 //正常会话
+	MsgRequestStatusPending = 1 //陌生人消息，待处理
+	MsgRequestStatusIgnored = 2 //已忽略
+
 	ConversationTypeC2C   = 0 //单聊
 	ConversationTypeGroup = 1 //群聊
 
+	ConversationStatusNormal    = 0 //正常
+	ConversationStatusDissolved = 1 //已解散
+
 	MsgTypeNotify  = 1 //通知消息
 	MsgTypeCommand = 2 //指令消息
 	MsgTypeChat    = 3 //普通聊天消息
+	MsgTypeAuth    = 4 //连接后首帧认证
+	MsgTypeError   = 5 //错误消息，如发送被限流
 
 	ChatSayHello = "从此我们是好友关系啦！"
 
 	MsgContentTypeTxt   = 1 //文字
 	MsgContentTypeImg   = 2 //语音
 	MsgContentTypeVideo = 3 //视频
+
+	MsgContentTypeSystem = 10 //系统消息
+
+	//举报对象
+	ReportTargetMsg   = 1 //消息
+	ReportTargetUser  = 2 //用户
+	ReportTargetGroup = 3 //群组，对象ID为会话ID
+
+	//举报原因
+	ReportReasonSpam       = 1 //垃圾广告
+	ReportReasonHarassment = 2 //骚扰辱骂
+	ReportReasonPorn       = 3 //色情低俗
+	ReportReasonIllegal    = 4 //违法违规
+	ReportReasonFraud      = 5 //诈骗
+	ReportReasonOther      = 9 //其他
+
+	//举报处理状态
+	ReportStatusPending   = 0 //待处理
+	ReportStatusResolved  = 1 //已处理
+	ReportStatusDismissed = 2 //已驳回
+
+	//举报处理动作
+	ReportActionNone = 0 //不处理
+	ReportActionHide = 1 //屏蔽被举报的消息
+	ReportActionMute = 2 //禁言
+	ReportActionBan  = 3 //封禁
+
+	//服务端通知事件
+	NotifyEventReportResult  = "report_result"  //举报处理结果
+	NotifyEventAnnouncement  = "announcement"   //系统公告
+	NotifyEventGroupDissolve = "group_dissolve" //群组被解散
 )
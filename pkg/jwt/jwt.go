@@ -1,7 +1,14 @@
 package jwt
 
 import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -9,8 +16,20 @@ import (
 	"github.com/spf13/viper"
 )
 
+// 签名算法：HS256 使用security.jwt.key；RS256/EdDSA 使用security.jwt.keys中的密钥对，
+// active_kid对应的私钥签名，列表中所有公钥都可以校验，轮换时旧公钥保留至少一个access token有效期
 type JWT struct {
-	key []byte
+	method     jwt.SigningMethod
+	kid        string                 //签名使用的kid
+	signKey    interface{}            //签名密钥
+	verifyKeys map[string]interface{} //kid -> 校验密钥
+}
+
+// 一个密钥，私钥可签名也可校验，只配公钥的仅用于校验
+type keyConfig struct {
+	Kid            string `mapstructure:"kid"`
+	PrivateKeyFile string `mapstructure:"private_key_file"` //PKCS8 PEM
+	PublicKeyFile  string `mapstructure:"public_key_file"`  //PKIX PEM
 }
 
 type MyCustomClaims struct {
@@ -18,12 +37,107 @@ type MyCustomClaims struct {
 	jwt.RegisteredClaims
 }
 
-func NewJwt(conf *viper.Viper) *JWT {
-	return &JWT{key: []byte(conf.GetString("security.jwt.key"))}
+func NewJwt(conf *viper.Viper) (*JWT, error) {
+	alg := conf.GetString("security.jwt.algorithm")
+	if alg == "" || alg == jwt.SigningMethodHS256.Alg() {
+		key := []byte(conf.GetString("security.jwt.key"))
+		if len(key) == 0 {
+			return nil, errors.New("jwt: security.jwt.key is empty")
+		}
+		return &JWT{
+			method:     jwt.SigningMethodHS256,
+			signKey:    key,
+			verifyKeys: map[string]interface{}{"": key},
+		}, nil
+	}
+
+	j := &JWT{
+		kid:        conf.GetString("security.jwt.active_kid"),
+		verifyKeys: make(map[string]interface{}),
+	}
+	switch alg {
+	case jwt.SigningMethodRS256.Alg():
+		j.method = jwt.SigningMethodRS256
+	case jwt.SigningMethodEdDSA.Alg():
+		j.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("jwt: unsupported algorithm %v", alg)
+	}
+
+	var keys []keyConfig
+	if err := conf.UnmarshalKey("security.jwt.keys", &keys); err != nil {
+		return nil, err
+	}
+	for _, v := range keys {
+		if v.Kid == "" {
+			return nil, errors.New("jwt: key without kid")
+		}
+		if _, ok := j.verifyKeys[v.Kid]; ok {
+			return nil, fmt.Errorf("jwt: duplicate kid %v", v.Kid)
+		}
+
+		if v.PrivateKeyFile != "" {
+			private, public, err := j.loadPrivateKey(v.PrivateKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("jwt: load key %v: %w", v.Kid, err)
+			}
+			j.verifyKeys[v.Kid] = public
+			if v.Kid == j.kid {
+				j.signKey = private
+			}
+			continue
+		}
+
+		public, err := j.loadPublicKey(v.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: load key %v: %w", v.Kid, err)
+		}
+		j.verifyKeys[v.Kid] = public
+	}
+
+	if j.signKey == nil {
+		return nil, fmt.Errorf("jwt: no private key for active_kid %v", j.kid)
+	}
+	return j, nil
 }
 
-func (j *JWT) GenToken(userId int64, expiresAt time.Time) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, MyCustomClaims{
+func (j *JWT) loadPrivateKey(file string) (interface{}, interface{}, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	if j.method == jwt.SigningMethodRS256 {
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, &key.PublicKey, nil
+	}
+	key, err := jwt.ParseEdPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("not an ed25519 private key")
+	}
+	return private, private.Public(), nil
+}
+
+func (j *JWT) loadPublicKey(file string) (interface{}, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if j.method == jwt.SigningMethodRS256 {
+		return jwt.ParseRSAPublicKeyFromPEM(data)
+	}
+	return jwt.ParseEdPublicKeyFromPEM(data)
+}
+
+// sessionId写入jti，用于吊销
+func (j *JWT) GenToken(userId int64, sessionId string, expiresAt time.Time) (string, error) {
+	token := jwt.NewWithClaims(j.method, MyCustomClaims{
 		UserId: userId,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
@@ -31,13 +145,16 @@ func (j *JWT) GenToken(userId int64, expiresAt time.Time) (string, error) {
 			NotBefore: jwt.NewNumericDate(time.Now()),
 			Issuer:    "",
 			Subject:   "",
-			ID:        "",
+			ID:        sessionId,
 			Audience:  []string{},
 		},
 	})
+	if j.kid != "" {
+		token.Header["kid"] = j.kid
+	}
 
 	// Sign and get the complete encoded token as a string using the key
-	tokenString, err := token.SignedString(j.key)
+	tokenString, err := token.SignedString(j.signKey)
 	if err != nil {
 		return "", err
 	}
@@ -50,8 +167,13 @@ func (j *JWT) ParseToken(tokenString string) (*MyCustomClaims, error) {
 		return nil, errors.New("token is empty")
 	}
 	token, err := jwt.ParseWithClaims(tokenString, &MyCustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return j.key, nil
-	})
+		kid, _ := token.Header["kid"].(string)
+		key, ok := j.verifyKeys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		return key, nil
+	}, jwt.WithValidMethods([]string{j.method.Alg()})) // 只接受配置的算法，防止算法混淆
 	if err != nil {
 		return nil, err
 	}
@@ -61,3 +183,48 @@ func (j *JWT) ParseToken(tokenString string) (*MyCustomClaims, error) {
 		return nil, err
 	}
 }
+
+// JWK 公钥，RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"` //OKP
+	X   string `json:"x,omitempty"`   //OKP
+	N   string `json:"n,omitempty"`   //RSA
+	E   string `json:"e,omitempty"`   //RSA
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS 所有可用于校验的公钥，HS256时为空，密钥不能公开
+func (j *JWT) JWKS() JWKS {
+	set := JWKS{Keys: make([]JWK, 0, len(j.verifyKeys))}
+	for kid, key := range j.verifyKeys {
+		switch k := key.(type) {
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: kid,
+				Use: "sig",
+				Alg: j.method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(k),
+			})
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				Alg: j.method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+			})
+		}
+	}
+	sort.Slice(set.Keys, func(i, k int) bool { return set.Keys[i].Kid < set.Keys[k].Kid })
+	return set
+}
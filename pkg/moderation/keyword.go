@@ -0,0 +1,232 @@
+package moderation
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/ljinf/im_server_standalone/pkg/log"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+type keyword struct {
+	word   string
+	length int //字符数
+	action Action
+}
+
+type acNode struct {
+	next map[rune]int32
+	fail int32
+	out  []int32 //以该节点结尾的词，包含fail链上的
+}
+
+// Aho-Corasick自动机，构建后只读，可以并发匹配
+type automaton struct {
+	nodes []acNode
+	words []keyword
+}
+
+func buildAutomaton(words []keyword) *automaton {
+	a := &automaton{
+		nodes: []acNode{{next: map[rune]int32{}}},
+		words: words,
+	}
+	for i, w := range words {
+		cur := int32(0)
+		for _, r := range w.word {
+			nxt, ok := a.nodes[cur].next[r]
+			if !ok {
+				a.nodes = append(a.nodes, acNode{next: map[rune]int32{}})
+				nxt = int32(len(a.nodes) - 1)
+				a.nodes[cur].next[r] = nxt
+			}
+			cur = nxt
+		}
+		a.nodes[cur].out = append(a.nodes[cur].out, int32(i))
+	}
+
+	// 按层构建fail指针，父节点的fail先于子节点确定
+	queue := make([]int32, 0, len(a.nodes))
+	for _, child := range a.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for r, child := range a.nodes[cur].next {
+			f := a.nodes[cur].fail
+			for f > 0 {
+				if _, ok := a.nodes[f].next[r]; ok {
+					break
+				}
+				f = a.nodes[f].fail
+			}
+			if nxt, ok := a.nodes[f].next[r]; ok && nxt != child {
+				a.nodes[child].fail = nxt
+			}
+			a.nodes[child].out = append(a.nodes[child].out, a.nodes[a.nodes[child].fail].out...)
+			queue = append(queue, child)
+		}
+	}
+	return a
+}
+
+// 对每个命中回调，[start,end)为命中的字符范围
+func (a *automaton) match(text []rune, fn func(start, end int, kw *keyword)) {
+	cur := int32(0)
+	for i, r := range text {
+		r = unicode.ToLower(r)
+		for {
+			if nxt, ok := a.nodes[cur].next[r]; ok {
+				cur = nxt
+				break
+			}
+			if cur == 0 {
+				break
+			}
+			cur = a.nodes[cur].fail
+		}
+		for _, idx := range a.nodes[cur].out {
+			kw := &a.words[idx]
+			fn(i+1-kw.length, i+1, kw)
+		}
+	}
+}
+
+// KeywordModerator 敏感词过滤，词库文件每行一个词，可用 词|动作 指定单独的动作，#开头为注释。
+// 定期检查文件修改时间，变化后重新加载
+type KeywordModerator struct {
+	logger        *log.Logger
+	file          string
+	defaultAction Action
+	ac            atomic.Pointer[automaton]
+	modTime       time.Time
+}
+
+func NewKeywordModerator(conf *viper.Viper, logger *log.Logger) (*KeywordModerator, error) {
+	file := conf.GetString("moderation.keyword.file")
+	if file == "" {
+		return nil, fmt.Errorf("moderation: moderation.keyword.file is empty")
+	}
+	defaultAction := ActionMask
+	if v := conf.GetString("moderation.keyword.action"); v != "" {
+		a, err := ParseAction(v)
+		if err != nil {
+			return nil, err
+		}
+		defaultAction = a
+	}
+
+	m := &KeywordModerator{
+		logger:        logger,
+		file:          file,
+		defaultAction: defaultAction,
+	}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+
+	interval := conf.GetDuration("moderation.keyword.reload_interval")
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	go m.watch(interval)
+	return m, nil
+}
+
+func (m *KeywordModerator) Name() string {
+	return "keyword"
+}
+
+// Reload 重新加载词库，失败时保留原词库
+func (m *KeywordModerator) Reload() error {
+	info, err := os.Stat(m.file)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(m.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	words := make([]keyword, 0, 1024)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		word, action := text, m.defaultAction
+		if i := strings.LastIndex(text, "|"); i > 0 {
+			if action, err = ParseAction(text[i+1:]); err != nil {
+				return fmt.Errorf("moderation: %v line %d: %w", m.file, line, err)
+			}
+			word = strings.TrimSpace(text[:i])
+		}
+		word = strings.ToLower(word)
+		words = append(words, keyword{word: word, length: len([]rune(word)), action: action})
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	m.ac.Store(buildAutomaton(words))
+	m.modTime = info.ModTime()
+	m.logger.Info("moderation keywords loaded", zap.String("file", m.file), zap.Int("count", len(words)))
+	return nil
+}
+
+func (m *KeywordModerator) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		info, err := os.Stat(m.file)
+		if err != nil {
+			m.logger.Error("moderation keywords stat error", zap.String("file", m.file), zap.Error(err))
+			continue
+		}
+		if info.ModTime().Equal(m.modTime) {
+			continue
+		}
+		if err = m.Reload(); err != nil {
+			m.logger.Error("moderation keywords reload error", zap.String("file", m.file), zap.Error(err))
+		}
+	}
+}
+
+func (m *KeywordModerator) Moderate(ctx context.Context, in *Input) (*Result, error) {
+	ac := m.ac.Load()
+	if ac == nil || in.Content == "" {
+		return nil, nil
+	}
+
+	runes := []rune(in.Content)
+	var hit *keyword
+	masked := false
+	ac.match(runes, func(start, end int, kw *keyword) {
+		if kw.action == ActionMask {
+			maskRunes(runes, start, end)
+			masked = true
+		}
+		if hit == nil || kw.action > hit.action {
+			hit = kw
+		}
+	})
+	if hit == nil {
+		return nil, nil
+	}
+
+	r := &Result{Action: hit.action, Content: in.Content, Rule: hit.word}
+	if masked {
+		r.Content = string(runes)
+	}
+	return r, nil
+}
@@ -0,0 +1,179 @@
+package moderation
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ljinf/im_server_standalone/pkg/log"
+	"go.uber.org/zap"
+)
+
+func testLogger() *log.Logger {
+	return &log.Logger{Logger: zap.NewNop()}
+}
+
+type hit struct {
+	start, end int
+	word       string
+}
+
+func TestAutomatonMatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		words []string
+		text  string
+		want  []hit
+	}{
+		{
+			name:  "经典重叠",
+			words: []string{"he", "she", "his", "hers"},
+			text:  "ushers",
+			want:  []hit{{1, 4, "she"}, {2, 4, "he"}, {2, 6, "hers"}},
+		},
+		{
+			name:  "长词失配后走fail命中后缀词",
+			words: []string{"abcd", "bc"},
+			text:  "abce",
+			want:  []hit{{1, 3, "bc"}},
+		},
+		{
+			name:  "词是另一个词的后缀",
+			words: []string{"abcd", "cd"},
+			text:  "xabcd",
+			want:  []hit{{1, 5, "abcd"}, {3, 5, "cd"}},
+		},
+		{
+			name:  "中文重叠",
+			words: []string{"傻瓜", "瓜蛋"},
+			text:  "你这傻瓜蛋",
+			want:  []hit{{2, 4, "傻瓜"}, {3, 5, "瓜蛋"}},
+		},
+		{
+			name:  "重复出现",
+			words: []string{"aa"},
+			text:  "aaa",
+			want:  []hit{{0, 2, "aa"}, {1, 3, "aa"}},
+		},
+		{
+			name:  "忽略大小写",
+			words: []string{"spam"},
+			text:  "SpAm!",
+			want:  []hit{{0, 4, "spam"}},
+		},
+		{
+			name:  "未命中",
+			words: []string{"abc"},
+			text:  "ababab",
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			words := make([]keyword, 0, len(tt.words))
+			for _, w := range tt.words {
+				words = append(words, keyword{word: w, length: len([]rune(w)), action: ActionMask})
+			}
+			var got []hit
+			buildAutomaton(words).match([]rune(tt.text), func(start, end int, kw *keyword) {
+				got = append(got, hit{start, end, kw.word})
+			})
+			sort.Slice(got, func(i, j int) bool {
+				if got[i].start != got[j].start {
+					return got[i].start < got[j].start
+				}
+				return got[i].end < got[j].end
+			})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func newTestKeywordModerator(t *testing.T, content string) *KeywordModerator {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "keywords.txt")
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m := &KeywordModerator{logger: testLogger(), file: file, defaultAction: ActionMask}
+	if err := m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestKeywordModerate(t *testing.T) {
+	m := newTestKeywordModerator(t, `# 注释
+傻瓜
+瓜蛋
+Spam
+刷单|block
+代开发票|reject
+`)
+	tests := []struct {
+		content string
+		action  Action
+		masked  string
+		rule    string
+	}{
+		{content: "你好", action: ActionAllow},
+		{content: "你这傻瓜蛋", action: ActionMask, masked: "你这***", rule: "傻瓜"},
+		{content: "傻瓜，瓜蛋", action: ActionMask, masked: "**，**", rule: "傻瓜"},
+		{content: "no SPAM here", action: ActionMask, masked: "no **** here", rule: "spam"},
+		{content: "傻瓜刷单", action: ActionBlock, masked: "**刷单", rule: "刷单"},
+		{content: "刷单代开发票", action: ActionReject, masked: "刷单代开发票", rule: "代开发票"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.content, func(t *testing.T) {
+			r, err := m.Moderate(context.Background(), &Input{Content: tt.content})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.action == ActionAllow {
+				if r != nil {
+					t.Fatalf("result = %+v, want nil", r)
+				}
+				return
+			}
+			if r == nil {
+				t.Fatalf("result = nil, want %v", tt.action)
+			}
+			if r.Action != tt.action || r.Content != tt.masked || r.Rule != tt.rule {
+				t.Fatalf("result = {%v %q %q}, want {%v %q %q}", r.Action, r.Content, r.Rule, tt.action, tt.masked, tt.rule)
+			}
+		})
+	}
+}
+
+func TestKeywordReload(t *testing.T) {
+	m := newTestKeywordModerator(t, "旧词\n")
+
+	if err := os.WriteFile(m.file, []byte("新词|reject\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := m.Moderate(context.Background(), &Input{Content: "旧词"}); r != nil {
+		t.Fatalf("旧词 result = %+v, want nil", r)
+	}
+	if r, _ := m.Moderate(context.Background(), &Input{Content: "新词"}); r == nil || r.Action != ActionReject {
+		t.Fatalf("新词 result = %+v, want reject", r)
+	}
+
+	// 词库有错误时保留原词库
+	if err := os.WriteFile(m.file, []byte("坏词|unknown\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reload(); err == nil {
+		t.Fatal("reload error = nil, want unknown action")
+	}
+	if r, _ := m.Moderate(context.Background(), &Input{Content: "新词"}); r == nil || r.Action != ActionReject {
+		t.Fatalf("新词 after bad reload = %+v, want reject", r)
+	}
+}
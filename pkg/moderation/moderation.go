@@ -0,0 +1,150 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ljinf/im_server_standalone/pkg/log"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Action 审核结果，数值越大越严格，多个审核器取最严格的
+type Action int
+
+const (
+	ActionAllow  Action = iota //放行
+	ActionMask                 //命中内容替换为*后放行
+	ActionBlock                //保存但标记为屏蔽，只有发送者可见
+	ActionReject               //拒绝发送
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionAllow:
+		return "allow"
+	case ActionMask:
+		return "mask"
+	case ActionBlock:
+		return "block"
+	case ActionReject:
+		return "reject"
+	}
+	return fmt.Sprintf("action(%d)", int(a))
+}
+
+func ParseAction(s string) (Action, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "allow":
+		return ActionAllow, nil
+	case "mask":
+		return ActionMask, nil
+	case "block":
+		return ActionBlock, nil
+	case "reject":
+		return ActionReject, nil
+	}
+	return ActionAllow, fmt.Errorf("moderation: unknown action %q", s)
+}
+
+// Input 待审核的消息
+type Input struct {
+	UserId         int64  `json:"user_id"`
+	TargetId       int64  `json:"target_id"`
+	ConversationId int64  `json:"conversation_id"`
+	ContentType    int    `json:"content_type"`
+	Content        string `json:"content"`
+}
+
+// Result 审核结果，Content为处理后的内容
+type Result struct {
+	Action    Action
+	Content   string
+	Moderator string //做出决定的审核器
+	Rule      string //命中的规则，如敏感词、正则名
+	Reason    string
+}
+
+// Moderator 审核器，返回nil表示放行
+type Moderator interface {
+	Name() string
+	Moderate(ctx context.Context, in *Input) (*Result, error)
+}
+
+// Pipeline 按顺序执行审核器：mask后的内容交给下一个审核器，reject立即结束，最终取最严格的结果
+type Pipeline struct {
+	logger     *log.Logger
+	moderators []Moderator
+}
+
+func NewPipeline(logger *log.Logger, moderators ...Moderator) *Pipeline {
+	return &Pipeline{logger: logger, moderators: moderators}
+}
+
+// 按moderation配置创建审核器，未开启的不加入
+func NewPipelineFromConf(conf *viper.Viper, logger *log.Logger) (*Pipeline, error) {
+	moderators := make([]Moderator, 0, 3)
+	if conf.GetBool("moderation.keyword.enable") {
+		m, err := NewKeywordModerator(conf, logger)
+		if err != nil {
+			return nil, err
+		}
+		moderators = append(moderators, m)
+	}
+	if rules := conf.Get("moderation.regex"); rules != nil {
+		m, err := NewRegexModerator(conf)
+		if err != nil {
+			return nil, err
+		}
+		moderators = append(moderators, m)
+	}
+	if conf.GetBool("moderation.webhook.enable") {
+		m, err := NewWebhookModerator(conf, logger)
+		if err != nil {
+			return nil, err
+		}
+		moderators = append(moderators, m)
+	}
+	return NewPipeline(logger, moderators...), nil
+}
+
+// 审核器出错时跳过，由审核器自己决定失败时是否放行
+func (p *Pipeline) Moderate(ctx context.Context, in *Input) *Result {
+	final := &Result{Action: ActionAllow, Content: in.Content}
+	cur := *in
+	for _, m := range p.moderators {
+		r, err := m.Moderate(ctx, &cur)
+		if err != nil {
+			p.logger.WithContext(ctx).Error("moderation error", zap.String("moderator", m.Name()), zap.Error(err))
+			continue
+		}
+		if r == nil || r.Action == ActionAllow {
+			continue
+		}
+		if r.Moderator == "" {
+			r.Moderator = m.Name()
+		}
+		if r.Action == ActionMask && r.Content != "" {
+			cur.Content = r.Content
+		}
+		if r.Action > final.Action {
+			final.Action = r.Action
+			final.Moderator = r.Moderator
+			final.Rule = r.Rule
+			final.Reason = r.Reason
+		}
+		if r.Action == ActionReject {
+			break
+		}
+	}
+	final.Content = cur.Content
+	return final
+}
+
+// 替换[start,end)范围的字符为*
+func maskRunes(runes []rune, start, end int) {
+	for i := start; i < end && i < len(runes); i++ {
+		runes[i] = '*'
+	}
+}
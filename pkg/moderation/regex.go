@@ -0,0 +1,73 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/spf13/viper"
+)
+
+type regexRule struct {
+	name   string
+	re     *regexp.Regexp
+	action Action
+}
+
+// RegexModerator 按配置的正则规则过滤，如手机号、外链等引流内容
+type RegexModerator struct {
+	rules []regexRule
+}
+
+func NewRegexModerator(conf *viper.Viper) (*RegexModerator, error) {
+	var items []struct {
+		Name    string `mapstructure:"name"`
+		Pattern string `mapstructure:"pattern"`
+		Action  string `mapstructure:"action"`
+	}
+	if err := conf.UnmarshalKey("moderation.regex", &items); err != nil {
+		return nil, err
+	}
+
+	m := &RegexModerator{rules: make([]regexRule, 0, len(items))}
+	for _, v := range items {
+		re, err := regexp.Compile(v.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("moderation: regex %v: %w", v.Name, err)
+		}
+		action, err := ParseAction(v.Action)
+		if err != nil {
+			return nil, fmt.Errorf("moderation: regex %v: %w", v.Name, err)
+		}
+		m.rules = append(m.rules, regexRule{name: v.Name, re: re, action: action})
+	}
+	return m, nil
+}
+
+func (m *RegexModerator) Name() string {
+	return "regex"
+}
+
+func (m *RegexModerator) Moderate(ctx context.Context, in *Input) (*Result, error) {
+	var result *Result
+	content := in.Content
+	for _, rule := range m.rules {
+		if !rule.re.MatchString(content) {
+			continue
+		}
+		if rule.action == ActionMask {
+			content = rule.re.ReplaceAllStringFunc(content, func(s string) string {
+				runes := []rune(s)
+				maskRunes(runes, 0, len(runes))
+				return string(runes)
+			})
+		}
+		if result == nil || rule.action > result.Action {
+			result = &Result{Action: rule.action, Rule: rule.name}
+		}
+	}
+	if result != nil {
+		result.Content = content
+	}
+	return result, nil
+}
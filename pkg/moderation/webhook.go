@@ -0,0 +1,113 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ljinf/im_server_standalone/pkg/log"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// WebhookResponse 审核服务的返回，action为 allow、mask、block、reject，mask时content为处理后的内容
+type WebhookResponse struct {
+	Action  string `json:"action"`
+	Content string `json:"content"`
+	Rule    string `json:"rule"`
+	Reason  string `json:"reason"`
+}
+
+// WebhookModerator 把消息POST给外部审核服务，请求体为Input的JSON。
+// 请求失败时按fail_action处理，默认放行
+type WebhookModerator struct {
+	logger     *log.Logger
+	url        string
+	secret     string
+	client     *http.Client
+	failAction Action
+}
+
+func NewWebhookModerator(conf *viper.Viper, logger *log.Logger) (*WebhookModerator, error) {
+	url := conf.GetString("moderation.webhook.url")
+	if url == "" {
+		return nil, fmt.Errorf("moderation: moderation.webhook.url is empty")
+	}
+	timeout := conf.GetDuration("moderation.webhook.timeout")
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	failAction := ActionAllow
+	if v := conf.GetString("moderation.webhook.fail_action"); v != "" {
+		a, err := ParseAction(v)
+		if err != nil {
+			return nil, err
+		}
+		failAction = a
+	}
+	return &WebhookModerator{
+		logger:     logger,
+		url:        url,
+		secret:     conf.GetString("moderation.webhook.secret"),
+		client:     &http.Client{Timeout: timeout},
+		failAction: failAction,
+	}, nil
+}
+
+func (m *WebhookModerator) Name() string {
+	return "webhook"
+}
+
+func (m *WebhookModerator) Moderate(ctx context.Context, in *Input) (*Result, error) {
+	resp, err := m.call(ctx, in)
+	if err != nil {
+		m.logger.WithContext(ctx).Error("moderation webhook error", zap.String("url", m.url), zap.Error(err))
+		if m.failAction == ActionAllow {
+			return nil, nil
+		}
+		return &Result{Action: m.failAction, Content: in.Content, Reason: "webhook unavailable"}, nil
+	}
+
+	action, err := ParseAction(resp.Action)
+	if err != nil {
+		return nil, err
+	}
+	r := &Result{Action: action, Content: in.Content, Rule: resp.Rule, Reason: resp.Reason}
+	if action == ActionMask && resp.Content != "" {
+		r.Content = resp.Content
+	}
+	return r, nil
+}
+
+func (m *WebhookModerator) call(ctx context.Context, in *Input) (*WebhookResponse, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if m.secret != "" {
+		req.Header.Set("Authorization", "Bearer "+m.secret)
+	}
+
+	res, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %v", res.StatusCode)
+	}
+
+	var resp WebhookResponse
+	if err = json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
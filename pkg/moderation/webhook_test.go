@@ -0,0 +1,111 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func newTestWebhookModerator(t *testing.T, url, failAction string) *WebhookModerator {
+	t.Helper()
+	conf := viper.New()
+	conf.Set("moderation.webhook.url", url)
+	conf.Set("moderation.webhook.secret", "secret")
+	conf.Set("moderation.webhook.timeout", 200*time.Millisecond)
+	conf.Set("moderation.webhook.fail_action", failAction)
+	m, err := NewWebhookModerator(conf, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestWebhookModerate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var in Input
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resp := WebhookResponse{Action: "allow"}
+		switch in.Content {
+		case "mask me":
+			resp = WebhookResponse{Action: "mask", Content: "**** me", Rule: "mask"}
+		case "block me":
+			resp = WebhookResponse{Action: "block", Rule: "block", Reason: "stub"}
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	m := newTestWebhookModerator(t, srv.URL, "")
+	tests := []struct {
+		content string
+		action  Action
+		result  string
+	}{
+		{content: "hello", action: ActionAllow, result: "hello"},
+		{content: "mask me", action: ActionMask, result: "**** me"},
+		{content: "block me", action: ActionBlock, result: "block me"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.content, func(t *testing.T) {
+			r, err := m.Moderate(context.Background(), &Input{UserId: 1, Content: tt.content})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Action != tt.action || r.Content != tt.result {
+				t.Fatalf("result = {%v %q}, want {%v %q}", r.Action, r.Content, tt.action, tt.result)
+			}
+		})
+	}
+}
+
+func TestWebhookFailAction(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	invalid := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("not json"))
+	}))
+	defer invalid.Close()
+
+	servers := map[string]string{"status 500": failing.URL, "timeout": slow.URL, "invalid body": invalid.URL}
+	for name, url := range servers {
+		t.Run(name, func(t *testing.T) {
+			// 默认放行
+			r, err := newTestWebhookModerator(t, url, "").Moderate(context.Background(), &Input{Content: "hi"})
+			if err != nil || r != nil {
+				t.Fatalf("default fail_action = %+v, %v, want nil, nil", r, err)
+			}
+
+			for _, action := range []Action{ActionBlock, ActionReject} {
+				m := newTestWebhookModerator(t, url, action.String())
+				r, err = m.Moderate(context.Background(), &Input{Content: "hi"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if r == nil || r.Action != action || r.Content != "hi" {
+					t.Fatalf("fail_action %v = %+v", action, r)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package rbac
+
+import (
+	"sort"
+
+	"github.com/spf13/viper"
+)
+
+// 管理后台的权限
+const (
+	PermUserView      = "user:view"      //查询用户
+	PermUserBan       = "user:ban"       //封禁、解封
+	PermUserLogout    = "user:logout"    //强制下线
+	PermGroupView     = "group:view"     //查看群组
+	PermGroupDissolve = "group:dissolve" //解散群组
+	PermAnnounce      = "announce:send"  //发布系统公告
+	PermMsgView       = "msg:view"       //查看会话消息，用于合规审查
+	PermStatsView     = "stats:view"     //实时统计
+	PermReportView    = "report:view"    //查看举报
+	PermReportHandle  = "report:handle"  //处理举报
+	PermAdminManage   = "admin:manage"   //管理员和角色分配
+
+	// 拥有所有权限
+	PermAll = "*"
+)
+
+// 内置角色，可在admin.roles中覆盖或新增
+const (
+	RoleSuper    = "super"    //超级管理员
+	RoleOperator = "operator" //运营
+	RoleAuditor  = "auditor"  //审核，可以查看消息
+)
+
+var defaultRoles = map[string][]string{
+	RoleSuper: {PermAll},
+	RoleOperator: {PermUserView, PermUserBan, PermUserLogout, PermGroupView, PermGroupDissolve,
+		PermAnnounce, PermStatsView, PermReportView, PermReportHandle},
+	RoleAuditor: {PermUserView, PermGroupView, PermMsgView, PermStatsView, PermReportView, PermReportHandle},
+}
+
+// RBAC 角色和权限的对应关系，创建后只读
+type RBAC struct {
+	roles map[string]map[string]struct{}
+}
+
+func New(roles map[string][]string) *RBAC {
+	r := &RBAC{roles: make(map[string]map[string]struct{}, len(roles))}
+	for role, perms := range roles {
+		set := make(map[string]struct{}, len(perms))
+		for _, p := range perms {
+			set[p] = struct{}{}
+		}
+		r.roles[role] = set
+	}
+	return r
+}
+
+// 内置角色加上admin.roles配置，同名的以配置为准
+func NewFromConf(conf *viper.Viper) *RBAC {
+	roles := make(map[string][]string, len(defaultRoles))
+	for k, v := range defaultRoles {
+		roles[k] = v
+	}
+	for k, v := range conf.GetStringMapStringSlice("admin.roles") {
+		roles[k] = v
+	}
+	return New(roles)
+}
+
+func (r *RBAC) HasRole(role string) bool {
+	_, ok := r.roles[role]
+	return ok
+}
+
+func (r *RBAC) Can(role, perm string) bool {
+	perms, ok := r.roles[role]
+	if !ok {
+		return false
+	}
+	if _, ok = perms[PermAll]; ok {
+		return true
+	}
+	_, ok = perms[perm]
+	return ok
+}
+
+// 角色的权限列表，按字母排序
+func (r *RBAC) Permissions(role string) []string {
+	perms := make([]string, 0, len(r.roles[role]))
+	for p := range r.roles[role] {
+		perms = append(perms, p)
+	}
+	sort.Strings(perms)
+	return perms
+}
+
+func (r *RBAC) Roles() []string {
+	roles := make([]string, 0, len(r.roles))
+	for role := range r.roles {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
@@ -0,0 +1,53 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// 追加写入文件，每条消息一行json，代替SMTP和短信网关用于测试，测试代码读取文件获取验证码和链接
+type fileSender struct {
+	path string
+	mu   sync.Mutex
+}
+
+type fileMessage struct {
+	Time    int64  `json:"time"`
+	Channel string `json:"channel"` //sms email
+	To      string `json:"to"`
+	Subject string `json:"subject,omitempty"`
+	Content string `json:"content"`
+}
+
+func NewFileSender(path string) Sender {
+	return &fileSender{path: path}
+}
+
+func (s *fileSender) SendSMS(ctx context.Context, phone, content string) error {
+	return s.write(fileMessage{Channel: "sms", To: phone, Content: content})
+}
+
+func (s *fileSender) SendEmail(ctx context.Context, email, subject, content string) error {
+	return s.write(fileMessage{Channel: "email", To: email, Subject: subject, Content: content})
+}
+
+func (s *fileSender) write(msg fileMessage) error {
+	msg.Time = time.Now().Unix()
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,68 @@
+package sender
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// 把短信和邮件POST给发送网关，由网关对接短信服务商和SMTP，请求体为httpMessage的JSON，返回200为成功
+type httpSender struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+type httpMessage struct {
+	Channel string `json:"channel"` //sms email
+	To      string `json:"to"`
+	Subject string `json:"subject,omitempty"`
+	Content string `json:"content"`
+}
+
+func NewHTTPSender(url, secret string, timeout time.Duration) Sender {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &httpSender{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *httpSender) SendSMS(ctx context.Context, phone, content string) error {
+	return s.post(ctx, httpMessage{Channel: "sms", To: phone, Content: content})
+}
+
+func (s *httpSender) SendEmail(ctx context.Context, email, subject, content string) error {
+	return s.post(ctx, httpMessage{Channel: "email", To: email, Subject: subject, Content: content})
+}
+
+func (s *httpSender) post(ctx context.Context, msg httpMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.secret != "" {
+		req.Header.Set("Authorization", "Bearer "+s.secret)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("sender: unexpected status %v", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package sender
+
+import (
+	"context"
+
+	"github.com/ljinf/im_server_standalone/pkg/log"
+	"go.uber.org/zap"
+)
+
+// 只打印日志不真正发送，用于本地开发和测试
+type logSender struct {
+	logger *log.Logger
+}
+
+func NewLogSender(logger *log.Logger) Sender {
+	return &logSender{logger: logger}
+}
+
+func (s *logSender) SendSMS(ctx context.Context, phone, content string) error {
+	s.logger.WithContext(ctx).Info("send sms", zap.String("phone", phone), zap.String("content", content))
+	return nil
+}
+
+func (s *logSender) SendEmail(ctx context.Context, email, subject, content string) error {
+	s.logger.WithContext(ctx).Info("send email", zap.String("email", email), zap.String("subject", subject),
+		zap.String("content", content))
+	return nil
+}
@@ -0,0 +1,44 @@
+package sender
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ljinf/im_server_standalone/pkg/log"
+	"github.com/spf13/viper"
+)
+
+// Sender 发送短信和邮件，具体实现由sender.driver配置决定
+type Sender interface {
+	SendSMS(ctx context.Context, phone, content string) error
+	SendEmail(ctx context.Context, email, subject, content string) error
+}
+
+// log和file会把验证码、重置链接等明文写入日志或文件，只允许在local、dev环境使用
+func NewSender(conf *viper.Viper, logger *log.Logger) (Sender, error) {
+	driver := conf.GetString("sender.driver")
+	if driver == "" || driver == "log" || driver == "file" {
+		if env := conf.GetString("env"); env != "local" && env != "dev" {
+			return nil, fmt.Errorf("sender: driver %q is not allowed in env %q, use http", driver, env)
+		}
+	}
+
+	switch driver {
+	case "", "log":
+		return NewLogSender(logger), nil
+	case "file":
+		path := conf.GetString("sender.file.path")
+		if path == "" {
+			return nil, fmt.Errorf("sender: sender.file.path is empty")
+		}
+		return NewFileSender(path), nil
+	case "http":
+		url := conf.GetString("sender.http.url")
+		if url == "" {
+			return nil, fmt.Errorf("sender: sender.http.url is empty")
+		}
+		return NewHTTPSender(url, conf.GetString("sender.http.secret"), conf.GetDuration("sender.http.timeout")), nil
+	default:
+		return nil, fmt.Errorf("sender: unsupported driver %v", driver)
+	}
+}
@@ -0,0 +1,96 @@
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var ErrInvalid = errors.New("token: invalid or expired")
+
+// Signer 签发一次性链接使用的token，如找回密码、邮箱验证。
+// 签名时绑定一个状态值(如当前密码哈希、邮箱)，状态变化后token自动失效，不需要服务端存储
+type Signer struct {
+	key []byte
+}
+
+// 密钥依次从环境变量SECURITY_TOKEN_KEY、security.token.key、security.token.key_file读取，
+// 生产环境不要把密钥写在配置文件中
+func NewSigner(conf *viper.Viper) (*Signer, error) {
+	key := os.Getenv("SECURITY_TOKEN_KEY")
+	if key == "" {
+		key = conf.GetString("security.token.key")
+	}
+	if key == "" {
+		if file := conf.GetString("security.token.key_file"); file != "" {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("token: read key file: %w", err)
+			}
+			key = strings.TrimSpace(string(data))
+		}
+	}
+	if key == "" {
+		return nil, errors.New("token: key is empty, set SECURITY_TOKEN_KEY or security.token.key_file")
+	}
+	return &Signer{key: []byte(key)}, nil
+}
+
+// token格式 base64(userId.过期时间).base64(签名)，purpose区分用途，防止不同用途的token混用
+func (s *Signer) Sign(purpose string, userId int64, state string, ttl time.Duration) string {
+	payload := fmt.Sprintf("%d.%d", userId, time.Now().Add(ttl).Unix())
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(s.mac(purpose, payload, state))
+}
+
+// Verify 校验签名和有效期，state根据token中的userId查询当前的状态值
+func (s *Signer) Verify(token, purpose string, state func(userId int64) (string, error)) (int64, error) {
+	encodedPayload, encodedMac, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, ErrInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return 0, ErrInvalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMac)
+	if err != nil {
+		return 0, ErrInvalid
+	}
+
+	userIdStr, expStr, ok := strings.Cut(string(payload), ".")
+	if !ok {
+		return 0, ErrInvalid
+	}
+	userId, err := strconv.ParseInt(userIdStr, 10, 64)
+	if err != nil {
+		return 0, ErrInvalid
+	}
+	exp, err := strconv.ParseInt(expStr, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return 0, ErrInvalid
+	}
+
+	current, err := state(userId)
+	if err != nil {
+		return 0, err
+	}
+	if !hmac.Equal(mac, s.mac(purpose, string(payload), current)) {
+		return 0, ErrInvalid
+	}
+	return userId, nil
+}
+
+func (s *Signer) mac(purpose, payload, state string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(purpose + "\n" + payload + "\n" + state))
+	return h.Sum(nil)
+}
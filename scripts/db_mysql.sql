@@ -12,12 +12,16 @@ CREATE TABLE `register`
     `phone`      varchar(11) DEFAULT NULL COMMENT '手机号',
     `email`      varchar(64) DEFAULT NULL COMMENT '邮箱',
     `password`   varchar(64) DEFAULT NULL COMMENT '密码',
+    `email_verified` tinyint(1) NOT NULL DEFAULT 0 COMMENT '邮箱已验证',
+    `phone_verified` tinyint(1) NOT NULL DEFAULT 0 COMMENT '手机号已验证',
     `created_at` datetime    DEFAULT NULL,
     `updated_at` datetime    DEFAULT NULL,
     `deleted_at` datetime    DEFAULT NULL,
     PRIMARY KEY (`id`),
     UNIQUE KEY `user` (`user_id`),
     KEY          user_phone_email_pass(`user_id`,`phone`,`email`,`password`),
+    KEY          `phone_idx` (`phone`),
+    KEY          `email_idx` (`email`),
     KEY          `deleted_idx` (`deleted_at`)
 ) ENGINE=InnoDB  DEFAULT CHARSET=utf8mb4 COMMENT '注册信息表';
 
@@ -32,15 +36,23 @@ CREATE TABLE `user_info`
     `gender`            tinyint(2) DEFAULT 3 COMMENT '性别 1男 2女 3未知',
     `birth_day`         varchar(50)  DEFAULT NULL COMMENT '生日',
     `self_signature`    varchar(255) DEFAULT NULL COMMENT '个性签名',
+    `region`            varchar(64)  DEFAULT NULL COMMENT '地区',
+    `signature_visibility` tinyint(2) NOT NULL DEFAULT '1' COMMENT '个性签名可见范围 1所有人 2仅好友 3仅自己',
+    `birthday_visibility`  tinyint(2) NOT NULL DEFAULT '1' COMMENT '生日可见范围',
+    `region_visibility`    tinyint(2) NOT NULL DEFAULT '1' COMMENT '地区可见范围',
     `friend_allow_type` int(10) NOT NULL DEFAULT '1' COMMENT '加好友验证类型（Friend_AllowType） 1无需验证 2需要验证',
     `silent_flag`       int(10) NOT NULL DEFAULT '0' COMMENT '禁言标识 1禁言',
+    `silent_until`      int(11) NOT NULL DEFAULT '0' COMMENT '禁言截止时间，0为永久',
     `status`            int(20) NOT NULL DEFAULT '1' COMMENT '用户状态  0:异常  1:正常',
+    `msg_allow_type`    tinyint(2) NOT NULL DEFAULT '0' COMMENT '谁可以给我发消息 0服务端配置 1所有人 2仅好友 3好友和关注我的人',
+    `discoverable`      tinyint(2) NOT NULL DEFAULT '7' COMMENT '允许被搜索的方式 1邮箱 2手机号 4昵称，按位组合',
     `created_at`        datetime     DEFAULT NULL,
     `updated_at`        datetime     DEFAULT NULL,
     `deleted_at`        datetime     DEFAULT NULL,
     PRIMARY KEY (`id`),
     UNIQUE KEY `user` (`user_id`),
     KEY                 user_phone_email_pass(`user_id`,`nick_name`,`avatar`,`gender`),
+    KEY                 `nick_name_idx` (`nick_name`),
     KEY                 `deleted_idx` (`deleted_at`)
 ) ENGINE=InnoDB  DEFAULT CHARSET=utf8mb4 COMMENT '用户信息表';
 
@@ -52,18 +64,68 @@ CREATE TABLE `relationship_list`
     `user_id`           BIGINT(20) UNSIGNED NOT NULL COMMENT '用户id 拥有者',
     `target_id`         BIGINT(20) UNSIGNED NOT NULL COMMENT '用户id 对方',
     `remark`            VARCHAR(64)  DEFAULT '' COMMENT '对方的别名备注',
-    `relationship_type` TINYINT(2) DEFAULT '1' COMMENT '关系类型  1好友 2关注',
+    `relationship_type` TINYINT(2) DEFAULT '1' COMMENT '关系类型  1好友 2关注 3黑名单',
     `status`            TINYINT(2) DEFAULT '1' COMMENT '状态 1正常 2拉黑 3删除',
     `extra`             VARCHAR(256) DEFAULT '' COMMENT '其他信息',
+    `version`           BIGINT(20) UNSIGNED NOT NULL DEFAULT '0' COMMENT '通讯录版本号',
     `created_at`        DATETIME     DEFAULT NULL,
     `updated_at`        DATETIME     DEFAULT NULL,
     `deleted_at`        DATETIME     DEFAULT NULL,
     PRIMARY KEY (`id`),
     UNIQUE KEY `user_target_relation_idx` (`user_id`,`target_id`,`relationship_type`),
+    KEY                 `target_relation_idx` (`target_id`,`relationship_type`,`status`),
+    KEY                 `user_version_idx` (`user_id`,`version`),
     KEY                 `updated_idx`(`updated_at`),
     KEY                 `deleted_idx` (`deleted_at`)
 ) ENGINE=INNODB  DEFAULT CHARSET=utf8mb4 COMMENT '关系信息表';
 
+DROP TABLE IF EXISTS `contact_version`;
+CREATE TABLE `contact_version`
+(
+    `user_id` BIGINT(20) UNSIGNED NOT NULL COMMENT '用户id',
+    `version` BIGINT(20) UNSIGNED NOT NULL DEFAULT '0' COMMENT '通讯录当前版本号',
+    PRIMARY KEY (`user_id`)
+) ENGINE=INNODB  DEFAULT CHARSET=utf8mb4 COMMENT '通讯录版本号';
+
+DROP TABLE IF EXISTS `relationship_tag`;
+CREATE TABLE `relationship_tag`
+(
+    `id`         BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+    `user_id`    BIGINT(20) UNSIGNED NOT NULL COMMENT '用户id 拥有者',
+    `name`       VARCHAR(32) NOT NULL COMMENT '标签名',
+    `created_at` DATETIME DEFAULT NULL,
+    `updated_at` DATETIME DEFAULT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `user_name_idx` (`user_id`,`name`)
+) ENGINE=INNODB  DEFAULT CHARSET=utf8mb4 COMMENT '好友分组标签';
+
+DROP TABLE IF EXISTS `relationship_tag_member`;
+CREATE TABLE `relationship_tag_member`
+(
+    `id`         BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+    `user_id`    BIGINT(20) UNSIGNED NOT NULL COMMENT '用户id 拥有者',
+    `tag_id`     BIGINT(20) UNSIGNED NOT NULL COMMENT '标签id',
+    `target_id`  BIGINT(20) UNSIGNED NOT NULL COMMENT '好友id',
+    `created_at` DATETIME DEFAULT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `tag_target_idx` (`tag_id`,`target_id`),
+    KEY          `user_target_idx` (`user_id`,`target_id`)
+) ENGINE=INNODB  DEFAULT CHARSET=utf8mb4 COMMENT '标签下的好友';
+
+DROP TABLE IF EXISTS `user_profile_field`;
+CREATE TABLE `user_profile_field`
+(
+    `id`         BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+    `user_id`    BIGINT(20) UNSIGNED NOT NULL COMMENT '用户id',
+    `field_key`  VARCHAR(32)  NOT NULL COMMENT '字段名，由profile.custom_fields配置',
+    `value`      VARCHAR(512) NOT NULL DEFAULT '' COMMENT '字段值',
+    `visibility` TINYINT(2)   NOT NULL DEFAULT '1' COMMENT '可见范围 1所有人 2仅好友 3仅自己',
+    `created_at` DATETIME DEFAULT NULL,
+    `updated_at` DATETIME DEFAULT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `user_key_idx` (`user_id`,`field_key`)
+) ENGINE=INNODB  DEFAULT CHARSET=utf8mb4 COMMENT '用户自定义资料字段';
+
 DROP TABLE IF EXISTS `apply_friendship_list`;
 CREATE TABLE `apply_friendship_list`
 (
@@ -92,10 +154,12 @@ CREATE TABLE `user_conversation_list`
     `last_read_seq`   bigint(20) unsigned DEFAULT 0 COMMENT '此会话用户已读的最后一条消息',
     `notify_type`     int(11) DEFAULT 0 COMMENT '会话收到消息的提醒类型，0未屏蔽，正常提醒 1屏蔽 2强提醒',
     `is_top`          tinyint(2) DEFAULT 0 COMMENT '会话是否被置顶展示 0否 1是',
+    `request_status`  tinyint(2) DEFAULT 0 COMMENT '消息请求状态 0正常会话 1陌生人消息待处理 2已忽略',
     `created_at`      int(11) NOT NULL DEFAULT 0,
     `updated_at`      int(11) NOT NULL DEFAULT 0,
     PRIMARY KEY (`id`),
-    UNIQUE KEY `user_conversation_idx` (`user_id`,`conversation_id`)
+    UNIQUE KEY `user_conversation_idx` (`user_id`,`conversation_id`),
+    KEY               `user_request_idx` (`user_id`,`request_status`,`updated_at`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='用户会话链';
 
 DROP TABLE IF EXISTS `user_msg_list`;
@@ -137,11 +201,25 @@ CREATE TABLE `conversation_list`
     `avatar`          varchar(256) DEFAULT '' COMMENT '群组头像',
     `announcement`    text COMMENT '群公告',
     `recent_msg_time` int(11) NOT NULL DEFAULT '0' COMMENT '此会话最新产生消息的时间',
+    `status`          tinyint(2) NOT NULL DEFAULT '0' COMMENT '会话状态 0正常 1已解散',
     `created_at`      int(11) NOT NULL DEFAULT '0',
     PRIMARY KEY (`id`),
-    KEY               conversation_idx(`conversation_id`)
+    KEY               conversation_idx(`conversation_id`),
+    KEY               type_idx(`type`,`created_at`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='会话表';
 
+DROP TABLE IF EXISTS `c2c_conversation`;
+CREATE TABLE `c2c_conversation`
+(
+    `id`              bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT '自增ID',
+    `min_user_id`     bigint(20) unsigned NOT NULL COMMENT '较小的用户ID',
+    `max_user_id`     bigint(20) unsigned NOT NULL COMMENT '较大的用户ID',
+    `conversation_id` varchar(64) NOT NULL COMMENT '会话ID',
+    `created_at`      int(11) NOT NULL DEFAULT '0',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `user_pair_idx` (`min_user_id`,`max_user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='单聊会话索引';
+
 DROP TABLE IF EXISTS `conversation_msg_list`;
 CREATE TABLE `conversation_msg_list`
 (
@@ -156,3 +234,89 @@ CREATE TABLE `conversation_msg_list`
 
 
 
+
+DROP TABLE IF EXISTS `moderation_audit`;
+CREATE TABLE `moderation_audit`
+(
+    `id`              bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT '自增ID',
+    `msg_id`          bigint(20) unsigned NOT NULL DEFAULT 0 COMMENT '消息ID，拒绝发送的为0',
+    `user_id`         bigint(20) unsigned NOT NULL COMMENT '发送者ID',
+    `target_id`       bigint(20) unsigned NOT NULL DEFAULT 0 COMMENT '接收者ID',
+    `conversation_id` varchar(64)  NOT NULL DEFAULT '' COMMENT '会话ID',
+    `moderator`       varchar(32)  NOT NULL DEFAULT '' COMMENT '做出决定的审核器 keyword regex webhook',
+    `rule`            varchar(255) NOT NULL DEFAULT '' COMMENT '命中的规则',
+    `action`          varchar(16)  NOT NULL COMMENT '审核结果 allow mask block reject',
+    `reason`          varchar(255) NOT NULL DEFAULT '' COMMENT '原因',
+    `content`         text COMMENT '原始内容',
+    `created_at`      int(11) NOT NULL DEFAULT '0',
+    PRIMARY KEY (`id`),
+    KEY               user_created_idx(`user_id`,`created_at`),
+    KEY               msg_idx(`msg_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='消息审核记录';
+
+
+DROP TABLE IF EXISTS `report`;
+CREATE TABLE `report`
+(
+    `id`               bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT '自增ID',
+    `reporter_id`      bigint(20) unsigned NOT NULL COMMENT '举报人ID',
+    `target_type`      tinyint(2) NOT NULL COMMENT '举报对象 1消息 2用户 3群组',
+    `target_id`        bigint(20) unsigned NOT NULL COMMENT '对象ID，消息ID、用户ID或群组会话ID',
+    `reported_user_id` bigint(20) unsigned NOT NULL DEFAULT 0 COMMENT '被举报的用户，举报消息时为发送者',
+    `reason`           tinyint(2) NOT NULL COMMENT '举报原因 1垃圾广告 2骚扰辱骂 3色情低俗 4违法违规 5诈骗 9其他',
+    `description`      varchar(500) NOT NULL DEFAULT '' COMMENT '补充说明',
+    `content`          text COMMENT '举报消息时的消息内容快照',
+    `status`           tinyint(2) NOT NULL DEFAULT 0 COMMENT '状态 0待处理 1已处理 2已驳回',
+    `action`           tinyint(2) NOT NULL DEFAULT 0 COMMENT '处理动作 0不处理 1屏蔽消息 2禁言 3封禁',
+    `handler_id`       bigint(20) unsigned NOT NULL DEFAULT 0 COMMENT '处理人ID',
+    `note`             varchar(500) NOT NULL DEFAULT '' COMMENT '处理备注，会通知举报人',
+    `created_at`       int(11) NOT NULL DEFAULT '0',
+    `handled_at`       int(11) NOT NULL DEFAULT '0',
+    PRIMARY KEY (`id`),
+    KEY               status_created_idx(`status`,`created_at`),
+    KEY               target_idx(`target_type`,`target_id`),
+    KEY               reporter_idx(`reporter_id`,`created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='举报';
+
+
+DROP TABLE IF EXISTS `admin_user`;
+CREATE TABLE `admin_user`
+(
+    `id`         bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT '自增ID',
+    `user_id`    bigint(20) unsigned NOT NULL COMMENT '用户ID',
+    `role`       varchar(32) NOT NULL COMMENT '角色 super operator auditor，或admin.roles中配置的角色',
+    `created_by` bigint(20) unsigned NOT NULL DEFAULT 0 COMMENT '分配角色的管理员',
+    `created_at` int(11) NOT NULL DEFAULT '0',
+    `updated_at` int(11) NOT NULL DEFAULT '0',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `user_idx` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='管理员';
+
+
+DROP TABLE IF EXISTS `admin_op_log`;
+CREATE TABLE `admin_op_log`
+(
+    `id`         bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT '自增ID',
+    `admin_id`   bigint(20) unsigned NOT NULL COMMENT '管理员用户ID',
+    `action`     varchar(32)  NOT NULL COMMENT '操作，对应权限名如user:ban',
+    `target_id`  bigint(20) unsigned NOT NULL DEFAULT 0 COMMENT '用户ID或会话ID',
+    `detail`     varchar(1024) NOT NULL DEFAULT '' COMMENT '操作参数，JSON',
+    `ip`         varchar(64)  NOT NULL DEFAULT '',
+    `created_at` int(11) NOT NULL DEFAULT '0',
+    PRIMARY KEY (`id`),
+    KEY          admin_created_idx(`admin_id`,`created_at`),
+    KEY          target_idx(`target_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='管理员操作记录';
+
+
+DROP TABLE IF EXISTS `announcement`;
+CREATE TABLE `announcement`
+(
+    `id`         bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT '自增ID',
+    `admin_id`   bigint(20) unsigned NOT NULL COMMENT '发布的管理员',
+    `title`      varchar(128) NOT NULL DEFAULT '' COMMENT '标题',
+    `content`    text COMMENT '内容',
+    `created_at` int(11) NOT NULL DEFAULT '0',
+    PRIMARY KEY (`id`),
+    KEY          created_idx(`created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='系统公告';
@@ -0,0 +1,23 @@
+-- 已部署的库升级单聊会话索引：建表后把已有的单聊会话写入c2c_conversation，
+-- 否则已有会话的两个用户再次发消息时会创建第二个会话。
+-- 同一对用户有多个单聊会话时保留最早创建的，按创建时间顺序插入，重复的由唯一索引忽略。
+-- 可重复执行。
+
+CREATE TABLE IF NOT EXISTS `c2c_conversation`
+(
+    `id`              bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT '自增ID',
+    `min_user_id`     bigint(20) unsigned NOT NULL COMMENT '较小的用户ID',
+    `max_user_id`     bigint(20) unsigned NOT NULL COMMENT '较大的用户ID',
+    `conversation_id` varchar(64) NOT NULL COMMENT '会话ID',
+    `created_at`      int(11) NOT NULL DEFAULT '0',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `user_pair_idx` (`min_user_id`,`max_user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='单聊会话索引';
+
+INSERT IGNORE INTO `c2c_conversation` (`min_user_id`, `max_user_id`, `conversation_id`, `created_at`)
+SELECT a.`user_id`, b.`user_id`, c.`conversation_id`, c.`created_at`
+FROM `conversation_list` c
+         JOIN `user_conversation_list` a ON a.`conversation_id` = c.`conversation_id`
+         JOIN `user_conversation_list` b ON b.`conversation_id` = c.`conversation_id` AND a.`user_id` < b.`user_id`
+WHERE c.`type` = 0
+ORDER BY c.`created_at`, c.`id`;
@@ -0,0 +1,53 @@
+-- 已部署的库升级通讯录增量同步：已有的关系version为0，而同步按version>?查询，
+-- 不回填的话全量同步(version=0)查不到任何已有联系人。
+-- 按用户给version为0的关系依次分配版本号，接在该用户当前版本号之后，再把contact_version更新为最大版本号。
+-- 需要MySQL 8(窗口函数)；回填期间关系变更可能与回填的版本号重复，请在停写时执行。
+-- 第一步的ALTER只在首次升级时执行，之后的语句可重复执行。
+
+ALTER TABLE `relationship_list`
+    ADD COLUMN `version` BIGINT(20) UNSIGNED NOT NULL DEFAULT '0' COMMENT '通讯录版本号' AFTER `extra`,
+    ADD KEY `user_version_idx` (`user_id`,`version`);
+
+CREATE TABLE IF NOT EXISTS `contact_version`
+(
+    `user_id` BIGINT(20) UNSIGNED NOT NULL COMMENT '用户id',
+    `version` BIGINT(20) UNSIGNED NOT NULL DEFAULT '0' COMMENT '通讯录当前版本号',
+    PRIMARY KEY (`user_id`)
+) ENGINE=INNODB  DEFAULT CHARSET=utf8mb4 COMMENT '通讯录版本号';
+
+CREATE TABLE IF NOT EXISTS `relationship_tag`
+(
+    `id`         BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+    `user_id`    BIGINT(20) UNSIGNED NOT NULL COMMENT '用户id 拥有者',
+    `name`       VARCHAR(32) NOT NULL COMMENT '标签名',
+    `created_at` DATETIME DEFAULT NULL,
+    `updated_at` DATETIME DEFAULT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `user_name_idx` (`user_id`,`name`)
+) ENGINE=INNODB  DEFAULT CHARSET=utf8mb4 COMMENT '好友分组标签';
+
+CREATE TABLE IF NOT EXISTS `relationship_tag_member`
+(
+    `id`         BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+    `user_id`    BIGINT(20) UNSIGNED NOT NULL COMMENT '用户id 拥有者',
+    `tag_id`     BIGINT(20) UNSIGNED NOT NULL COMMENT '标签id',
+    `target_id`  BIGINT(20) UNSIGNED NOT NULL COMMENT '好友id',
+    `created_at` DATETIME DEFAULT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `tag_target_idx` (`tag_id`,`target_id`),
+    KEY          `user_target_idx` (`user_id`,`target_id`)
+) ENGINE=INNODB  DEFAULT CHARSET=utf8mb4 COMMENT '标签下的好友';
+
+-- 已删除的关系也分配版本号，增量同步时客户端据此删除本地记录
+UPDATE `relationship_list` r
+    JOIN (SELECT `id`, ROW_NUMBER() OVER (PARTITION BY `user_id` ORDER BY `id`) AS `rn`
+          FROM `relationship_list`
+          WHERE `version` = 0) t ON t.`id` = r.`id`
+    LEFT JOIN `contact_version` cv ON cv.`user_id` = r.`user_id`
+SET r.`version` = IFNULL(cv.`version`, 0) + t.`rn`;
+
+INSERT INTO `contact_version` (`user_id`, `version`)
+SELECT `user_id`, MAX(`version`)
+FROM `relationship_list`
+GROUP BY `user_id`
+ON DUPLICATE KEY UPDATE `version` = GREATEST(`contact_version`.`version`, VALUES(`version`));